@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type testParties struct {
+	target, monitor, stranger *Identity
+	// the monitor's trust store, which knows the target
+	store *TrustStore
+}
+
+func newTestParties(t *testing.T) *testParties {
+	t.Helper()
+	var ps testParties
+	for _, p := range []struct {
+		id   **Identity
+		name string
+	}{{&ps.target, "target"}, {&ps.monitor, "monitor"}, {&ps.stranger, "stranger"}} {
+		id, err := NewIdentity(p.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		*p.id = id
+	}
+	ps.store = NewTrustStore()
+	if err := ps.store.Add(ps.target.ID, Target, ps.target.Public()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.store.Add(ps.monitor.ID, Monitor, ps.monitor.Public()); err != nil {
+		t.Fatal(err)
+	}
+	return &ps
+}
+
+func TestSignVerify(t *testing.T) {
+
+	ps := newTestParties(t)
+	payload := []byte("encoded query")
+	data, err := ps.target.Sign(KindQuery, ps.monitor.ID, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := ps.store.Verify(data, KindQuery, ps.monitor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Sender != ps.target.ID || string(msg.Payload) != string(payload) || msg.SignedAt == 0 {
+		t.Fatalf("verified %+v", msg)
+	}
+
+	if _, err := ps.target.Sign(Kind("ping"), ps.monitor.ID, payload); err == nil {
+		t.Fatal("signed a message of an unknown kind")
+	}
+}
+
+// A signed message must be rejected when any of its signed fields is changed,
+// when it is checked as another kind or by another recipient, and when its
+// sender is unknown or signs messages that its role does not send
+func TestVerifyRejects(t *testing.T) {
+
+	ps := newTestParties(t)
+	query, err := ps.target.Sign(KindQuery, ps.monitor.ID, []byte("encoded query"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := func(tamper func(msg *SignedMessage)) []byte {
+		var msg SignedMessage
+		if err := json.Unmarshal(query, &msg); err != nil {
+			t.Fatal(err)
+		}
+		tamper(&msg)
+		data, err := json.Marshal(&msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	sign := func(id *Identity, kind Kind) []byte {
+		data, err := id.Sign(kind, ps.monitor.ID, []byte("encoded message"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	for _, c := range []struct {
+		name      string
+		data      []byte
+		kind      Kind
+		recipient string
+	}{
+		{"wrong kind", query, KindQueryDelta, ps.monitor.ID},
+		{"wrong recipient", query, KindQuery, "other monitor"},
+		{"unknown kind", query, Kind("ping"), ps.monitor.ID},
+		{"tampered payload", tampered(func(msg *SignedMessage) { msg.Payload[0] ^= 1 }), KindQuery, ps.monitor.ID},
+		{"tampered time", tampered(func(msg *SignedMessage) { msg.SignedAt++ }), KindQuery, ps.monitor.ID},
+		{"relabelled kind", tampered(func(msg *SignedMessage) { msg.Kind = KindRevocation }), KindRevocation, ps.monitor.ID},
+		{"readdressed", tampered(func(msg *SignedMessage) { msg.Recipient = "other monitor" }), KindQuery, "other monitor"},
+		{"other sender", tampered(func(msg *SignedMessage) { msg.Sender = ps.monitor.ID }), KindQuery, ps.monitor.ID},
+		{"no signature", tampered(func(msg *SignedMessage) { msg.Signature = nil }), KindQuery, ps.monitor.ID},
+		{"unknown sender", sign(ps.stranger, KindQuery), KindQuery, ps.monitor.ID},
+		{"monitor sends query", sign(ps.monitor, KindQuery), KindQuery, ps.monitor.ID},
+		{"target sends response", sign(ps.target, KindResponse), KindResponse, ps.monitor.ID},
+		{"not JSON", []byte("query"), KindQuery, ps.monitor.ID},
+		{"too large", make([]byte, MaxSignedMessageSize+1), KindQuery, ps.monitor.ID},
+	} {
+		if _, err := ps.store.Verify(c.data, c.kind, c.recipient); err == nil {
+			t.Errorf("%s: verified", c.name)
+		}
+	}
+
+	// once removed, the target is a stranger
+	ps.store.Remove(ps.target.ID)
+	if _, err := ps.store.Verify(query, KindQuery, ps.monitor.ID); err == nil {
+		t.Error("verified a message of a removed sender")
+	}
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+// Role says which messages a peer may sign: targets send queries, query
+// deltas and revocations, monitors send responses
+type Role string
+
+const (
+	Target  Role = "target"
+	Monitor Role = "monitor"
+)
+
+// TrustStore maps the IDs of known peers to their role and verification key.
+// It is safe for concurrent use.
+type TrustStore struct {
+	mu      sync.RWMutex
+	entries map[string]trustEntry
+}
+
+type trustEntry struct {
+	role Role
+	key  *ecdsa.PublicKey
+}
+
+// The stored form of an entry; Key is the PKIX encoding
+type trustEntryJSON struct {
+	ID   string
+	Role Role
+	Key  []byte
+}
+
+func NewTrustStore() *TrustStore {
+	return &TrustStore{entries: make(map[string]trustEntry)}
+}
+
+// This function trusts key for the peer id in the given role. A peer that is
+// already known with another role or key must be removed first, so that a key
+// is never replaced by accident.
+func (ts *TrustStore) Add(id string, role Role, key *ecdsa.PublicKey) error {
+
+	if id == "" {
+		return errors.New("empty identity")
+	}
+	if role != Target && role != Monitor {
+		return errors.New("unknown role " + string(role))
+	}
+	if key == nil || key.Curve == nil {
+		return errors.New("missing verification key")
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if old, ok := ts.entries[id]; ok {
+		if old.role == role && old.key.Equal(key) {
+			return nil
+		}
+		return errors.New("identity " + id + " is already trusted with another role or key")
+	}
+	ts.entries[id] = trustEntry{role, key}
+	return nil
+}
+
+// This function stops trusting the peer id
+func (ts *TrustStore) Remove(id string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	delete(ts.entries, id)
+}
+
+// This function returns the role and verification key of the peer id
+func (ts *TrustStore) Lookup(id string) (Role, *ecdsa.PublicKey, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	entry, ok := ts.entries[id]
+	return entry.role, entry.key, ok
+}
+
+// This function returns the IDs of all trusted peers in order
+func (ts *TrustStore) IDs() []string {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	ids := make([]string, 0, len(ts.entries))
+	for id := range ts.entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (ts *TrustStore) MarshalJSON() ([]byte, error) {
+	ids := ts.IDs()
+	stored := make([]trustEntryJSON, 0, len(ids))
+	for _, id := range ids {
+		role, key, ok := ts.Lookup(id)
+		if !ok {
+			continue
+		}
+		enc, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+		stored = append(stored, trustEntryJSON{id, role, enc})
+	}
+	return json.Marshal(stored)
+}
+
+func (ts *TrustStore) UnmarshalJSON(data []byte) error {
+	var stored []trustEntryJSON
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	loaded := NewTrustStore()
+	for _, entry := range stored {
+		key, err := parseVerificationKey(entry.Key)
+		if err != nil {
+			return err
+		}
+		if err := loaded.Add(entry.ID, entry.Role, key); err != nil {
+			return err
+		}
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.entries = loaded.entries
+	return nil
+}
+
+// This function reads a trust store saved by Save
+func LoadTrustStore(path string) (*TrustStore, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ts := NewTrustStore()
+	if err := json.Unmarshal(data, ts); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// This function writes the trust store to path as JSON
+func (ts *TrustStore) Save(path string) error {
+	data, err := json.MarshalIndent(ts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
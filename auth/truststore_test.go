@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIdentityPEMRoundTrip(t *testing.T) {
+
+	id, err := NewIdentity("target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := id.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseIdentityPEM(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.ID != id.ID || !parsed.Key.Equal(id.Key) {
+		t.Fatal("identity changed in the PEM round trip")
+	}
+
+	vk, err := MarshalVerificationKeyPEM(id.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseVerificationKeyPEM(vk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(id.Public()) || Fingerprint(key) != Fingerprint(id.Public()) {
+		t.Fatal("verification key changed in the PEM round trip")
+	}
+
+	// a private key is not a verification key, and the other way round
+	if _, err := ParseVerificationKeyPEM(data); err == nil {
+		t.Error("parsed an identity as a verification key")
+	}
+	if _, err := ParseIdentityPEM(vk); err == nil {
+		t.Error("parsed a verification key as an identity")
+	}
+	if _, err := ParseIdentityPEM([]byte("target")); err == nil {
+		t.Error("parsed an identity without a PEM block")
+	}
+}
+
+func TestTrustStoreRoundTrip(t *testing.T) {
+
+	ps := newTestParties(t)
+	data, err := json.Marshal(ps.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewTrustStore()
+	if err := json.Unmarshal(data, loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "trust.json")
+	if err := ps.store.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := LoadTrustStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ts := range []*TrustStore{loaded, saved} {
+		if !reflect.DeepEqual(ts.IDs(), []string{ps.monitor.ID, ps.target.ID}) {
+			t.Fatalf("round trip kept %v", ts.IDs())
+		}
+		for _, p := range []struct {
+			id   *Identity
+			role Role
+		}{{ps.target, Target}, {ps.monitor, Monitor}} {
+			role, key, ok := ts.Lookup(p.id.ID)
+			if !ok || role != p.role || !key.Equal(p.id.Public()) {
+				t.Fatalf("round trip changed %s", p.id.ID)
+			}
+		}
+		query, err := ps.target.Sign(KindQuery, ps.monitor.ID, []byte("encoded query"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ts.Verify(query, KindQuery, ps.monitor.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// A known peer keeps its role and key until it is removed
+func TestTrustStoreAdd(t *testing.T) {
+
+	ps := newTestParties(t)
+	if err := ps.store.Add(ps.target.ID, Target, ps.target.Public()); err != nil {
+		t.Fatalf("adding the same entry again: %v", err)
+	}
+	for _, c := range []struct {
+		name string
+		id   string
+		role Role
+		key  *Identity
+	}{
+		{"other key", ps.target.ID, Target, ps.stranger},
+		{"other role", ps.target.ID, Monitor, ps.target},
+		{"unknown role", ps.stranger.ID, Role("observer"), ps.stranger},
+		{"empty ID", "", Target, ps.stranger},
+	} {
+		if err := ps.store.Add(c.id, c.role, c.key.Public()); err == nil {
+			t.Errorf("%s: added", c.name)
+		}
+	}
+	if err := ps.store.Add(ps.stranger.ID, Target, nil); err == nil {
+		t.Error("added a peer without a key")
+	}
+	if role, key, _ := ps.store.Lookup(ps.target.ID); role != Target || !key.Equal(ps.target.Public()) {
+		t.Fatal("a rejected Add changed the entry")
+	}
+
+	// a broken entry leaves the store as it was
+	if err := json.Unmarshal([]byte(`[{"ID":"x","Role":"target","Key":"AAAA"}]`), ps.store); err == nil {
+		t.Fatal("loaded an entry with an invalid key")
+	}
+	if len(ps.store.IDs()) != 2 {
+		t.Fatalf("failed load left %v", ps.store.IDs())
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/willf/bitset"
 	"crypto/sha1"
 	"math/big"
+	"sort"
 )
 
 type BloomFilter struct {
@@ -66,6 +67,25 @@ func (f *BloomFilter) Test(data []byte) bool {
 	return true
 }
 
+// Locations returns the distinct positions that Add sets for data, in
+// ascending order
+func (f *BloomFilter) Locations(data []byte) []uint {
+	locs := make([]uint, 0, f.k)
+	for i := uint(0); i < f.k; i++ {
+		seed := uint(big.NewInt(0).SetBytes(data).Uint64())
+		locs = append(locs, f.location(data, i+seed))
+	}
+	sort.Slice(locs, func(i, j int) bool { return locs[i] < locs[j] })
+
+	distinct := locs[:0]
+	for _, loc := range locs {
+		if len(distinct) == 0 || loc != distinct[len(distinct)-1] {
+			distinct = append(distinct, loc)
+		}
+	}
+	return distinct
+}
+
 func (f *BloomFilter) TestLocations(locs []uint64) bool {
 	for i := 0; i < len(locs); i++ {
 		if !f.b.Test(uint(locs[i] % uint64(f.m))) {
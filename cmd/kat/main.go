@@ -0,0 +1,438 @@
+// Command kat writes and checks known-answer vectors for one protocol run.
+//
+//	kat gen   -seed <hex> [-group P-256] [-BFLength 64] ... -out vector.json
+//	kat check vector.json [more.json ...]
+//
+// gen derives the target key from the seed with elgamal.KeyGenFromSeed and
+// drives the target and the monitor with seeded randomness sources, so that
+// every value in the file is a function of the seed and the parameters:
+//
+//	KeySeed     = seed
+//	TargetSeed  = seed || "/target"   (elgamal.NewSeededReader, target key)
+//	MonitorSeed = seed || "/monitor"  (elgamal.NewSeededReader, received key)
+//
+// Byte fields use the encodings of the wire messages (base64 in JSON). check
+// first validates a vector on its own, which is what a client written in
+// another language has to pass: the keys match, the ZKPs verify, C1 decrypts
+// to zero and decrypting Z1 and Z2 gives the recorded result. It then replays
+// the run from the seeds and compares every field byte for byte.
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+
+	bloom "bhwmonitoring-go/bloom"
+	elgamal "bhwmonitoring-go/elgamal"
+	pcr "bhwmonitoring-go/pcr"
+)
+
+// Version 1 vectors pinned Z1 and Z2 of responses whose c2 lacked the
+// partial sums over the positions of the password, which a goroutine added
+// after the response was returned. Version 2 vectors pin the full sum; the
+// randomness is drawn in the same order. Version 3 vectors no longer draw a
+// zero-encryption randomness per thread of NumThreads, which changes Z1 and
+// Z2.
+const vectorVersion = 3
+
+// Vector is the content of a known-answer file
+type Vector struct {
+	Version int
+
+	Group            string
+	BfLength         int
+	BfNumOnes        int
+	NumHashFuncs     int
+	NumThreads       int
+	PointCompression bool
+	TargetPassword   string
+	MonitorInput     string
+
+	KeySeed     []byte
+	TargetSeed  []byte
+	MonitorSeed []byte
+
+	PublicKey []byte
+	SecretKey []byte
+	// '0' or '1' for every position of the target's Bloom filter
+	BloomFilter string
+
+	EBF       []*elgamal.CiphertextByte
+	ZKPs      []*elgamal.ZKPByte
+	Challenge []byte
+	C1        *elgamal.CiphertextByte
+	Z1        *elgamal.CiphertextByte
+	Z2        *elgamal.CiphertextByte
+
+	Success bool
+	Result  string
+}
+
+func main() {
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "gen":
+		err = gen(os.Args[2:])
+	case "check":
+		err = check(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(-1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: kat gen -seed <hex> [flags] | kat check <file>...")
+	os.Exit(2)
+}
+
+// This function runs the protocol from a seed and writes the vector
+func gen(args []string) error {
+
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	seedHex := fs.String("seed", "", "hex seed of at least 16 bytes")
+	group := fs.String("group", "P-256", "P-224, P-256, P-384, P-521 or ristretto255")
+	bfLength := fs.Int("BFLength", 64, "an int")
+	bfNumOnes := fs.Int("numOnes", 16, "an int")
+	numHashFuncs := fs.Int("numHFs", 4, "an int")
+	numThreads := fs.Int("numThreads", 4, "an int")
+	pointCompression := fs.Bool("enablePC", true, "true or false")
+	targetPassword := fs.String("targetPassword", "Simba", "a string")
+	monitorInput := fs.String("monitorInput", "Simba", "a string")
+	out := fs.String("out", "", "output file, stdout if empty")
+	fs.Parse(args)
+
+	seed, err := hex.DecodeString(*seedHex)
+	if err != nil {
+		return err
+	}
+	if len(seed) < elgamal.MinSeedLength {
+		return errors.New("-seed must be at least 16 bytes of hex")
+	}
+
+	v := &Vector{
+		Version:          vectorVersion,
+		Group:            *group,
+		BfLength:         *bfLength,
+		BfNumOnes:        *bfNumOnes,
+		NumHashFuncs:     *numHashFuncs,
+		NumThreads:       *numThreads,
+		PointCompression: *pointCompression,
+		TargetPassword:   *targetPassword,
+		MonitorInput:     *monitorInput,
+		KeySeed:          seed,
+		TargetSeed:       append(append([]byte{}, seed...), "/target"...),
+		MonitorSeed:      append(append([]byte{}, seed...), "/monitor"...),
+	}
+	if err := run(v); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if *out == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(*out, data, 0644)
+}
+
+// This function fills in every output field of v from its parameters and seeds
+func run(v *Vector) error {
+
+	group, err := elgamal.GroupByName(v.Group)
+	if err != nil {
+		return err
+	}
+	if err := checkParams(v); err != nil {
+		return err
+	}
+
+	/*  Target: key, Bloom filter and query */
+	pk, sk, err := elgamal.KeyGenFromSeed(group, v.KeySeed, v.PointCompression)
+	if err != nil {
+		return err
+	}
+	pk.SetRandomSource(elgamal.NewSeededReader(v.TargetSeed))
+	reqPara := newReqPara(v, group.Name(), pk.SecParam)
+
+	bf := pcr.ReqBFGen(pk, reqPara, v.TargetPassword)
+	queryMessage := pcr.QueryGen(pk, reqPara, bf)
+
+	/*  Monitor: deployment and response, after the wire round trip */
+	rcvQueryMessage, err := pcr.DecodeQuery(pcr.EncodeQuery(queryMessage))
+	if err != nil {
+		return err
+	}
+	rcvQueryMessage.PK.SetRandomSource(elgamal.NewSeededReader(v.MonitorSeed))
+	queryMessagePlus := pcr.RespDeployment(rcvQueryMessage)
+	responseMessage := pcr.ResponseGen(nil, queryMessagePlus, v.MonitorInput)
+
+	/*  Target: decryption */
+	rcvResponseMessage, err := pcr.DecodeResponse(pcr.EncodeResponse(responseMessage))
+	if err != nil {
+		return err
+	}
+	result := pcr.ResponseDecrypt(pk, sk, reqPara, rcvResponseMessage, bf)
+
+	if v.PublicKey, err = pk.MarshalBinary(); err != nil {
+		return err
+	}
+	if v.SecretKey, err = sk.MarshalBinary(); err != nil {
+		return err
+	}
+	v.BloomFilter = bloomBits(bf)
+	v.EBF = queryMessage.EBF
+	v.ZKPs = queryMessage.ZKPs
+	v.Challenge = queryMessage.Challenge
+	v.C1 = queryMessagePlus.C1
+	v.Z1 = responseMessage.Z1
+	v.Z2 = responseMessage.Z2
+	v.Success = result.Positive()
+	v.Result = string(result.Revealed)
+	return nil
+}
+
+// This function checks every file named in args
+func check(args []string) error {
+
+	if len(args) == 0 {
+		usage()
+	}
+
+	failed := 0
+	for _, path := range args {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var v Vector
+		if err := json.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		if err := validate(&v); err != nil {
+			fmt.Printf("%s: FAIL: %v\n", path, err)
+			failed++
+			continue
+		}
+		if mismatches := replay(&v); len(mismatches) > 0 {
+			fmt.Printf("%s: FAIL: replay differs in %s\n", path, strings.Join(mismatches, ", "))
+			failed++
+			continue
+		}
+		fmt.Printf("%s: ok\n", path)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d vectors failed", failed, len(args))
+	}
+	return nil
+}
+
+// This function checks that the recorded values are consistent with each
+// other, without using the seeds
+func validate(v *Vector) error {
+
+	if v.Version != vectorVersion {
+		return fmt.Errorf("unsupported vector version %d", v.Version)
+	}
+	if err := checkParams(v); err != nil {
+		return err
+	}
+
+	pk, err := elgamal.ParsePublicKey(v.PublicKey)
+	if err != nil {
+		return fmt.Errorf("PublicKey: %v", err)
+	}
+	pk.PointCompression = v.PointCompression
+	sk, err := elgamal.ParseSecretKey(v.SecretKey)
+	if err != nil {
+		return fmt.Errorf("SecretKey: %v", err)
+	}
+	if sk.Fingerprint() != pk.Fingerprint() {
+		return errors.New("SecretKey does not match PublicKey")
+	}
+	if pk.Group().Name() != v.Group {
+		return errors.New("PublicKey is not in " + v.Group)
+	}
+
+	bf, err := parseBloomBits(v.BloomFilter, v.NumHashFuncs)
+	if err != nil {
+		return err
+	}
+	if bf.Cap() != uint(v.BfLength) || pcr.GetBFNumOnes(bf) != v.BfNumOnes {
+		return errors.New("BloomFilter does not match BfLength and BfNumOnes")
+	}
+	if !bf.Test(elgamal.HashSha256([]byte(v.TargetPassword))) {
+		return errors.New("BloomFilter does not contain TargetPassword")
+	}
+
+	// the query must pass the monitor's checks, and its EBF must encrypt the
+	// filter bits as +1 and -1
+	queryMessage := &pcr.QueryMessage{
+		BfLength:         v.BfLength,
+		BfNumOnes:        v.BfNumOnes,
+		NumHashFuncs:     v.NumHashFuncs,
+		NumThreads:       v.NumThreads,
+		PointCompression: v.PointCompression,
+		PK:               pk,
+		EBF:              v.EBF,
+		ZKPs:             v.ZKPs,
+		Challenge:        v.Challenge,
+	}
+	rcvQueryMessage, err := pcr.DecodeQuery(pcr.EncodeQuery(queryMessage))
+	if err != nil {
+		return fmt.Errorf("query: %v", err)
+	}
+	ebf := make([]*elgamal.Ciphertext, len(v.EBF))
+	zkps := make([]*elgamal.ZKP, len(v.ZKPs))
+	for i := range v.EBF {
+		if ebf[i], err = pk.Bytes2Ciphertext(v.EBF[i], v.PointCompression); err != nil {
+			return fmt.Errorf("EBF[%d]: %v", i, err)
+		}
+		if zkps[i], err = pk.Bytes2ZKP(v.ZKPs[i], v.PointCompression); err != nil {
+			return fmt.Errorf("ZKPs[%d]: %v", i, err)
+		}
+	}
+	if !rcvQueryMessage.PK.VerifySeqZKP(ebf, zkps, big.NewInt(0).SetBytes(v.Challenge), v.NumThreads) {
+		return errors.New("ZKPs do not verify")
+	}
+	for i := range ebf {
+		bit := big.NewInt(-1)
+		if v.BloomFilter[i] == '1' {
+			bit = big.NewInt(1)
+		}
+		bit.Mod(bit, pk.Group().Order())
+		if !sk.DecryptAndCheck(ebf[i], bit.Bytes()) {
+			return fmt.Errorf("EBF[%d] does not encrypt bit %c", i, v.BloomFilter[i])
+		}
+	}
+
+	c1, err := pk.Bytes2Ciphertext(v.C1, v.PointCompression)
+	if err != nil {
+		return fmt.Errorf("C1: %v", err)
+	}
+	if !sk.DecryptAndCheck0(c1) {
+		return errors.New("C1 does not encrypt zero")
+	}
+
+	reqPara := newReqPara(v, v.Group, pk.SecParam)
+	responseMessage := &pcr.ResponseMessage{Z1: v.Z1, Z2: v.Z2}
+	result := pcr.ResponseDecrypt(pk, sk, reqPara, responseMessage, bf)
+	if result.Positive() != v.Success || string(result.Revealed) != v.Result {
+		return fmt.Errorf("Z1 and Z2 decrypt to %v (%q), not (%v, %q)", result.Outcome, result.Revealed, v.Success, v.Result)
+	}
+	return nil
+}
+
+// This function reruns the vector from its seeds and returns the names of the
+// fields that differ
+func replay(v *Vector) []string {
+
+	w := &Vector{
+		Version:          v.Version,
+		Group:            v.Group,
+		BfLength:         v.BfLength,
+		BfNumOnes:        v.BfNumOnes,
+		NumHashFuncs:     v.NumHashFuncs,
+		NumThreads:       v.NumThreads,
+		PointCompression: v.PointCompression,
+		TargetPassword:   v.TargetPassword,
+		MonitorInput:     v.MonitorInput,
+		KeySeed:          v.KeySeed,
+		TargetSeed:       v.TargetSeed,
+		MonitorSeed:      v.MonitorSeed,
+	}
+	if err := run(w); err != nil {
+		return []string{err.Error()}
+	}
+
+	var mismatches []string
+	field := func(name string, a, b interface{}) {
+		ja, _ := json.Marshal(a)
+		jb, _ := json.Marshal(b)
+		if !bytes.Equal(ja, jb) {
+			mismatches = append(mismatches, name)
+		}
+	}
+	field("PublicKey", v.PublicKey, w.PublicKey)
+	field("SecretKey", v.SecretKey, w.SecretKey)
+	field("BloomFilter", v.BloomFilter, w.BloomFilter)
+	field("EBF", v.EBF, w.EBF)
+	field("ZKPs", v.ZKPs, w.ZKPs)
+	field("Challenge", v.Challenge, w.Challenge)
+	field("C1", v.C1, w.C1)
+	field("Z1", v.Z1, w.Z1)
+	field("Z2", v.Z2, w.Z2)
+	field("Success", v.Success, w.Success)
+	field("Result", v.Result, w.Result)
+	return mismatches
+}
+
+// This function rejects parameters that would make the protocol exit
+func checkParams(v *Vector) error {
+	if v.BfLength < 1 || v.BfNumOnes < 0 || v.BfNumOnes > v.BfLength ||
+		v.NumHashFuncs < 1 || v.NumHashFuncs > v.BfLength ||
+		v.NumThreads < 1 || v.NumThreads > v.BfLength {
+		return errors.New("invalid Bloom filter or thread parameters")
+	}
+	return nil
+}
+
+func newReqPara(v *Vector, group string, secParam int) *pcr.ReqPara {
+	return &pcr.ReqPara{
+		Group:            group,
+		Params:           secParam,
+		BfLength:         v.BfLength,
+		BfNumOnes:        v.BfNumOnes,
+		NumHashFuncs:     v.NumHashFuncs,
+		NumThreads:       v.NumThreads,
+		PointCompression: v.PointCompression,
+	}
+}
+
+func bloomBits(bf *bloom.BloomFilter) string {
+	var b strings.Builder
+	for i := uint(0); i < bf.Cap(); i++ {
+		if bf.TestLocations([]uint64{uint64(i)}) {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+func parseBloomBits(bits string, numHashFuncs int) (*bloom.BloomFilter, error) {
+	bf := bloom.New(uint(len(bits)), uint(numHashFuncs))
+	for i, c := range bits {
+		switch c {
+		case '1':
+			bf.BitSet().Set(uint(i))
+		case '0':
+		default:
+			return nil, errors.New("BloomFilter must only hold 0 and 1")
+		}
+	}
+	return bf, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Every vector in testdata/kat has to validate and replay byte for byte, so
+// that a change of the wire format or of the randomness order fails go test
+func TestVectors(t *testing.T) {
+
+	paths, err := filepath.Glob(filepath.Join("..", "..", "testdata", "kat", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no vectors in testdata/kat")
+	}
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var v Vector
+			if err := json.Unmarshal(data, &v); err != nil {
+				t.Fatal(err)
+			}
+			if err := validate(&v); err != nil {
+				t.Fatal(err)
+			}
+			if mismatches := replay(&v); len(mismatches) > 0 {
+				t.Fatalf("replay differs in %s", strings.Join(mismatches, ", "))
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Command keyd holds a target's secret key and answers decryption requests
+// from the service that receives monitor responses over a Unix socket.
+//
+//	keyd -key target.key -socket /run/bhw/keyd.sock [-passFile pass.txt]
+//
+// The key file is the one written by keytool. Without -passFile the
+// passphrase is prompted for on stderr and read as one line from stdin.
+// Connect to the daemon with keyserver.Dial and pass the client to
+// pcr.ResponseDecryptWith.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+
+	elgamal "bhwmonitoring-go/elgamal"
+	kdf "bhwmonitoring-go/internal/kdf"
+	keyserver "bhwmonitoring-go/keyserver"
+)
+
+func main() {
+
+	keyPath := flag.String("key", "", "encrypted secret key file")
+	socketPath := flag.String("socket", "", "Unix socket to listen on")
+	passFile := flag.String("passFile", "", "file holding the passphrase")
+	flag.Parse()
+
+	if *keyPath == "" || *socketPath == "" {
+		fmt.Fprintln(os.Stderr, "usage: keyd -key <file> -socket <path> [-passFile <file>]")
+		os.Exit(2)
+	}
+
+	sk, err := loadKey(*keyPath, *passFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(-1)
+	}
+	fmt.Fprintln(os.Stderr, "serving", sk.Fingerprint(), "on", *socketPath)
+
+	// remove the socket on shutdown so that clients fail fast
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		os.Remove(*socketPath)
+		sk.Wipe()
+		os.Exit(0)
+	}()
+
+	server := keyserver.NewServer(elgamal.NewLocalDecryptor(sk))
+	if err := server.ListenAndServe(*socketPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(-1)
+	}
+}
+
+// This function reads the passphrase and decrypts the key file
+func loadKey(keyPath, passFile string) (*elgamal.SecretKey, error) {
+
+	var line []byte
+	if passFile != "" {
+		data, err := ioutil.ReadFile(passFile)
+		if err != nil {
+			return nil, err
+		}
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = append([]byte{}, data[:i]...)
+		} else {
+			line = append([]byte{}, data...)
+		}
+		kdf.Wipe(data)
+	} else {
+		fmt.Fprint(os.Stderr, "Passphrase: ")
+		read, err := bufio.NewReader(os.Stdin).ReadBytes('\n')
+		if err != nil && len(read) == 0 {
+			return nil, err
+		}
+		line = append([]byte{}, bytes.TrimRight(read, "\n")...)
+		kdf.Wipe(read)
+	}
+	defer kdf.Wipe(line)
+
+	line = bytes.TrimRight(line, "\r")
+	if len(line) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	return elgamal.LoadSecretKey(keyPath, line)
+}
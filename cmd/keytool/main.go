@@ -0,0 +1,243 @@
+// Command keytool manages a target's passphrase-encrypted ElGamal key file.
+//
+//	keytool gen    -key target.key [-group P-256] [-pub target.pub]
+//	keytool passwd -key target.key
+//	keytool pub    -key target.key [-format pem|jwk]
+//
+// Passphrases are read from the files given with -passFile and -newPassFile,
+// or else prompted for on stderr and read as one line from stdin. Input is not
+// hidden from the terminal, so prefer the files or a pipe on shared machines.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	elgamal "bhwmonitoring-go/elgamal"
+	kdf "bhwmonitoring-go/internal/kdf"
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+func main() {
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "gen":
+		err = gen(os.Args[2:])
+	case "passwd":
+		err = passwd(os.Args[2:])
+	case "pub":
+		err = pub(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(-1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: keytool gen|passwd|pub -key <file> [flags]")
+	os.Exit(2)
+}
+
+// This function generates a key pair and saves the secret key encrypted
+func gen(args []string) error {
+
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	keyPath := fs.String("key", "", "encrypted secret key file to create")
+	groupName := fs.String("group", "P-256", "P-224, P-256, P-384, P-521 or ristretto255")
+	pubPath := fs.String("pub", "", "optional file for the PEM public key")
+	passFile := fs.String("passFile", "", "file holding the passphrase")
+	iterations := fs.Int("iterations", elgamal.DefaultKeyFileIterations, "PBKDF2 iterations")
+	fs.Parse(args)
+
+	if *keyPath == "" {
+		return errors.New("-key is required")
+	}
+	if _, err := os.Stat(*keyPath); err == nil {
+		return errors.New(*keyPath + " already exists")
+	}
+
+	group, err := elgamal.GroupByName(*groupName)
+	if err != nil {
+		return err
+	}
+	pk, sk, err := elgamal.KeyGenInGroup(group, true)
+	if err != nil {
+		return err
+	}
+	defer sk.Wipe()
+
+	passphrase, err := readNewPassphrase(*passFile)
+	if err != nil {
+		return err
+	}
+	defer kdf.Wipe(passphrase)
+
+	data, err := elgamal.EncryptSecretKeyWithIterations(sk, passphrase, *iterations)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(*keyPath, data, 0600); err != nil {
+		return err
+	}
+
+	if *pubPath != "" {
+		pemBytes, err := pk.MarshalPEM()
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(*pubPath, pemBytes, 0644); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println(pk.Fingerprint())
+	return nil
+}
+
+// This function re-encrypts a key file under a new passphrase
+func passwd(args []string) error {
+
+	fs := flag.NewFlagSet("passwd", flag.ExitOnError)
+	keyPath := fs.String("key", "", "encrypted secret key file")
+	passFile := fs.String("passFile", "", "file holding the current passphrase")
+	newPassFile := fs.String("newPassFile", "", "file holding the new passphrase")
+	fs.Parse(args)
+
+	if *keyPath == "" {
+		return errors.New("-key is required")
+	}
+
+	oldPassphrase, err := readPassphrase(*passFile, "Current passphrase: ")
+	if err != nil {
+		return err
+	}
+	defer kdf.Wipe(oldPassphrase)
+
+	// fail on a wrong passphrase before asking for the new one
+	sk, err := elgamal.LoadSecretKey(*keyPath, oldPassphrase)
+	if err != nil {
+		return err
+	}
+	defer sk.Wipe()
+
+	newPassphrase, err := readNewPassphrase(*newPassFile)
+	if err != nil {
+		return err
+	}
+	defer kdf.Wipe(newPassphrase)
+
+	if err := elgamal.SaveSecretKey(*keyPath, sk, newPassphrase); err != nil {
+		return err
+	}
+	fmt.Println(sk.Fingerprint())
+	return nil
+}
+
+// This function prints the public key of a key file
+func pub(args []string) error {
+
+	fs := flag.NewFlagSet("pub", flag.ExitOnError)
+	keyPath := fs.String("key", "", "encrypted secret key file")
+	passFile := fs.String("passFile", "", "file holding the passphrase")
+	format := fs.String("format", "pem", "pem or jwk")
+	fs.Parse(args)
+
+	if *keyPath == "" {
+		return errors.New("-key is required")
+	}
+
+	passphrase, err := readPassphrase(*passFile, "Passphrase: ")
+	if err != nil {
+		return err
+	}
+	defer kdf.Wipe(passphrase)
+
+	sk, err := elgamal.LoadSecretKey(*keyPath, passphrase)
+	if err != nil {
+		return err
+	}
+	defer sk.Wipe()
+
+	var out []byte
+	switch *format {
+	case "pem":
+		out, err = sk.PublicKey().MarshalPEM()
+	case "jwk":
+		out, err = sk.PublicKey().MarshalJWK()
+		out = append(out, '\n')
+	default:
+		err = errors.New("unknown format " + *format)
+	}
+	if err != nil {
+		return err
+	}
+	os.Stdout.Write(out)
+	return nil
+}
+
+// This function reads a new passphrase, asking twice when prompting
+func readNewPassphrase(path string) ([]byte, error) {
+	passphrase, err := readPassphrase(path, "New passphrase: ")
+	if err != nil || path != "" {
+		return passphrase, err
+	}
+	again, err := readPassphrase("", "Repeat new passphrase: ")
+	if err != nil {
+		kdf.Wipe(passphrase)
+		return nil, err
+	}
+	defer kdf.Wipe(again)
+	if !bytes.Equal(passphrase, again) {
+		kdf.Wipe(passphrase)
+		return nil, errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+// This function reads a passphrase from the first line of a file, or from
+// stdin after printing the prompt
+func readPassphrase(path, prompt string) ([]byte, error) {
+
+	var line []byte
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = append([]byte{}, data[:i]...)
+		} else {
+			line = append([]byte{}, data...)
+		}
+		kdf.Wipe(data)
+	} else {
+		fmt.Fprint(os.Stderr, prompt)
+		read, err := stdin.ReadBytes('\n')
+		if err != nil && len(read) == 0 {
+			return nil, err
+		}
+		line = append([]byte{}, bytes.TrimRight(read, "\n")...)
+		kdf.Wipe(read)
+	}
+
+	line = bytes.TrimRight(line, "\r")
+	if len(line) == 0 {
+		kdf.Wipe(line)
+		return nil, errors.New("empty passphrase")
+	}
+	return line, nil
+}
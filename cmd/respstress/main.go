@@ -0,0 +1,200 @@
+// Command respstress checks that responses stay correct when many of them are
+// generated at once. Run it under the race detector:
+//
+//	go run -race ./cmd/respstress [-group all] [-workers 64] [-concurrency 8] [-checks 10]
+//
+// For each group it deploys one query for the target password and lets
+// -concurrency goroutines check the target password and other passwords
+// against it, with plain and hybrid responses, on a worker pool of -workers
+// goroutines shared with a precomputation pool. Every check of the target
+// password has to come out positive and every other check negative. It then
+// replays one response from a fixed seed on a pool of one worker and on the
+// large pool and requires identical bytes, since the partial results must be
+// combined in the same order whatever the parallelism.
+//
+// Any failure, and with -race any data race, makes the command exit non-zero.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	elgamal "bhwmonitoring-go/elgamal"
+	pcr "bhwmonitoring-go/pcr"
+	workpool "bhwmonitoring-go/workpool"
+)
+
+const targetPassword = "Simba"
+
+var otherPasswords = []string{"Nala", "Mufasa", "Rafiki", "Sarabi"}
+
+func main() {
+
+	group := flag.String("group", "all", "group name, or all")
+	bfLength := flag.Int("BFLength", 256, "an int")
+	bfNumOnes := flag.Int("numOnes", 64, "an int")
+	numHashFuncs := flag.Int("numHFs", 8, "an int")
+	workers := flag.Int("workers", 64, "goroutines of the shared worker pool")
+	concurrency := flag.Int("concurrency", 8, "goroutines generating responses")
+	checks := flag.Int("checks", 10, "checks per goroutine")
+	flag.Parse()
+
+	groups := []string{*group}
+	if *group == "all" {
+		groups = elgamal.GroupNames()
+	}
+
+	pool := workpool.New(*workers)
+	defer pool.Close()
+
+	failed := false
+	for _, name := range groups {
+		start := time.Now()
+		misses, falseAlarms, err := stress(name, pool, *bfLength, *bfNumOnes, *numHashFuncs, *concurrency, *checks)
+		if err == nil {
+			err = replay(name, *workers, *bfLength, *bfNumOnes, *numHashFuncs)
+		}
+		switch {
+		case err != nil:
+			fmt.Printf("%-12s FAIL: %v\n", name, err)
+			failed = true
+		case misses > 0 || falseAlarms > 0:
+			fmt.Printf("%-12s FAIL: %d missed matches, %d wrong results for other passwords\n", name, misses, falseAlarms)
+			failed = true
+		default:
+			fmt.Printf("%-12s ok: %d checks in %v\n", name, *concurrency**checks, time.Since(start).Round(time.Millisecond))
+		}
+	}
+	if failed {
+		os.Exit(-1)
+	}
+}
+
+// This function runs concurrent checks against one deployed query and counts
+// the target-password checks that were not positive and the other checks
+// that were not negative
+func stress(groupName string, pool *workpool.Pool, bfLength, bfNumOnes, numHashFuncs, concurrency, checks int) (misses, falseAlarms int64, err error) {
+
+	ctx := workpool.NewContext(context.Background(), pool)
+	pk, sk, reqPara := pcr.ReqInitGroup(groupName, bfLength, bfNumOnes, numHashFuncs, 1, true)
+	bf := pcr.ReqBFGen(pk, reqPara, targetPassword)
+
+	queryMessage, err := pcr.QueryGenContext(ctx, pk, reqPara, bf)
+	if err != nil {
+		return 0, 0, err
+	}
+	rcvQueryMessage, err := pcr.DecodeQuery(pcr.EncodeQuery(queryMessage))
+	if err != nil {
+		return 0, 0, err
+	}
+	queryMessagePlus, err := pcr.RespDeploymentContext(ctx, rcvQueryMessage)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := queryMessagePlus.Deploy(true); err != nil {
+		return 0, 0, err
+	}
+	precompute := queryMessagePlus.Precompute(pcr.PrecomputeConfig{Depth: concurrency, Hybrid: true})
+	defer precompute.Stop()
+
+	var wg sync.WaitGroup
+	for g := 0; g < concurrency; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < checks; i++ {
+				pwd := targetPassword
+				if (g+i)%2 == 1 {
+					pwd = otherPasswords[(g+i)%len(otherPasswords)]
+				}
+
+				var responseMessage *pcr.ResponseMessage
+				var err error
+				if i%3 == 2 {
+					record := &pcr.RevealRecord{Password: pwd, Timestamp: int64(i), Source: "respstress"}
+					responseMessage, err = pcr.ResponseGenHybridContext(ctx, nil, queryMessagePlus, record)
+				} else {
+					responseMessage, err = pcr.ResponseGenContext(ctx, nil, queryMessagePlus, pwd)
+				}
+				var rcvResponseMessage *pcr.ResponseMessage
+				if err == nil {
+					rcvResponseMessage, err = pcr.DecodeResponse(pcr.EncodeResponse(responseMessage))
+				}
+				if err != nil {
+					atomic.AddInt64(&falseAlarms, 1)
+					continue
+				}
+
+				result := pcr.ResponseDecrypt(pk, sk, reqPara, rcvResponseMessage, bf)
+				if pwd == targetPassword && result.Outcome != pcr.Positive {
+					atomic.AddInt64(&misses, 1)
+				}
+				if pwd != targetPassword && result.Outcome != pcr.Negative {
+					atomic.AddInt64(&falseAlarms, 1)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	return misses, falseAlarms, nil
+}
+
+// This function generates the same seeded response on a pool of one worker
+// and on a pool of the given size and compares the encodings
+func replay(groupName string, workers, bfLength, bfNumOnes, numHashFuncs int) error {
+
+	seed := []byte("respstress/replay/" + groupName)
+	var responses [][]byte
+	for _, size := range []int{1, workers} {
+		response, err := seededResponse(groupName, seed, size, bfLength, bfNumOnes, numHashFuncs)
+		if err != nil {
+			return err
+		}
+		responses = append(responses, response)
+	}
+	if !bytes.Equal(responses[0], responses[1]) {
+		return fmt.Errorf("seeded response differs between 1 and %d workers", workers)
+	}
+	return nil
+}
+
+// This function returns the encoding of a response to a query under a key
+// and randomness drawn from seed, computed on a pool of the given size
+func seededResponse(groupName string, seed []byte, size, bfLength, bfNumOnes, numHashFuncs int) ([]byte, error) {
+
+	group, err := elgamal.GroupByName(groupName)
+	if err != nil {
+		return nil, err
+	}
+	pk, _, err := elgamal.KeyGenFromSeed(group, seed, true)
+	if err != nil {
+		return nil, err
+	}
+	pool := workpool.New(size)
+	defer pool.Close()
+	ctx := workpool.NewContext(context.Background(), pool)
+	pk.SetRandomSource(elgamal.NewSeededReader(append(append([]byte{}, seed...), "/target"...)))
+
+	reqPara := &pcr.ReqPara{Group: group.Name(), Params: pk.SecParam, BfLength: bfLength, BfNumOnes: bfNumOnes, NumHashFuncs: numHashFuncs, NumThreads: 1, PointCompression: true}
+	bf := pcr.ReqBFGen(pk, reqPara, targetPassword)
+	queryMessage, err := pcr.QueryGenContext(ctx, pk, reqPara, bf)
+	if err != nil {
+		return nil, err
+	}
+	queryMessagePlus, err := pcr.RespDeploymentContext(ctx, queryMessage)
+	if err != nil {
+		return nil, err
+	}
+	pk.SetRandomSource(elgamal.NewSeededReader(append(append([]byte{}, seed...), "/monitor"...)))
+	responseMessage, err := pcr.ResponseGenContext(ctx, nil, queryMessagePlus, targetPassword)
+	if err != nil {
+		return nil, err
+	}
+	return pcr.EncodeResponse(responseMessage), nil
+}
@@ -0,0 +1,202 @@
+package elgamal
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+)
+
+// Decryptor performs every operation that needs the secret key, so that the
+// key can be held by another process (see package keyserver) while the
+// service receiving responses only holds the public key.
+type Decryptor interface {
+	PublicKey() *PublicKey
+	// DecryptAndCheck0 reports whether c encrypts zero
+	DecryptAndCheck0(c *Ciphertext) (bool, error)
+	// Decrypt returns the message embedded by EncryptMul
+	Decrypt(c *Ciphertext) ([]byte, error)
+	// DecapsulateKey returns the key of a hybrid KEM ciphertext
+	DecapsulateKey(c *Ciphertext) ([]byte, error)
+	// ProveDecryption returns sk·C1 with a proof that it was computed with
+	// the secret key of PublicKey()
+	ProveDecryption(c *Ciphertext) (*DecryptionProof, error)
+}
+
+// DecryptionProof carries the decryption share D = sk·C1 of a ciphertext and a
+// Chaum-Pedersen proof that log_G(H) = log_C1(D). Anyone holding the public
+// key can then compute the plaintext element C2 - D.
+type DecryptionProof struct {
+	Dx *big.Int
+	Dy *big.Int
+	Ax *big.Int
+	Ay *big.Int
+	Bx *big.Int
+	By *big.Int
+	S  *big.Int
+}
+
+type DecryptionProofByte struct {
+	D []byte
+	A []byte
+	B []byte
+	S []byte
+}
+
+const decryptionProofLabel = "bhwmonitoring-go/decryption-proof/v1"
+
+// LocalDecryptor is the in-process Decryptor around a secret key
+type LocalDecryptor struct {
+	sk *SecretKey
+	pk *PublicKey
+}
+
+// This function returns a Decryptor that uses the secret key directly
+func NewLocalDecryptor(sk *SecretKey) *LocalDecryptor {
+	return &LocalDecryptor{sk, sk.PublicKey()}
+}
+
+func (d *LocalDecryptor) PublicKey() *PublicKey {
+	return d.pk
+}
+
+func (d *LocalDecryptor) DecryptAndCheck0(c *Ciphertext) (bool, error) {
+	return d.sk.DecryptAndCheck0(c), nil
+}
+
+func (d *LocalDecryptor) Decrypt(c *Ciphertext) ([]byte, error) {
+	return d.sk.Decrypt(c), nil
+}
+
+func (d *LocalDecryptor) DecapsulateKey(c *Ciphertext) ([]byte, error) {
+	return d.sk.DecapsulateKey(c), nil
+}
+
+func (d *LocalDecryptor) ProveDecryption(c *Ciphertext) (*DecryptionProof, error) {
+	return d.sk.ProveDecryption(c), nil
+}
+
+// This function computes the decryption share sk·C1 of a ciphertext and proves
+// it correct
+func (sk *SecretKey) ProveDecryption(c *Ciphertext) *DecryptionProof {
+	return proveDecryption(sk.Group(), sk.Priv, sk.Hx, sk.Hy, c)
+}
+
+// This function checks a decryption proof for the ciphertext under this key
+func (pk *PublicKey) VerifyDecryption(c *Ciphertext, proof *DecryptionProof) bool {
+	return verifyDecryption(pk.Group(), pk.Hx, pk.Hy, c, proof)
+}
+
+// This function checks a decryption proof for the ciphertext and that the
+// ciphertext encrypts the integer m, i.e. that C2 - D = m·G. It lets the key
+// holder prove what a ciphertext computed by someone else encrypts.
+func (pk *PublicKey) VerifyPlaintext(c *Ciphertext, m *big.Int, proof *DecryptionProof) bool {
+
+	if !pk.VerifyDecryption(c, proof) {
+		return false
+	}
+	group := pk.Group()
+	Mx, My := pk.ApplyDecryption(c, proof)
+	k := big.NewInt(0).Mod(m, group.Order())
+	Ex, Ey := group.ScalarBaseMult(k.Bytes())
+	if group.IsIdentity(Ex, Ey) || group.IsIdentity(Mx, My) {
+		return group.IsIdentity(Ex, Ey) && group.IsIdentity(Mx, My)
+	}
+	return Mx.Cmp(Ex) == 0 && My.Cmp(Ey) == 0
+}
+
+// This function computes D = x·C1 and proves log_G(H) = log_C1(D) for the
+// public element H = x·G
+func proveDecryption(group Group, x []byte, Hx, Hy *big.Int, c *Ciphertext) *DecryptionProof {
+
+	Dx, Dy := group.ScalarMult(c.C1x, c.C1y, x)
+
+	w := newCryptoRandom(group.Order().Bytes())
+	Ax, Ay := group.ScalarBaseMult(w)
+	Bx, By := group.ScalarMult(c.C1x, c.C1y, w)
+
+	e := decryptionChallenge(group, Hx, Hy, c, Dx, Dy, Ax, Ay, Bx, By)
+	s := big.NewInt(0).Mul(e, big.NewInt(0).SetBytes(x))
+	s.Add(s, big.NewInt(0).SetBytes(w))
+	s.Mod(s, group.Order())
+
+	return &DecryptionProof{Dx, Dy, Ax, Ay, Bx, By, s}
+}
+
+// This function checks a proof made by proveDecryption for the element H
+func verifyDecryption(group Group, Hx, Hy *big.Int, c *Ciphertext, proof *DecryptionProof) bool {
+
+	e := decryptionChallenge(group, Hx, Hy, c, proof.Dx, proof.Dy, proof.Ax, proof.Ay, proof.Bx, proof.By)
+
+	// s·G = A + e·H
+	sGx, sGy := group.ScalarBaseMult(proof.S.Bytes())
+	eHx, eHy := group.ScalarMult(Hx, Hy, e.Bytes())
+	rx, ry := addPoints(group, proof.Ax, proof.Ay, eHx, eHy)
+	if sGx.Cmp(rx) != 0 || sGy.Cmp(ry) != 0 {
+		return false
+	}
+
+	// s·C1 = B + e·D
+	sCx, sCy := group.ScalarMult(c.C1x, c.C1y, proof.S.Bytes())
+	eDx, eDy := group.ScalarMult(proof.Dx, proof.Dy, e.Bytes())
+	rx, ry = addPoints(group, proof.Bx, proof.By, eDx, eDy)
+	return sCx.Cmp(rx) == 0 && sCy.Cmp(ry) == 0
+}
+
+// This function returns the plaintext element C2 - D of a ciphertext whose
+// decryption share D has been verified
+func (pk *PublicKey) ApplyDecryption(c *Ciphertext, proof *DecryptionProof) (*big.Int, *big.Int) {
+	group := pk.Group()
+	nDx, nDy := group.Neg(proof.Dx, proof.Dy)
+	return addPoints(group, c.C2x, c.C2y, nDx, nDy)
+}
+
+// This function encodes a decryption proof to bytes
+func (pk *PublicKey) DecryptionProof2Bytes(proof *DecryptionProof, pointCompression bool) *DecryptionProofByte {
+	group := pk.Group()
+	return &DecryptionProofByte{
+		group.Marshal(proof.Dx, proof.Dy, pointCompression),
+		group.Marshal(proof.Ax, proof.Ay, pointCompression),
+		group.Marshal(proof.Bx, proof.By, pointCompression),
+		proof.S.Bytes(),
+	}
+}
+
+// This function decodes a decryption proof, rejecting invalid points and
+// out-of-range scalars as Bytes2ZKP does
+func (pk *PublicKey) Bytes2DecryptionProof(proofBytes *DecryptionProofByte, pointCompression bool) (*DecryptionProof, error) {
+	if proofBytes == nil {
+		return nil, errors.New("missing decryption proof")
+	}
+	Dx, Dy, err := pk.decodeElement(proofBytes.D, pointCompression)
+	if err != nil {
+		return nil, err
+	}
+	Ax, Ay, err := pk.decodeElement(proofBytes.A, pointCompression)
+	if err != nil {
+		return nil, err
+	}
+	Bx, By, err := pk.decodeElement(proofBytes.B, pointCompression)
+	if err != nil {
+		return nil, err
+	}
+	S, err := pk.DecodeScalar(proofBytes.S)
+	if err != nil {
+		return nil, err
+	}
+	return &DecryptionProof{Dx, Dy, Ax, Ay, Bx, By, S}, nil
+}
+
+// This function hashes the statement and commitments, given as consecutive
+// coordinate pairs, with their fixed-length uncompressed encodings
+func decryptionChallenge(group Group, Hx, Hy *big.Int, c *Ciphertext, points ...*big.Int) *big.Int {
+	buf := &bytes.Buffer{}
+	buf.WriteString(decryptionProofLabel)
+	buf.WriteString(group.Name())
+	Gx, Gy := group.Generator()
+	coords := append([]*big.Int{Gx, Gy, Hx, Hy, c.C1x, c.C1y, c.C2x, c.C2y}, points...)
+	for i := 0; i+1 < len(coords); i += 2 {
+		buf.Write(group.Marshal(coords[i], coords[i+1], false))
+	}
+	e := big.NewInt(0).SetBytes(HashSha256(buf.Bytes()))
+	return e.Mod(e, group.Order())
+}
@@ -0,0 +1,123 @@
+package elgamal
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+)
+
+// DlogTable holds the baby steps j·G for 0 <= j < Steps and recovers any
+// m in [-Bound, Bound] from m·G with at most Steps giant steps.
+type DlogTable struct {
+	Bound  int64
+	Steps  int64
+	group  Group
+	baby   map[string]int64
+	giantX *big.Int // -Steps·G
+	giantY *big.Int
+}
+
+var dlogCache = struct {
+	sync.Mutex
+	tables map[string]*DlogTable
+}{tables: make(map[string]*DlogTable)}
+
+// This function returns the baby-step table for the given group and bound,
+// building it on first use and caching it for later calls
+func CachedDlogTable(group Group, bound int64) *DlogTable {
+
+	key := group.Name() + "/" + big.NewInt(bound).String()
+
+	dlogCache.Lock()
+	defer dlogCache.Unlock()
+	if t, ok := dlogCache.tables[key]; ok {
+		return t
+	}
+	t := NewDlogTable(group, bound)
+	dlogCache.tables[key] = t
+	return t
+}
+
+// This function precomputes a baby-step table able to recover plaintexts in
+// [-bound, bound]. Building it costs about sqrt(2·bound+1) point additions.
+func NewDlogTable(group Group, bound int64) *DlogTable {
+
+	if bound < 0 {
+		bound = -bound
+	}
+	span := big.NewInt(bound)
+	span.Lsh(span, 1).Add(span, big.NewInt(1))
+	steps := new(big.Int).Sqrt(span).Int64() + 1
+
+	t := &DlogTable{Bound: bound, Steps: steps, group: group, baby: make(map[string]int64, steps)}
+
+	x, y := group.Identity()
+	Gx, Gy := group.Generator()
+	for j := int64(0); j < steps; j++ {
+		t.baby[dlogKey(group, x, y)] = j
+		x, y = addPoints(group, x, y, Gx, Gy)
+	}
+
+	negSteps := new(big.Int).Sub(group.Order(), big.NewInt(steps))
+	t.giantX, t.giantY = group.ScalarBaseMult(negSteps.Bytes())
+
+	return t
+}
+
+// This function returns m such that (x, y) = m·G, or an error if m is outside
+// [-Bound, Bound]
+func (t *DlogTable) Lookup(x, y *big.Int) (int64, error) {
+
+	group := t.group
+
+	// shift into [0, 2·Bound] so that only non-negative exponents are searched
+	shift := new(big.Int).SetInt64(t.Bound)
+	sx, sy := group.ScalarBaseMult(shift.Bytes())
+	px, py := addPoints(group, x, y, sx, sy)
+
+	for i := int64(0); i <= t.Steps; i++ {
+		if j, ok := t.baby[dlogKey(group, px, py)]; ok {
+			m := i*t.Steps + j - t.Bound
+			if m <= t.Bound {
+				return m, nil
+			}
+		}
+		px, py = addPoints(group, px, py, t.giantX, t.giantY)
+	}
+
+	return 0, errors.New("plaintext outside the table bound")
+}
+
+// This function, given a secret key, decrypts a ciphertext produced by Encrypt
+// and recovers the small integer plaintext with the given baby-step table
+func (sk *SecretKey) DecryptSmall(c *Ciphertext, t *DlogTable) (int64, error) {
+
+	group := sk.Group()
+
+	invSK := big.NewInt(0).SetBytes(sk.Priv)
+	invSK = invSK.Sub(group.Order(), invSK)
+
+	tempx, tempy := group.ScalarMult(c.C1x, c.C1y, invSK.Bytes())
+	gmx, gmy := addPoints(group, c.C2x, c.C2y, tempx, tempy)
+
+	return t.Lookup(gmx, gmy)
+}
+
+// crypto/elliptic's ScalarBaseMult(0) and ScalarMult by 0 return (0, 0), so
+// the identity is tested explicitly before adding
+func addPoints(group Group, x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	if group.IsIdentity(x1, y1) {
+		return x2, y2
+	}
+	if group.IsIdentity(x2, y2) {
+		return x1, y1
+	}
+	return group.Add(x1, y1, x2, y2)
+}
+
+func dlogKey(group Group, x, y *big.Int) string {
+	if group.IsIdentity(x, y) {
+		return ""
+	}
+	return string(group.Marshal(x, y, true))
+}
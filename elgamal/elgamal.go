@@ -1,17 +1,16 @@
 package elgamal
 
 import (
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"log"
 	"math/big"
-	"math"
-	"os"
 	"fmt"
 	"errors"
 	"sync"
 	"bytes"
+	"context"
+	"io"
+	workpool "bhwmonitoring-go/workpool"
 )
 
 type GroupElement struct {
@@ -20,20 +19,28 @@ type GroupElement struct {
 }
 
 type PublicKey struct {
-	Curve *elliptic.Curve
+	GroupName string
 	SecParam int
 	Gx *big.Int
 	Gy *big.Int
 	Hx *big.Int
 	Hy *big.Int
 	PointCompression bool
+
+	group Group
+	precompMode int
+	precompOnce sync.Once
+	precomp *precomputation
+	random io.Reader
 }
 
 type SecretKey struct {
-	Curve *elliptic.Curve
+	GroupName string
 	Hx *big.Int
 	Hy *big.Int
 	Priv []byte
+
+	group Group
 }
 
 type Ciphertext struct {
@@ -76,20 +83,35 @@ type ZKPByte struct {
 
 const PaddingBytes = 4
 
-// This function generates a EC-ElGamal key pair
-func KeyGen(secParam int, pointCompression bool) (*PublicKey, *SecretKey) {
+// This function generates a EC-ElGamal key pair over the NIST curve with the
+// given key length (224, 256, 384 or 521 bits)
+func KeyGen(secParam int, pointCompression bool) (*PublicKey, *SecretKey, error) {
 
-	curve := *(initCurve(secParam))
+	group, err := GroupBySecParam(secParam)
+	if err != nil {
+		return nil, nil, err
+	}
+	return KeyGenInGroup(group, pointCompression)
+}
 
-	priv, Hx, Hy, _ := elliptic.GenerateKey(curve, rand.Reader)
-	Gx, Gy := curve.ScalarBaseMult(big.NewInt(1).Bytes())
+// This function generates a EC-ElGamal key pair over the given group
+func KeyGenInGroup(group Group, pointCompression bool) (*PublicKey, *SecretKey, error) {
+
+	order := group.Order()
+	privInt := big.NewInt(0)
+	for privInt.Sign() == 0 {
+		privInt.SetBytes(newCryptoRandom(order.Bytes()))
+	}
+	priv := privInt.FillBytes(make([]byte, len(order.Bytes())))
+	Hx, Hy := group.ScalarBaseMult(priv)
+	Gx, Gy := group.Generator()
 
 	// create public key
-	pk := &PublicKey{&curve, secParam, Gx, Gy, Hx, Hy, pointCompression}
+	pk := &PublicKey{GroupName: group.Name(), SecParam: order.BitLen(), Gx: Gx, Gy: Gy, Hx: Hx, Hy: Hy, PointCompression: pointCompression, group: group}
 
 	// create secret key
-	sk := &SecretKey{&curve, Hx, Hy, priv}
-	return pk, sk
+	sk := &SecretKey{GroupName: group.Name(), Hx: Hx, Hy: Hy, Priv: priv, group: group}
+	return pk, sk, nil
 }
 
 
@@ -97,72 +119,104 @@ func KeyGen(secParam int, pointCompression bool) (*PublicKey, *SecretKey) {
 // and return a ciphertext
 func (pk *PublicKey) Encrypt(m *big.Int) *Ciphertext {
 
-	curve := *pk.Curve
-	m = m.Mod(m, curve.Params().N)
-	z := newCryptoRandom(curve.Params().N.Bytes())
-	c1x, c1y := curve.ScalarBaseMult(z)
-	Hzx, Hzy := curve.ScalarMult(pk.Hx, pk.Hy, z)
-	gmx, gmy := curve.ScalarBaseMult(m.Bytes())
-	c2x, c2y := curve.Add(gmx, gmy, Hzx, Hzy)
-
-	c := &Ciphertext{c1x, c1y, c2x, c2y}
+	group := pk.Group()
+	m = m.Mod(m, group.Order())
+	z := big.NewInt(0).SetBytes(pk.randomBytes())
 
-	return c
+	return pk.EncryptWithRandomness(m, z)
 }
 
-func (pk *PublicKey) polynomial(x *big.Int) *big.Int {
-	curve := *pk.Curve
-	x3 := new(big.Int).Mul(x, x)
-	x3.Mul(x3, x)
+// This function, given a public key, encrypts a plaintext message with the
+// caller-supplied randomness z, i.e., returns (zG, mG + zH). Callers must never
+// reuse z across encryptions; it exists for proofs and reproducible tests.
+func (pk *PublicKey) EncryptWithRandomness(m, z *big.Int) *Ciphertext {
 
-	threeX := new(big.Int).Lsh(x, 1)
-	threeX.Add(threeX, x)
+	group := pk.Group()
+	m = big.NewInt(0).Mod(m, group.Order())
+	z = big.NewInt(0).Mod(z, group.Order())
+	c1x, c1y := pk.baseMult(z.Bytes())
+	Hzx, Hzy := pk.pubMult(z.Bytes())
+	gmx, gmy := pk.baseMult(m.Bytes())
+	c2x, c2y := group.Add(gmx, gmy, Hzx, Hzy)
 
-	x3.Sub(x3, threeX)
-	x3.Add(x3, curve.Params().B)
-	x3.Mod(x3, curve.Params().P)
+	c := &Ciphertext{c1x, c1y, c2x, c2y}
 
-	return x3
+	return c
 }
 
+// This function, given a public key, embeds a short message into a group
+// element and encrypts it, so that Decrypt recovers the message. It panics if
+// the message is too long to embed; use EncryptMulWithZero for messages that
+// are not known to fit.
 func (pk *PublicKey) EncryptMul(msg []byte) *Ciphertext {
 
-	curve := *pk.Curve
-	// m = m.Lsh(m, 32)
-	p := curve.Params().P
-	msgExt := msg
-	for i := 0; i < PaddingBytes; i ++ {
-		msgExt = append(msgExt, 0)
-	}
-	mx := big.NewInt(0).SetBytes(msgExt)
-	my := big.NewInt(0)
-	for i := 0; i < int(math.Pow(2, PaddingBytes*8)); i++ {
-		my = pk.polynomial(mx)
-		my = my.ModSqrt(my, p)
-		if curve.IsOnCurve(mx, my) {
-			break
-		}
-		mx = mx.Add(mx, big.NewInt(1))
-	}
-	if !curve.IsOnCurve(mx, my) {
-		os.Exit(-1)
+	group := pk.Group()
+	mx, my, err := group.Embed(msg)
+	if err != nil {
+		panic(err)
 	}
-	z := newCryptoRandom(curve.Params().N.Bytes())
-	c1x, c1y := curve.ScalarBaseMult(z)
-	Hzx, Hzy := curve.ScalarMult(pk.Hx, pk.Hy, z)
-	c2x, c2y := curve.Add(mx, my, Hzx, Hzy)
+	z := pk.randomBytes()
+	c1x, c1y := pk.baseMult(z)
+	Hzx, Hzy := pk.pubMult(z)
+	c2x, c2y := group.Add(mx, my, Hzx, Hzy)
 
 	c := &Ciphertext{c1x, c1y, c2x, c2y}
 
 	return c
 }
 
+// This function works as EncryptMul, but takes its randomness from an
+// encryption of zero, e.g. one computed offline, so that only the embedding
+// and one addition remain
+func (pk *PublicKey) EncryptMulWithZero(msg []byte, zero *Ciphertext) (*Ciphertext, error) {
+
+	group := pk.Group()
+	mx, my, err := group.Embed(msg)
+	if err != nil {
+		return nil, err
+	}
+	c2x, c2y := group.Add(mx, my, zero.C2x, zero.C2y)
 
+	return &Ciphertext{zero.C1x, zero.C1y, c2x, c2y}, nil
+}
+
+// This function encrypts a sequence of +1 and -1 and proves with OR-proofs
+// that each ciphertext encrypts one of them. The work runs on
+// workpool.Default(), and in the Context variants on the pool the context
+// carries (see workpool.NewContext); numThreads is kept for compatibility and
+// ignored.
+// It panics on any other message.
 func (pk *PublicKey) EncryptSeqWithZKP(ms []*big.Int, numThreads int) ([]*Ciphertext, []*ZKP, *big.Int) {
+	cs, zkps, challenge, err := pk.EncryptSeqWithZKPContext(context.Background(), ms, numThreads)
+	if err != nil {
+		panic(err)
+	}
+	return cs, zkps, challenge
+}
 
-	var encSeqZKP sync.WaitGroup
+// This function works as EncryptSeqWithZKP, but returns an error for a message
+// other than +1 and -1, and stops dispatching work once ctx is done and then
+// returns ctx.Err()
+func (pk *PublicKey) EncryptSeqWithZKPContext(ctx context.Context, ms []*big.Int, numThreads int) ([]*Ciphertext, []*ZKP, *big.Int, error) {
+	return pk.EncryptSeqWithZKPBoundContext(ctx, ms, numThreads, nil)
+}
+
+// This function works as EncryptSeqWithZKPContext, but also hashes label into
+// the challenge, so that the proofs only verify together with the same label,
+// e.g. metadata sent along with the ciphertexts. An empty label gives the
+// challenge of EncryptSeqWithZKPContext.
+func (pk *PublicKey) EncryptSeqWithZKPBoundContext(ctx context.Context, ms []*big.Int, numThreads int, label []byte) ([]*Ciphertext, []*ZKP, *big.Int, error) {
 
-	curve := *pk.Curve
+	for i := range ms {
+		if big.NewInt(1).CmpAbs(ms[i]) != 0 {
+			return nil, nil, nil, fmt.Errorf("invalid message space at index %d", i)
+		}
+	}
+
+	pool := workpool.FromContext(ctx)
+	var err error
+
+	group := pk.Group()
 	zs := make([]*big.Int, len(ms))
 	a1s := make([]*GroupElement, len(ms))
 	a2s := make([]*GroupElement, len(ms))
@@ -177,40 +231,41 @@ func (pk *PublicKey) EncryptSeqWithZKP(ms []*big.Int, numThreads int) ([]*Cipher
 	zkps := make([]*ZKP, len(ms))
 
 
-	chWorkerEnc := make(chan int, numThreads)
-	defer close(chWorkerEnc)
-
+	// the randomness is drawn in sequence order before the workers start, so
+	// that a deterministic source gives the same query for any numThreads
 	for i := range ms {
-		chWorkerEnc <- 1
-		encSeqZKP.Add(1)
-
-		go func(i int) {
-			defer encSeqZKP.Done()
-			
-			if big.NewInt(1).CmpAbs(ms[i]) != 0 {
-				err := errors.New("Invalid message space!")
-				fmt.Println(err)
-				os.Exit(-1)	
-			}
-
-			// Encryption
-		
-			ms[i] = ms[i].Mod(ms[i], curve.Params().N)
-			gmx, gmy := curve.ScalarBaseMult(ms[i].Bytes())
-			zs[i] = big.NewInt(1).SetBytes(newCryptoRandom(curve.Params().N.Bytes()))
-			c1x, c1y := curve.ScalarBaseMult(zs[i].Bytes())
-			Hzx, Hzy := curve.ScalarMult(pk.Hx, pk.Hy, zs[i].Bytes())
-			c2x, c2y := curve.Add(Hzx, Hzy, gmx, gmy)
-
-			cs[i] = &Ciphertext{c1x, c1y, c2x, c2y}
-			<- chWorkerEnc
-		}(i)
-		
+		zs[i] = big.NewInt(0).SetBytes(pk.randomBytes())
+	}
+	// commitment, simulated response and simulated challenge of each OR-proof
+	rs := make([]*big.Int, len(ms))
+	ds := make([]*big.Int, len(ms))
+	for i := range ms {
+		ws[i] = big.NewInt(0).SetBytes(pk.randomBytes())
+		rs[i] = big.NewInt(0).SetBytes(pk.randomBytes())
+		ds[i] = big.NewInt(0).SetBytes(pk.randomBytes())
+	}
+
+	err = pool.ForEach(ctx, len(ms), func(i int) {
+
+		// Encryption
+	
+		ms[i] = ms[i].Mod(ms[i], group.Order())
+		gmx, gmy := pk.baseMult(ms[i].Bytes())
+		c1x, c1y := pk.baseMult(zs[i].Bytes())
+		Hzx, Hzy := pk.pubMult(zs[i].Bytes())
+		c2x, c2y := group.Add(Hzx, Hzy, gmx, gmy)
+
+		cs[i] = &Ciphertext{c1x, c1y, c2x, c2y}
+	})
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	encSeqZKP.Wait()
 
 
 	buf := &bytes.Buffer{}
+	if len(label) > 0 {
+		buf.Write(HashSha256(label))
+	}
 	for i := range ms {
 		buf.Write(cs[i].C1x.Bytes())
 		buf.Write(cs[i].C1y.Bytes())
@@ -220,84 +275,71 @@ func (pk *PublicKey) EncryptSeqWithZKP(ms []*big.Int, numThreads int) ([]*Cipher
 	// csbytes := buf.Bytes()
 
 
-	chWorkerZKP := make(chan int, numThreads)
-	defer close(chWorkerZKP)
-
-	for i := range ms {
-		chWorkerZKP <- 1
-		encSeqZKP.Add(1)
-
-		go func(i int) {
-			defer encSeqZKP.Done()
-			
-			a1x := big.NewInt(0)
-			a1y := big.NewInt(0)
-			b1x := big.NewInt(0)
-			b1y := big.NewInt(0)
-			a2x := big.NewInt(0)
-			a2y := big.NewInt(0)
-			b2x := big.NewInt(0)
-			b2y := big.NewInt(0)
-			w := big.NewInt(0)
-			d1 := big.NewInt(0)
-			d2 := big.NewInt(0)
-			r1 := big.NewInt(0)
-			r2 := big.NewInt(0)
-			
-			if big.NewInt(1).Cmp(ms[i]) == 0 {
-
-				w = big.NewInt(1).SetBytes(newCryptoRandom(curve.Params().N.Bytes()))
-				r1 = big.NewInt(1).SetBytes(newCryptoRandom(curve.Params().N.Bytes()))
-				d1 = big.NewInt(1).SetBytes(newCryptoRandom(curve.Params().N.Bytes()))
-				gr1x, gr1y := curve.ScalarBaseMult(r1.Bytes())
-				c1d1x, c1d1y := curve.ScalarMult(cs[i].C1x, cs[i].C1y, d1.Bytes())
-				a1x, a1y = curve.Add(gr1x, gr1y, c1d1x, c1d1y)
-
-				hr1x, hr1y := curve.ScalarMult(pk.Hx, pk.Hy, r1.Bytes())
-				c2gx, c2gy := curve.Add(cs[i].C2x, cs[i].C2y, pk.Gx, pk.Gy)
-				c2gd1x, c2gd1y := curve.ScalarMult(c2gx, c2gy, d1.Bytes())
-				b1x, b1y = curve.Add(hr1x, hr1y, c2gd1x, c2gd1y)
-
-				a2x, a2y = curve.ScalarBaseMult(w.Bytes())
-				b2x, b2y = curve.ScalarMult(pk.Hx, pk.Hy, w.Bytes())
-
-			} else {
-
-				w = big.NewInt(1).SetBytes(newCryptoRandom(curve.Params().N.Bytes()))
-				r2 = big.NewInt(1).SetBytes(newCryptoRandom(curve.Params().N.Bytes()))
-				d2 = big.NewInt(1).SetBytes(newCryptoRandom(curve.Params().N.Bytes()))
-				a1x, a1y = curve.ScalarBaseMult(w.Bytes())
-				b1x, b1y = curve.ScalarMult(pk.Hx, pk.Hy, w.Bytes())
-
-				gr2x, gr2y := curve.ScalarBaseMult(r2.Bytes())
-
-				c1d2x, c1d2y := curve.ScalarMult(cs[i].C1x, cs[i].C1y, d2.Bytes())
-				a2x, a2y = curve.Add(gr2x, gr2y, c1d2x, c1d2y)
-
-				invOne := big.NewInt(1).Mod(big.NewInt(-1), curve.Params().N)
-				invGx, invGy := curve.ScalarBaseMult(invOne.Bytes())
-
-				hr2x, hr2y := curve.ScalarMult(pk.Hx, pk.Hy, r2.Bytes())
-				c2invgx, c2invgy := curve.Add(cs[i].C2x, cs[i].C2y, invGx, invGy)
-				c2invgd2x, c2invgd2y := curve.ScalarMult(c2invgx, c2invgy, d2.Bytes())
-				b2x, b2y = curve.Add(hr2x, hr2y, c2invgd2x, c2invgd2y)
-			}
-
-			a1s[i] = &GroupElement{a1x, a1y}
-			b1s[i] = &GroupElement{b1x, b1y}
-			a2s[i] = &GroupElement{a2x, a2y}
-			b2s[i] = &GroupElement{b2x, b2y}
-			d1s[i] = d1
-			d2s[i] = d2
-			r1s[i] = r1
-			r2s[i] = r2
-			ws[i] = w
+	err = pool.ForEach(ctx, len(ms), func(i int) {
 		
-			<- chWorkerZKP
-		}(i)
+		a1x := big.NewInt(0)
+		a1y := big.NewInt(0)
+		b1x := big.NewInt(0)
+		b1y := big.NewInt(0)
+		a2x := big.NewInt(0)
+		a2y := big.NewInt(0)
+		b2x := big.NewInt(0)
+		b2y := big.NewInt(0)
+		w := big.NewInt(0)
+		d1 := big.NewInt(0)
+		d2 := big.NewInt(0)
+		r1 := big.NewInt(0)
+		r2 := big.NewInt(0)
 		
+		if big.NewInt(1).Cmp(ms[i]) == 0 {
+
+			w, r1, d1 = ws[i], rs[i], ds[i]
+			gr1x, gr1y := pk.baseMult(r1.Bytes())
+			c1d1x, c1d1y := group.ScalarMult(cs[i].C1x, cs[i].C1y, d1.Bytes())
+			a1x, a1y = group.Add(gr1x, gr1y, c1d1x, c1d1y)
+
+			hr1x, hr1y := pk.pubMult(r1.Bytes())
+			c2gx, c2gy := group.Add(cs[i].C2x, cs[i].C2y, pk.Gx, pk.Gy)
+			c2gd1x, c2gd1y := group.ScalarMult(c2gx, c2gy, d1.Bytes())
+			b1x, b1y = group.Add(hr1x, hr1y, c2gd1x, c2gd1y)
+
+			a2x, a2y = pk.baseMult(w.Bytes())
+			b2x, b2y = pk.pubMult(w.Bytes())
+
+		} else {
+
+			w, r2, d2 = ws[i], rs[i], ds[i]
+			a1x, a1y = pk.baseMult(w.Bytes())
+			b1x, b1y = pk.pubMult(w.Bytes())
+
+			gr2x, gr2y := pk.baseMult(r2.Bytes())
+
+			c1d2x, c1d2y := group.ScalarMult(cs[i].C1x, cs[i].C1y, d2.Bytes())
+			a2x, a2y = group.Add(gr2x, gr2y, c1d2x, c1d2y)
+
+			invOne := big.NewInt(1).Mod(big.NewInt(-1), group.Order())
+			invGx, invGy := pk.baseMult(invOne.Bytes())
+
+			hr2x, hr2y := pk.pubMult(r2.Bytes())
+			c2invgx, c2invgy := group.Add(cs[i].C2x, cs[i].C2y, invGx, invGy)
+			c2invgd2x, c2invgd2y := group.ScalarMult(c2invgx, c2invgy, d2.Bytes())
+			b2x, b2y = group.Add(hr2x, hr2y, c2invgd2x, c2invgd2y)
+		}
+
+		a1s[i] = &GroupElement{a1x, a1y}
+		b1s[i] = &GroupElement{b1x, b1y}
+		a2s[i] = &GroupElement{a2x, a2y}
+		b2s[i] = &GroupElement{b2x, b2y}
+		d1s[i] = d1
+		d2s[i] = d2
+		r1s[i] = r1
+		r2s[i] = r2
+		ws[i] = w
+	
+	})
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	encSeqZKP.Wait()
 
 	for i := range ms {
 		buf.Write(a1s[i].X.Bytes())
@@ -312,62 +354,100 @@ func (pk *PublicKey) EncryptSeqWithZKP(ms []*big.Int, numThreads int) ([]*Cipher
 	commitment := buf.Bytes()
 
 	challenge := big.NewInt(0).SetBytes(HashSha256(commitment))
-	challenge = challenge.Mod(challenge, curve.Params().N)
+	challenge = challenge.Mod(challenge, group.Order())
 
 
-	chWorkerZKPr:= make(chan int, numThreads)
-	defer close(chWorkerZKPr)
-	for i := range ms {
-		chWorkerZKPr <- 1
-		encSeqZKP.Add(1)
+	err = pool.ForEach(ctx, len(ms), func(i int) {
+		
+		if big.NewInt(1).Cmp(ms[i]) == 0 {
 
-		go func(i int) {
-			defer encSeqZKP.Done()
-			
-			if big.NewInt(1).Cmp(ms[i]) == 0 {
+			d2 := big.NewInt(0).Sub(challenge, d1s[i])
+			d2 = d2.Mod(d2, group.Order())
+			zd2 := big.NewInt(1).Mul(zs[i], d2)
+			zd2 = zd2.Mod(zd2, group.Order())
+			r2 := big.NewInt(0).Sub(ws[i], zd2)
+			r2 = r2.Mod(r2, group.Order())
 
-				d2 := big.NewInt(0).Sub(challenge, d1s[i])
-				d2 = d2.Mod(d2, curve.Params().N)
-				zd2 := big.NewInt(1).Mul(zs[i], d2)
-				zd2 = zd2.Mod(zd2, curve.Params().N)
-				r2 := big.NewInt(0).Sub(ws[i], zd2)
-				r2 = r2.Mod(r2, curve.Params().N)
+			d2s[i] = d2
+			r2s[i] = r2
+
+		} else {
+
+			d1 := big.NewInt(0).Sub(challenge, d2s[i])
+			d1 = d1.Mod(d1, group.Order())
+			zd1 := big.NewInt(1).Mul(zs[i], d1)
+			zd1 = zd1.Mod(zd1, group.Order())
+			r1 := big.NewInt(0).Sub(ws[i], zd1)
+			r1 = r1.Mod(r1, group.Order())
 
-				d2s[i] = d2
-				r2s[i] = r2
+			d1s[i] = d1
+			r1s[i] = r1
 
-			} else {
+		}
 
-				d1 := big.NewInt(0).Sub(challenge, d2s[i])
-				d1 = d1.Mod(d1, curve.Params().N)
-				zd1 := big.NewInt(1).Mul(zs[i], d1)
-				zd1 = zd1.Mod(zd1, curve.Params().N)
-				r1 := big.NewInt(0).Sub(ws[i], zd1)
-				r1 = r1.Mod(r1, curve.Params().N)
+		zkps[i] = &ZKP{a1s[i].X, a1s[i].Y, b1s[i].X, b1s[i].Y, a2s[i].X, a2s[i].Y, b2s[i].X, b2s[i].Y, d1s[i], d2s[i], r1s[i], r2s[i]}
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
-				d1s[i] = d1
-				r1s[i] = r1
+	return cs, zkps, challenge, nil
+}
 
-			}
+// ErrInvalidZKP is wrapped by the *ZKPError VerifySeqZKPContext returns for a
+// sequence of proofs that does not verify
+var ErrInvalidZKP = errors.New("Invalid ZKP!")
 
-			zkps[i] = &ZKP{a1s[i].X, a1s[i].Y, b1s[i].X, b1s[i].Y, a2s[i].X, a2s[i].Y, b2s[i].X, b2s[i].Y, d1s[i], d2s[i], r1s[i], r2s[i]}
-			<- chWorkerZKPr
-		}(i)
-		
+// ZKPError names the first check of VerifySeqZKPContext that failed: the
+// challenge of the whole sequence (Index -1), or one of the checks c, a1,
+// b1, a2 and b2 of the proof at Index
+type ZKPError struct {
+	Index int
+	Test  string
+}
+
+func (e *ZKPError) Error() string {
+	if e.Index < 0 {
+		return ErrInvalidZKP.Error() + " " + e.Test + "-test failed"
 	}
-	encSeqZKP.Wait()
+	return fmt.Sprintf("%s %s-test failed at index %d", ErrInvalidZKP, e.Test, e.Index)
+}
 
-	return cs, zkps, challenge
+func (e *ZKPError) Unwrap() error {
+	return ErrInvalidZKP
 }
 
+// This function verifies the OR-proofs made by EncryptSeqWithZKP on the key's
+// executor. numThreads, which usually comes from the query, is ignored.
 func (pk *PublicKey) VerifySeqZKP(cs []*Ciphertext, zkps []*ZKP, rcvChallenge *big.Int, numThreads int) bool {
+	valid, _ := pk.VerifySeqZKPContext(context.Background(), cs, zkps, rcvChallenge, numThreads)
+	return valid
+}
 
-	var vrfySeqZKP sync.WaitGroup
-	numFailedTests := 0
+// This function works as VerifySeqZKP, but returns false with a *ZKPError
+// for proofs that do not verify, and stops dispatching work once ctx is done
+// and then returns false with ctx.Err(). The proofs usually come from a peer,
+// so failures are only reported through the error.
+func (pk *PublicKey) VerifySeqZKPContext(ctx context.Context, cs []*Ciphertext, zkps []*ZKP, rcvChallenge *big.Int, numThreads int) (bool, error) {
+	return pk.VerifySeqZKPBoundContext(ctx, cs, zkps, rcvChallenge, numThreads, nil)
+}
+
+// This function verifies proofs made by EncryptSeqWithZKPBoundContext with the
+// same label, as VerifySeqZKPContext does; a different label fails the
+// challenge-test
+func (pk *PublicKey) VerifySeqZKPBoundContext(ctx context.Context, cs []*Ciphertext, zkps []*ZKP, rcvChallenge *big.Int, numThreads int, label []byte) (bool, error) {
+
+	pool := workpool.FromContext(ctx)
+	var err error
+	// the first failed check of each proof, "" if it verified
+	failedTests := make([]string, len(zkps))
 
-	curve := *pk.Curve
+	group := pk.Group()
 
 	buf := &bytes.Buffer{}
+	if len(label) > 0 {
+		buf.Write(HashSha256(label))
+	}
 	for i := range cs {
 		buf.Write(cs[i].C1x.Bytes())
 		buf.Write(cs[i].C1y.Bytes())
@@ -388,101 +468,95 @@ func (pk *PublicKey) VerifySeqZKP(cs []*Ciphertext, zkps []*ZKP, rcvChallenge *b
 	commitment := buf.Bytes()
 
 	challenge := big.NewInt(0).SetBytes(HashSha256(commitment))
-	challenge = challenge.Mod(challenge, curve.Params().N)
+	challenge = challenge.Mod(challenge, group.Order())
 
 	if challenge.Cmp(rcvChallenge) != 0 {
-		fmt.Println("b1-test failed.")
-		numFailedTests += 1
+		return false, &ZKPError{Index: -1, Test: "challenge"}
 	}
 
-	chWorkerVrfy := make(chan int, numThreads)
-	defer close(chWorkerVrfy)
+	err = pool.ForEach(ctx, len(zkps), func(i int) {
+		
+		c1x := cs[i].C1x
+		c1y := cs[i].C1y
+		c2x := cs[i].C2x
+		c2y := cs[i].C2y
+
+		a1x := zkps[i].A1x
+		a1y := zkps[i].A1y
+		b1x := zkps[i].B1x
+		b1y := zkps[i].B1y
+		a2x := zkps[i].A2x
+		a2y := zkps[i].A2y
+		b2x := zkps[i].B2x
+		b2y := zkps[i].B2y
+		d1 := zkps[i].D1
+		d2 := zkps[i].D2
+		r1 := zkps[i].R1
+		r2 := zkps[i].R2
+		
+		bigOne := big.NewInt(1)
+		d1PLUSd2 := bigOne.Add(d1, d2)
+		d1PLUSd2 = d1PLUSd2.Mod(d1PLUSd2, group.Order())
+		if challenge.Cmp(d1PLUSd2) != 0 {
+			failedTests[i] = "c"
+			return
+		}
 
-	for i := range zkps {
-		chWorkerVrfy <- 1
-		vrfySeqZKP.Add(1)
-
-		go func(i int) {
-			defer vrfySeqZKP.Done()
-			
-			c1x := cs[i].C1x
-			c1y := cs[i].C1y
-			c2x := cs[i].C2x
-			c2y := cs[i].C2y
-
-			a1x := zkps[i].A1x
-			a1y := zkps[i].A1y
-			b1x := zkps[i].B1x
-			b1y := zkps[i].B1y
-			a2x := zkps[i].A2x
-			a2y := zkps[i].A2y
-			b2x := zkps[i].B2x
-			b2y := zkps[i].B2y
-			d1 := zkps[i].D1
-			d2 := zkps[i].D2
-			r1 := zkps[i].R1
-			r2 := zkps[i].R2
-			
-			bigOne := big.NewInt(1)
-			d1PLUSd2 := bigOne.Add(d1, d2)
-			d1PLUSd2 = d1PLUSd2.Mod(d1PLUSd2, curve.Params().N)
-			if challenge.Cmp(d1PLUSd2) != 0 {
-				fmt.Println("c-test failed.")
-				numFailedTests += 1
-			}
-
-			gr1x, gr1y := curve.ScalarBaseMult(r1.Bytes())
-			c1d1x, c1d1y := curve.ScalarMult(c1x, c1y, d1.Bytes())
-			a1xx, a1yy := curve.Add(gr1x, gr1y, c1d1x, c1d1y)
-			if a1x.Cmp(a1xx) != 0 || a1y.Cmp(a1yy) != 0 {
-				fmt.Println("a1-test failed.")
-				numFailedTests += 1
-			}
-
-			hr1x, hr1y := curve.ScalarMult(pk.Hx, pk.Hy, r1.Bytes())
-			c2gx, c2gy := curve.Add(c2x, c2y, pk.Gx, pk.Gy)
-			c2gd1x, c2gd1y := curve.ScalarMult(c2gx, c2gy, d1.Bytes())
-			b1xx, b1yy := curve.Add(hr1x, hr1y, c2gd1x, c2gd1y)
-			if b1x.Cmp(b1xx) != 0 || b1y.Cmp(b1yy) != 0 {
-				fmt.Println("b1-test failed.")
-				numFailedTests += 1
-			}
-
-			gr2x, gr2y := curve.ScalarBaseMult(r2.Bytes())
-			c1d2x, c1d2y := curve.ScalarMult(c1x, c1y, d2.Bytes())
-			a2xx, a2yy := curve.Add(gr2x, gr2y, c1d2x, c1d2y)
-			if a2x.Cmp(a2xx) != 0 || a2y.Cmp(a2yy) != 0 {
-				fmt.Println("a2-test failed.")
-				numFailedTests += 1
-			}
-
-			invOne := big.NewInt(1).Mod(big.NewInt(-1), curve.Params().N)
-			invGx, invGy := curve.ScalarBaseMult(invOne.Bytes())
-			hr2x, hr2y := curve.ScalarMult(pk.Hx, pk.Hy, r2.Bytes())
-			c2invgx, c2invgy := curve.Add(c2x, c2y, invGx, invGy)
-			c2invgd2x, c2invgd2y := curve.ScalarMult(c2invgx, c2invgy, d2.Bytes())
-			b2xx, b2yy := curve.Add(hr2x, hr2y, c2invgd2x, c2invgd2y)
-			if b2x.Cmp(b2xx) != 0 || b2y.Cmp(b2yy) != 0 {
-				fmt.Println("b2-test failed.")
-				numFailedTests += 1
-			}
-			
-			<- chWorkerVrfy
-		}(i)
+		gr1x, gr1y := pk.baseMult(r1.Bytes())
+		c1d1x, c1d1y := group.ScalarMult(c1x, c1y, d1.Bytes())
+		a1xx, a1yy := group.Add(gr1x, gr1y, c1d1x, c1d1y)
+		if a1x.Cmp(a1xx) != 0 || a1y.Cmp(a1yy) != 0 {
+			failedTests[i] = "a1"
+			return
+		}
+
+		hr1x, hr1y := pk.pubMult(r1.Bytes())
+		c2gx, c2gy := group.Add(c2x, c2y, pk.Gx, pk.Gy)
+		c2gd1x, c2gd1y := group.ScalarMult(c2gx, c2gy, d1.Bytes())
+		b1xx, b1yy := group.Add(hr1x, hr1y, c2gd1x, c2gd1y)
+		if b1x.Cmp(b1xx) != 0 || b1y.Cmp(b1yy) != 0 {
+			failedTests[i] = "b1"
+			return
+		}
+
+		gr2x, gr2y := pk.baseMult(r2.Bytes())
+		c1d2x, c1d2y := group.ScalarMult(c1x, c1y, d2.Bytes())
+		a2xx, a2yy := group.Add(gr2x, gr2y, c1d2x, c1d2y)
+		if a2x.Cmp(a2xx) != 0 || a2y.Cmp(a2yy) != 0 {
+			failedTests[i] = "a2"
+			return
+		}
+
+		invOne := big.NewInt(1).Mod(big.NewInt(-1), group.Order())
+		invGx, invGy := pk.baseMult(invOne.Bytes())
+		hr2x, hr2y := pk.pubMult(r2.Bytes())
+		c2invgx, c2invgy := group.Add(c2x, c2y, invGx, invGy)
+		c2invgd2x, c2invgd2y := group.ScalarMult(c2invgx, c2invgy, d2.Bytes())
+		b2xx, b2yy := group.Add(hr2x, hr2y, c2invgd2x, c2invgd2y)
+		if b2x.Cmp(b2xx) != 0 || b2y.Cmp(b2yy) != 0 {
+			failedTests[i] = "b2"
+			return
+		}
 		
+	})
+	if err != nil {
+		return false, err
 	}
-	vrfySeqZKP.Wait()
-
-	return numFailedTests == 0
 
+	for i, test := range failedTests {
+		if test != "" {
+			return false, &ZKPError{Index: i, Test: test}
+		}
+	}
+	return true, nil
 }
 
 
 func (pk *PublicKey) RaiseG2M(m []byte) []byte {
 
-	curve := *pk.Curve
-	m = big.NewInt(1).SetBytes(m).Mod(big.NewInt(1).SetBytes(m), curve.Params().N).Bytes()
-	gmx, gmy := curve.ScalarBaseMult(m)
+	group := pk.Group()
+	m = big.NewInt(1).SetBytes(m).Mod(big.NewInt(1).SetBytes(m), group.Order()).Bytes()
+	gmx, gmy := pk.baseMult(m)
 	res := gmx.Bytes()
 	res = append(res, gmy.Bytes()...)
 
@@ -494,28 +568,26 @@ func (pk *PublicKey) RaiseG2M(m []byte) []byte {
 // encryption of the input plaintext
 func (sk *SecretKey) Decrypt(c *Ciphertext) []byte {
 
-	curve := *sk.Curve
+	group := sk.Group()
 
 	invSK := big.NewInt(0).SetBytes(sk.Priv)
-	invSK = invSK.Sub(curve.Params().N, invSK)
+	invSK = invSK.Sub(group.Order(), invSK)
 
-	tempx, tempy := curve.ScalarMult(c.C1x, c.C1y, invSK.Bytes())
-	resx, _ := curve.Add(c.C2x, c.C2y, tempx, tempy)
+	tempx, tempy := group.ScalarMult(c.C1x, c.C1y, invSK.Bytes())
+	resx, resy := group.Add(c.C2x, c.C2y, tempx, tempy)
 
-	res := resx.Bytes()[:len(resx.Bytes())-PaddingBytes]
-
-	return res
+	return group.Extract(resx, resy)
 }
 
 // This function, given a secret key, checks if the input ciphertext is an
 // encryption of the input plaintext
 func (sk *SecretKey) DecryptAndCheck(c *Ciphertext, test []byte) (bool) {
 
-	curve := *sk.Curve
+	group := sk.Group()
 
-	tempx, tempy := curve.ScalarMult(c.C1x, c.C1y, sk.Priv)
-	gmx, gmy := curve.ScalarBaseMult(test)
-	resx, resy := curve.Add(tempx, tempy, gmx, gmy)
+	tempx, tempy := group.ScalarMult(c.C1x, c.C1y, sk.Priv)
+	gmx, gmy := group.ScalarBaseMult(test)
+	resx, resy := group.Add(tempx, tempy, gmx, gmy)
 	if (resx.Cmp(c.C2x) == 0) && (resy.Cmp(c.C2y) == 0) {
 		return true
 	}
@@ -527,9 +599,9 @@ func (sk *SecretKey) DecryptAndCheck(c *Ciphertext, test []byte) (bool) {
 // encryption of zero
 func (sk *SecretKey) DecryptAndCheck0(c *Ciphertext) (bool) {
 
-	curve := *sk.Curve
+	group := sk.Group()
 
-	tempx, tempy := curve.ScalarMult(c.C1x, c.C1y, sk.Priv)
+	tempx, tempy := group.ScalarMult(c.C1x, c.C1y, sk.Priv)
 	if (tempx.Cmp(c.C2x) == 0) && (tempy.Cmp(c.C2y) == 0) {
 		return true
 	}
@@ -542,14 +614,14 @@ func (sk *SecretKey) DecryptAndCheck0(c *Ciphertext) (bool) {
 // the resulting ciphertext (can be seen as homomorphic addition with an encryption of zero)
 // if the input boolean variable "rand" is set to true.
 func (pk *PublicKey) Add(cA, cB *Ciphertext, rand bool) (*Ciphertext) {
-	curve := *pk.Curve
-	ctemp1x, ctemp1y := curve.Add(cA.C1x, cA.C1y, cB.C1x, cB.C1y)
-	ctemp2x, ctemp2y := curve.Add(cA.C2x, cA.C2y, cB.C2x, cB.C2y)
+	group := pk.Group()
+	ctemp1x, ctemp1y := group.Add(cA.C1x, cA.C1y, cB.C1x, cB.C1y)
+	ctemp2x, ctemp2y := group.Add(cA.C2x, cA.C2y, cB.C2x, cB.C2y)
 
 	if rand {
 		zeroCT := pk.Encrypt(big.NewInt(0))
-		randCtemp1x, randCtemp1y := curve.Add(ctemp1x, ctemp1y, zeroCT.C1x, zeroCT.C1y)
-		randCtemp2x, randCtemp2y := curve.Add(ctemp2x, ctemp2y, zeroCT.C2x, zeroCT.C2y)
+		randCtemp1x, randCtemp1y := group.Add(ctemp1x, ctemp1y, zeroCT.C1x, zeroCT.C1y)
+		randCtemp2x, randCtemp2y := group.Add(ctemp2x, ctemp2y, zeroCT.C2x, zeroCT.C2y)
 		c := &Ciphertext{randCtemp1x, randCtemp1y, randCtemp2x, randCtemp2y}
 		return c
 	}
@@ -564,147 +636,253 @@ func (pk *PublicKey) Add(cA, cB *Ciphertext, rand bool) (*Ciphertext) {
 // if the input boolean variable "rand" is set to true.
 func (pk *PublicKey) ScalarMultRandomizer(cA *Ciphertext, rand bool) (*Ciphertext) {
 
-	curve := *pk.Curve
-	scalar := newCryptoRandom(curve.Params().N.Bytes())
+	scalar := pk.randomBytes()
 
-	ctemp1x, ctemp1y := curve.ScalarMult(cA.C1x, cA.C1y, scalar)
-	ctemp2x, ctemp2y := curve.ScalarMult(cA.C2x, cA.C2y, scalar)
+	return pk.ScalarMul(cA, big.NewInt(0).SetBytes(scalar), rand)
+}
 
-	if rand {
-		zeroCT := pk.Encrypt(big.NewInt(0))
-		randCtemp1x, randCtemp1y := curve.Add(ctemp1x, ctemp1y, zeroCT.C1x, zeroCT.C1y)
-		randCtemp2x, randCtemp2y := curve.Add(ctemp2x, ctemp2y, zeroCT.C2x, zeroCT.C2y)
-		c := &Ciphertext{randCtemp1x, randCtemp1y, randCtemp2x, randCtemp2y}
-		return c
-	}
+// This function returns a ciphertext of the inverse of the input plaintext
+func (pk *PublicKey) EncryptInv(m *big.Int) *Ciphertext {
+	group := pk.Group()
+	return pk.Encrypt(m.Sub(group.Order(), m))
+}
+
+// This function returns the trivial encryption of zero, (O, O), which is the
+// neutral element of homomorphic addition
+func (pk *PublicKey) Identity() *Ciphertext {
+	group := pk.Group()
+	c1x, c1y := group.Identity()
+	c2x, c2y := group.Identity()
+	return &Ciphertext{c1x, c1y, c2x, c2y}
+}
+
+// This function returns a ciphertext of the negation of the plaintext of the
+// input ciphertext
+func (pk *PublicKey) Neg(cA *Ciphertext) *Ciphertext {
+	group := pk.Group()
+	c1x, c1y := group.Neg(cA.C1x, cA.C1y)
+	c2x, c2y := group.Neg(cA.C2x, cA.C2y)
+	return &Ciphertext{c1x, c1y, c2x, c2y}
+}
+
+// This function, given a public key, homomorphically subtracts cB from cA and
+// returns a ciphertext of the difference. The "rand" flag works as in Add.
+func (pk *PublicKey) Sub(cA, cB *Ciphertext, rand bool) *Ciphertext {
+	return pk.Add(cA, pk.Neg(cB), rand)
+}
 
+// This function, given a public key, multiplies the plaintext of the input
+// ciphertext by a known scalar k. The "rand" flag works as in Add.
+func (pk *PublicKey) ScalarMul(cA *Ciphertext, k *big.Int, rand bool) *Ciphertext {
+
+	group := pk.Group()
+	scalar := big.NewInt(0).Mod(k, group.Order()).Bytes()
+
+	ctemp1x, ctemp1y := group.ScalarMult(cA.C1x, cA.C1y, scalar)
+	ctemp2x, ctemp2y := group.ScalarMult(cA.C2x, cA.C2y, scalar)
 	c := &Ciphertext{ctemp1x, ctemp1y, ctemp2x, ctemp2y}
+
+	if rand {
+		return pk.Rerandomize(c)
+	}
 	return c
 }
 
-// This function returns a ciphertext of the inverse of the input plaintext
-func (pk *PublicKey) EncryptInv(m *big.Int) *Ciphertext {
-	curve := *pk.Curve
-	return pk.Encrypt(m.Sub(curve.Params().N, m))
+// This function returns a fresh ciphertext of the same plaintext by adding an
+// encryption of zero
+func (pk *PublicKey) Rerandomize(cA *Ciphertext) *Ciphertext {
+	return pk.Add(cA, pk.Encrypt(big.NewInt(0)), false)
+}
+
+// This function checks if two ciphertexts are identical. Two encryptions of
+// the same plaintext under different randomness are not Equal.
+func (pk *PublicKey) Equal(cA, cB *Ciphertext) bool {
+	return cA.C1x.Cmp(cB.C1x) == 0 && cA.C1y.Cmp(cB.C1y) == 0 &&
+		cA.C2x.Cmp(cB.C2x) == 0 && cA.C2y.Cmp(cB.C2y) == 0
+}
+
+// This function returns a uniformly random scalar in [0, N)
+func (pk *PublicKey) RandomScalar() *big.Int {
+	return big.NewInt(0).SetBytes(pk.randomBytes())
 }
 
 // This function returns a random number smaller than max
 func newCryptoRandom(max []byte) []byte {
+	return newCryptoRandomFrom(rand.Reader, max)
+}
+
+// This function returns a random number smaller than max drawn from r. It
+// panics if r fails, since no encryption or proof is safe without randomness.
+func newCryptoRandomFrom(r io.Reader, max []byte) []byte {
 	maxInt := big.NewInt(0).SetBytes(max)
-	rand, err := rand.Int(rand.Reader, maxInt)
+	rand, err := rand.Int(r, maxInt)
 	if err != nil {
-		log.Println(err)
+		panic("elgamal: reading randomness: " + err.Error())
 	}
 
 	return rand.Bytes()
 }
 
-// This function initializes and returns the chosen curve
-func initCurve(secParam int) *elliptic.Curve {
-	//curve := elliptic.P192()
-	var curve elliptic.Curve
-
-	if secParam == 224 {
-		curve = elliptic.P224()
-	} else if secParam == 256 {
-		curve = elliptic.P256()
-	} else if secParam == 384 {
-		curve = elliptic.P384()
-	} else if secParam == 521 {
-		curve = elliptic.P521()
-	} else {}
+// This function resolves the group named in the public key, or the NIST curve
+// matching SecParam for keys without a group name, and checks that the key
+// lies in it. Keys already resolved, by KeyGen or an earlier call, are left
+// untouched, so that a deployed query can be used from many goroutines.
+func (pk *PublicKey) InitCurve() error {
+	if pk.group != nil {
+		return nil
+	}
+	group, err := resolveGroup(pk.GroupName, pk.SecParam)
+	if err != nil {
+		return err
+	}
+	if pk.Gx == nil || pk.Gy == nil || pk.Hx == nil || pk.Hy == nil {
+		return errors.New("incomplete public key")
+	}
+	Gx, Gy := group.Generator()
+	if pk.Gx.Cmp(Gx) != 0 || pk.Gy.Cmp(Gy) != 0 {
+		return errors.New("public key generator does not match " + group.Name())
+	}
+	if !group.IsOnGroup(pk.Hx, pk.Hy) || group.IsIdentity(pk.Hx, pk.Hy) {
+		return errors.New("public key is not a valid " + group.Name() + " element")
+	}
+	pk.GroupName = group.Name()
+	pk.group = group
+	return nil
+}
 
-	return &curve
+// This function returns the group of the public key. Keys that have not been
+// through KeyGen or InitCurve resolve it from GroupName or SecParam on every
+// call, and an unknown group is fatal.
+func (pk *PublicKey) Group() Group {
+	if pk.group != nil {
+		return pk.group
+	}
+	group, err := resolveGroup(pk.GroupName, pk.SecParam)
+	if err != nil {
+		panic(err)
+	}
+	return group
 }
 
-// This function initializes a curve under the public key
-func (pk *PublicKey) InitCurve() {
-	pk.Curve = initCurve(pk.SecParam)
+// This function returns the group of the secret key
+func (sk *SecretKey) Group() Group {
+	if sk.group != nil {
+		return sk.group
+	}
+	group, err := GroupByName(sk.GroupName)
+	if err != nil {
+		panic(err)
+	}
+	return group
 }
 
 // This function encodes a ciphertext struct to bytes
 func (pk *PublicKey) Ciphertext2Bytes(ciphertext *Ciphertext, pointCompression bool) (*CiphertextByte) {
 	//curve := initCurve(pk.SecParam)
-	curve := *pk.Curve
-	var C1, C2 []byte
-	if pointCompression {
-		C1 = elliptic.MarshalCompressed(curve, ciphertext.C1x, ciphertext.C1y)
-		C2 = elliptic.MarshalCompressed(curve, ciphertext.C2x, ciphertext.C2y)
-	} else {
-		C1 = elliptic.Marshal(curve, ciphertext.C1x, ciphertext.C1y)
-		C2 = elliptic.Marshal(curve, ciphertext.C2x, ciphertext.C2y)
-	}
+	group := pk.Group()
+	C1 := group.Marshal(ciphertext.C1x, ciphertext.C1y, pointCompression)
+	C2 := group.Marshal(ciphertext.C2x, ciphertext.C2y, pointCompression)
 
 	ciphertextBytes := &CiphertextByte{C1, C2}
 	return ciphertextBytes
 }
 
-// This function decodes ciphertext bytes back to a ciphertext struct
-func (pk *PublicKey) Bytes2Ciphertext(ciphertextBytes *CiphertextByte, pointCompression bool) (*Ciphertext) {
-	//curve := initCurve(pk.SecParam)
-	curve := *pk.Curve
-	var C1x, C1y, C2x, C2y *big.Int
-	if pointCompression {
-		C1x, C1y = elliptic.UnmarshalCompressed(curve, ciphertextBytes.C1)
-		C2x, C2y = elliptic.UnmarshalCompressed(curve, ciphertextBytes.C2)
-	} else {
-		C1x, C1y = elliptic.Unmarshal(curve, ciphertextBytes.C1)
-		C2x, C2y = elliptic.Unmarshal(curve, ciphertextBytes.C2)
+// This function decodes ciphertext bytes back to a ciphertext struct. It
+// rejects invalid encodings, points outside the group and the identity.
+func (pk *PublicKey) Bytes2Ciphertext(ciphertextBytes *CiphertextByte, pointCompression bool) (*Ciphertext, error) {
+	if ciphertextBytes == nil {
+		return nil, errors.New("missing ciphertext")
+	}
+	C1x, C1y, err := pk.decodeElement(ciphertextBytes.C1, pointCompression)
+	if err != nil {
+		return nil, err
+	}
+	C2x, C2y, err := pk.decodeElement(ciphertextBytes.C2, pointCompression)
+	if err != nil {
+		return nil, err
 	}
 
 	ciphertext := &Ciphertext{C1x, C1y, C2x, C2y}
-	// if !pk.CheckOnCurve(ciphertext) {
-	// 	os.Exit(1) // ill-formed ciphertexts detected
-	// }
-	return ciphertext
+	return ciphertext, nil
 }
 
 // This function encodes a ciphertext struct to bytes
 func (pk *PublicKey) ZKP2Bytes(zkp *ZKP, pointCompression bool) (*ZKPByte) {
 	//curve := initCurve(pk.SecParam)
-	curve := *pk.Curve
-	var A1, A2, B1, B2 []byte
-	if pointCompression {
-		A1 = elliptic.MarshalCompressed(curve, zkp.A1x, zkp.A1y)
-		A2 = elliptic.MarshalCompressed(curve, zkp.A2x, zkp.A2y)
-		B1 = elliptic.MarshalCompressed(curve, zkp.B1x, zkp.B1y)
-		B2 = elliptic.MarshalCompressed(curve, zkp.B2x, zkp.B2y)
-	} else {
-		A1 = elliptic.Marshal(curve, zkp.A1x, zkp.A1y)
-		A2 = elliptic.Marshal(curve, zkp.A2x, zkp.A2y)
-		B1 = elliptic.Marshal(curve, zkp.B1x, zkp.B1y)
-		B2 = elliptic.Marshal(curve, zkp.B2x, zkp.B2y)
-	}
+	group := pk.Group()
+	A1 := group.Marshal(zkp.A1x, zkp.A1y, pointCompression)
+	A2 := group.Marshal(zkp.A2x, zkp.A2y, pointCompression)
+	B1 := group.Marshal(zkp.B1x, zkp.B1y, pointCompression)
+	B2 := group.Marshal(zkp.B2x, zkp.B2y, pointCompression)
 
 	zkpBytes := &ZKPByte{A1, B1, A2, B2, zkp.D1.Bytes(), zkp.D2.Bytes(), zkp.R1.Bytes(), zkp.R2.Bytes()}
 	return zkpBytes
 }
 
-// This function decodes ciphertext bytes back to a ciphertext struct
-func (pk *PublicKey) Bytes2ZKP(zkpBytes *ZKPByte, pointCompression bool) (*ZKP) {
-	//curve := initCurve(pk.SecParam)
-	curve := *pk.Curve
-	var A1x, A1y, A2x, A2y, B1x, B1y, B2x, B2y *big.Int
-	if pointCompression {
-		A1x, A1y = elliptic.UnmarshalCompressed(curve, zkpBytes.A1)
-		A2x, A2y = elliptic.UnmarshalCompressed(curve, zkpBytes.A2)
-		B1x, B1y = elliptic.UnmarshalCompressed(curve, zkpBytes.B1)
-		B2x, B2y = elliptic.UnmarshalCompressed(curve, zkpBytes.B2)
-	} else {
-		A1x, A1y = elliptic.Unmarshal(curve, zkpBytes.A1)
-		A2x, A2y = elliptic.Unmarshal(curve, zkpBytes.A2)
-		B1x, B1y = elliptic.Unmarshal(curve, zkpBytes.B1)
-		B2x, B2y = elliptic.Unmarshal(curve, zkpBytes.B2)
+// This function decodes proof bytes back to a proof struct. It rejects invalid
+// encodings, points outside the group, the identity and scalars outside [0, N).
+func (pk *PublicKey) Bytes2ZKP(zkpBytes *ZKPByte, pointCompression bool) (*ZKP, error) {
+	if zkpBytes == nil {
+		return nil, errors.New("missing proof")
+	}
+
+	points := [][]byte{zkpBytes.A1, zkpBytes.B1, zkpBytes.A2, zkpBytes.B2}
+	coords := make([]*big.Int, 0, 2*len(points))
+	for _, point := range points {
+		x, y, err := pk.decodeElement(point, pointCompression)
+		if err != nil {
+			return nil, err
+		}
+		coords = append(coords, x, y)
+	}
+
+	scalarBytes := [][]byte{zkpBytes.D1, zkpBytes.D2, zkpBytes.R1, zkpBytes.R2}
+	scalars := make([]*big.Int, len(scalarBytes))
+	for i, b := range scalarBytes {
+		scalar, err := pk.DecodeScalar(b)
+		if err != nil {
+			return nil, err
+		}
+		scalars[i] = scalar
 	}
 
-	zkp := &ZKP{A1x, A1y, B1x, B1y, A2x, A2y, B2x, B2y, big.NewInt(1).SetBytes(zkpBytes.D1), big.NewInt(1).SetBytes(zkpBytes.D2), big.NewInt(1).SetBytes(zkpBytes.R1), big.NewInt(1).SetBytes(zkpBytes.R2)} 
+	zkp := &ZKP{coords[0], coords[1], coords[2], coords[3], coords[4], coords[5], coords[6], coords[7], scalars[0], scalars[1], scalars[2], scalars[3]}
+	return zkp, nil
+}
+
+// This function decodes a big-endian scalar received from a peer and rejects
+// values outside [0, N)
+func (pk *PublicKey) DecodeScalar(b []byte) (*big.Int, error) {
+	order := pk.Group().Order()
+	if len(b) > len(order.Bytes()) {
+		return nil, errors.New("scalar too long")
+	}
+	scalar := big.NewInt(0).SetBytes(b)
+	if scalar.Cmp(order) >= 0 {
+		return nil, errors.New("scalar out of range")
+	}
+	return scalar, nil
+}
 
-	return zkp }
+// This function decodes a group element received from a peer. Group.Unmarshal
+// already rejects encodings of points outside the group; the identity is
+// rejected here because no honest ciphertext or proof contains it.
+func (pk *PublicKey) decodeElement(data []byte, pointCompression bool) (*big.Int, *big.Int, error) {
+	group := pk.Group()
+	x, y, err := group.Unmarshal(data, pointCompression)
+	if err != nil {
+		return nil, nil, err
+	}
+	if group.IsIdentity(x, y) {
+		return nil, nil, errors.New("unexpected identity element")
+	}
+	return x, y, nil
+}
 
 // This function checks if a given ciphertext is a well-formed ciphertext
 func (pk *PublicKey) CheckOnCurve(ciphertext *Ciphertext) bool {
-	curve := *pk.Curve
-	return curve.IsOnCurve(ciphertext.C1x, ciphertext.C1y) && curve.IsOnCurve(ciphertext.C2x, ciphertext.C2y)
+	group := pk.Group()
+	return group.IsOnGroup(ciphertext.C1x, ciphertext.C1y) && group.IsOnGroup(ciphertext.C2x, ciphertext.C2y)
 }
 
 func HashSha256(input []byte) []byte {
@@ -0,0 +1,174 @@
+package elgamal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// Plaintexts of the homomorphism tests stay within this bound, so that
+// DecryptSmall recovers them
+const testDlogBound = 1 << 12
+
+func forEachGroup(t *testing.T, f func(t *testing.T, pk *PublicKey, sk *SecretKey, table *DlogTable)) {
+	for _, name := range GroupNames() {
+		group, err := GroupByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(name, func(t *testing.T) {
+			pk, sk, err := KeyGenInGroup(group, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f(t, pk, sk, CachedDlogTable(group, testDlogBound))
+		})
+	}
+}
+
+func decryptSmall(t *testing.T, sk *SecretKey, c *Ciphertext, table *DlogTable) int64 {
+	t.Helper()
+	m, err := sk.DecryptSmall(c, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestEncryptWithRandomness(t *testing.T) {
+	forEachGroup(t, func(t *testing.T, pk *PublicKey, sk *SecretKey, table *DlogTable) {
+		z := pk.RandomScalar()
+		c := pk.EncryptWithRandomness(big.NewInt(42), z)
+		if m := decryptSmall(t, sk, c, table); m != 42 {
+			t.Fatalf("Dec(EncryptWithRandomness(42)) = %d", m)
+		}
+		if !pk.Equal(c, pk.EncryptWithRandomness(big.NewInt(42), z)) {
+			t.Fatal("same randomness gave different ciphertexts")
+		}
+	})
+}
+
+func TestIdentity(t *testing.T) {
+	forEachGroup(t, func(t *testing.T, pk *PublicKey, sk *SecretKey, table *DlogTable) {
+		if !sk.DecryptAndCheck0(pk.Identity()) {
+			t.Fatal("Identity is not an encryption of zero")
+		}
+		c := pk.Encrypt(big.NewInt(7))
+		if !pk.Equal(pk.Add(c, pk.Identity(), false), c) {
+			t.Fatal("adding Identity changed the ciphertext")
+		}
+	})
+}
+
+func TestNeg(t *testing.T) {
+	forEachGroup(t, func(t *testing.T, pk *PublicKey, sk *SecretKey, table *DlogTable) {
+		for _, m := range []int64{0, 1, 5, 1000} {
+			if got := decryptSmall(t, sk, pk.Neg(pk.Encrypt(big.NewInt(m))), table); got != -m {
+				t.Fatalf("Dec(Neg(Enc(%d))) = %d", m, got)
+			}
+		}
+	})
+}
+
+func TestSub(t *testing.T) {
+	forEachGroup(t, func(t *testing.T, pk *PublicKey, sk *SecretKey, table *DlogTable) {
+		for _, ms := range [][2]int64{{9, 4}, {4, 9}, {17, 17}, {0, 3}} {
+			cA, cB := pk.Encrypt(big.NewInt(ms[0])), pk.Encrypt(big.NewInt(ms[1]))
+			for _, rand := range []bool{false, true} {
+				if got := decryptSmall(t, sk, pk.Sub(cA, cB, rand), table); got != ms[0]-ms[1] {
+					t.Fatalf("Dec(Sub(Enc(%d), Enc(%d))) = %d (rand %v)", ms[0], ms[1], got, rand)
+				}
+			}
+		}
+	})
+}
+
+func TestScalarMul(t *testing.T) {
+	forEachGroup(t, func(t *testing.T, pk *PublicKey, sk *SecretKey, table *DlogTable) {
+		for _, mk := range [][2]int64{{3, 7}, {0, 11}, {12, 0}, {5, -4}} {
+			c := pk.Encrypt(big.NewInt(mk[0]))
+			for _, rand := range []bool{false, true} {
+				if got := decryptSmall(t, sk, pk.ScalarMul(c, big.NewInt(mk[1]), rand), table); got != mk[0]*mk[1] {
+					t.Fatalf("Dec(ScalarMul(%d, Enc(%d))) = %d (rand %v)", mk[1], mk[0], got, rand)
+				}
+			}
+		}
+	})
+}
+
+func TestRerandomize(t *testing.T) {
+	forEachGroup(t, func(t *testing.T, pk *PublicKey, sk *SecretKey, table *DlogTable) {
+		c := pk.Encrypt(big.NewInt(23))
+		r := pk.Rerandomize(c)
+		if got := decryptSmall(t, sk, r, table); got != 23 {
+			t.Fatalf("Dec(Rerandomize(Enc(23))) = %d", got)
+		}
+		if pk.Equal(c, r) {
+			t.Fatal("Rerandomize returned the same ciphertext")
+		}
+		cb, rb := pk.Ciphertext2Bytes(c, true), pk.Ciphertext2Bytes(r, true)
+		if string(cb.C1) == string(rb.C1) || string(cb.C2) == string(rb.C2) {
+			t.Fatal("Rerandomize kept part of the encoding")
+		}
+	})
+}
+
+func TestVerifySeqZKPReportsIndex(t *testing.T) {
+	forEachGroup(t, func(t *testing.T, pk *PublicKey, sk *SecretKey, table *DlogTable) {
+		filter := func() []*big.Int {
+			return []*big.Int{big.NewInt(1), big.NewInt(-1), big.NewInt(-1), big.NewInt(1)}
+		}
+		verify := func(cs []*Ciphertext, zkps []*ZKP, challenge *big.Int) *ZKPError {
+			t.Helper()
+			valid, err := pk.VerifySeqZKPContext(context.Background(), cs, zkps, challenge, 1)
+			if err == nil {
+				if !valid {
+					t.Fatal("proofs rejected without an error")
+				}
+				return nil
+			}
+			var zkpErr *ZKPError
+			if valid || !errors.As(err, &zkpErr) || !errors.Is(err, ErrInvalidZKP) {
+				t.Fatalf("got %v, %v", valid, err)
+			}
+			return zkpErr
+		}
+
+		cs, zkps, challenge := pk.EncryptSeqWithZKP(filter(), 1)
+		if zkpErr := verify(cs, zkps, challenge); zkpErr != nil {
+			t.Fatalf("valid proofs rejected: %v", zkpErr)
+		}
+
+		// the challenge hashes the ciphertexts and commitments
+		swapped := append([]*Ciphertext{}, cs...)
+		swapped[2] = pk.Encrypt(big.NewInt(-1))
+		if zkpErr := verify(swapped, zkps, challenge); zkpErr == nil || zkpErr.Index != -1 {
+			t.Fatalf("replaced ciphertext gave %v, want a challenge mismatch", zkpErr)
+		}
+
+		// but not the responses
+		zkps[2].R1 = big.NewInt(0).Add(zkps[2].R1, big.NewInt(1))
+		if zkpErr := verify(cs, zkps, challenge); zkpErr == nil || zkpErr.Index != 2 || zkpErr.Test != "a1" {
+			t.Fatalf("tampered response gave %v, want the a1-test at index 2", zkpErr)
+		}
+	})
+}
+
+// A randomness source that runs dry must stop the encryption with a clear
+// panic rather than a nil dereference
+func TestRandomSourceFailure(t *testing.T) {
+	forEachGroup(t, func(t *testing.T, pk *PublicKey, sk *SecretKey, table *DlogTable) {
+		pk.SetRandomSource(bytes.NewReader(make([]byte, 8)))
+		defer pk.SetRandomSource(nil)
+		defer func() {
+			msg, _ := recover().(string)
+			if !strings.HasPrefix(msg, "elgamal: reading randomness") {
+				t.Fatalf("got panic %q", msg)
+			}
+		}()
+		pk.Encrypt(big.NewInt(1))
+	})
+}
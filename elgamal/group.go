@@ -0,0 +1,134 @@
+package elgamal
+
+import (
+	"errors"
+	"math/big"
+)
+
+// Group is a prime-order group in which the EC-ElGamal scheme runs. Elements
+// are passed around as a pair of coordinates so that ciphertexts, proofs and
+// the Fiat-Shamir transcripts keep their shape across implementations; each
+// implementation decides how the pair represents an element and must hand out
+// one unique pair per element.
+type Group interface {
+	// Name identifies the group on the wire, e.g. "P-256" or "ristretto255"
+	Name() string
+	Order() *big.Int
+	Generator() (x, y *big.Int)
+	Identity() (x, y *big.Int)
+	IsIdentity(x, y *big.Int) bool
+	// IsOnGroup reports whether (x, y) is a valid element, the identity included
+	IsOnGroup(x, y *big.Int) bool
+
+	Add(x1, y1, x2, y2 *big.Int) (x, y *big.Int)
+	Neg(x, y *big.Int) (*big.Int, *big.Int)
+	ScalarMult(x, y *big.Int, k []byte) (*big.Int, *big.Int)
+	ScalarBaseMult(k []byte) (*big.Int, *big.Int)
+
+	// Marshal and Unmarshal convert between elements and their wire encoding.
+	// Groups with a single encoding ignore the compressed flag. Unmarshal
+	// must reject any input that does not encode an element of the group.
+	Marshal(x, y *big.Int, compressed bool) []byte
+	Unmarshal(data []byte, compressed bool) (x, y *big.Int, err error)
+
+	// HashToGroup maps a message to an element with unknown discrete log
+	HashToGroup(msg, dst []byte) (x, y *big.Int)
+	// Embed maps a short message to an element such that Extract recovers it
+	Embed(msg []byte) (x, y *big.Int, err error)
+	Extract(x, y *big.Int) []byte
+}
+
+// The groups below can add many elements, or compute Σ ks[i]·P_i, faster than
+// one Add or ScalarMult per term
+type summingGroup interface {
+	Sum(xs, ys []*big.Int) (*big.Int, *big.Int)
+}
+
+type multiScalarGroup interface {
+	MultiScalarMult(xs, ys, ks []*big.Int) (*big.Int, *big.Int)
+}
+
+// Groups that can keep the per-point tables of their multi-scalar
+// multiplication for points used many times (see PreparedCiphertext).
+// newMSMTable returns nil where tables do not pay off.
+type tableGroup interface {
+	newMSMTable(x, y *big.Int) msmTable
+	multiScalarMultTables(ts []msmTable, ks []*big.Int) (*big.Int, *big.Int)
+}
+
+// msmTable is a group-specific table built by newMSMTable
+type msmTable interface{}
+
+var groupsByName = map[string]func() Group{
+	"P-224":        func() Group { return nistP224 },
+	"P-256":        func() Group { return nistP256 },
+	"P-384":        func() Group { return nistP384 },
+	"P-521":        func() Group { return nistP521 },
+	"ristretto255": func() Group { return ristretto255 },
+}
+
+var groupsBySecParam = map[int]string{
+	224: "P-224",
+	256: "P-256",
+	384: "P-384",
+	521: "P-521",
+}
+
+// This function returns the group registered under the given name
+func GroupByName(name string) (Group, error) {
+	g, ok := groupsByName[name]
+	if !ok {
+		return nil, errors.New("unknown group: " + name)
+	}
+	return g(), nil
+}
+
+// This function returns the NIST curve for a key length of 224, 256, 384 or
+// 521 bits
+func GroupBySecParam(secParam int) (Group, error) {
+	name, ok := groupsBySecParam[secParam]
+	if !ok {
+		return nil, errors.New("unsupported key length: " + big.NewInt(int64(secParam)).String())
+	}
+	return GroupByName(name)
+}
+
+// This function returns the names of all supported groups
+func GroupNames() []string {
+	return []string{"P-224", "P-256", "P-384", "P-521", "ristretto255"}
+}
+
+// This function resolves a group from its name or, for keys that predate
+// group names, from the key length
+func resolveGroup(name string, secParam int) (Group, error) {
+	if name != "" {
+		return GroupByName(name)
+	}
+	return GroupBySecParam(secParam)
+}
+
+// This function returns the sum of the given elements
+func groupSum(g Group, xs, ys []*big.Int) (*big.Int, *big.Int) {
+	if s, ok := g.(summingGroup); ok {
+		return s.Sum(xs, ys)
+	}
+	x, y := g.Identity()
+	for i := range xs {
+		x, y = g.Add(x, y, xs[i], ys[i])
+	}
+	return x, y
+}
+
+// This function returns Σ ks[i]·(xs[i], ys[i])
+func groupMultiScalarMult(g Group, xs, ys, ks []*big.Int) (*big.Int, *big.Int) {
+	if m, ok := g.(multiScalarGroup); ok {
+		return m.MultiScalarMult(xs, ys, ks)
+	}
+	x, y := g.Identity()
+	for i := range xs {
+		k := big.NewInt(0).Mod(ks[i], g.Order())
+		tx, ty := g.ScalarMult(xs[i], ys[i], k.Bytes())
+		x, y = g.Add(x, y, tx, ty)
+	}
+	return x, y
+}
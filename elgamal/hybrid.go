@@ -0,0 +1,139 @@
+package elgamal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"math/big"
+)
+
+// HybridCiphertext carries an ElGamal encryption of a random group element
+// (the KEM part) and an AES-GCM encryption of an arbitrary-length payload under
+// a key derived from that element (the DEM part).
+type HybridCiphertext struct {
+	KEM *Ciphertext
+	DEM []byte
+}
+
+type HybridCiphertextByte struct {
+	KEM *CiphertextByte
+	DEM []byte
+}
+
+const hybridKeyLabel = "bhwmonitoring-go/hybrid-kem/v1"
+
+// This function picks a random group element, encrypts it under the public key
+// and returns the ciphertext together with the AES-256 key derived from the element
+func (pk *PublicKey) EncapsulateKey() (*Ciphertext, []byte) {
+
+	group := pk.Group()
+	r := pk.randomBytes()
+	mx, my := pk.baseMult(r)
+
+	z := pk.randomBytes()
+	c1x, c1y := pk.baseMult(z)
+	Hzx, Hzy := pk.pubMult(z)
+	c2x, c2y := group.Add(mx, my, Hzx, Hzy)
+
+	return &Ciphertext{c1x, c1y, c2x, c2y}, deriveHybridKey(group, mx, my)
+}
+
+// This function, given a secret key, recovers the group element encrypted in
+// the input ciphertext and returns the AES-256 key derived from it
+func (sk *SecretKey) DecapsulateKey(c *Ciphertext) []byte {
+
+	group := sk.Group()
+
+	invSK := big.NewInt(0).SetBytes(sk.Priv)
+	invSK = invSK.Sub(group.Order(), invSK)
+
+	tempx, tempy := group.ScalarMult(c.C1x, c.C1y, invSK.Bytes())
+	mx, my := group.Add(c.C2x, c.C2y, tempx, tempy)
+
+	return deriveHybridKey(group, mx, my)
+}
+
+// This function encrypts an arbitrary-length payload under the public key
+func (pk *PublicKey) EncryptHybrid(payload []byte) (*HybridCiphertext, error) {
+
+	kem, key := pk.EncapsulateKey()
+	dem, err := SealPayload(key, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HybridCiphertext{kem, dem}, nil
+}
+
+// This function decrypts a hybrid ciphertext and returns the payload. It fails
+// if the KEM part does not decrypt to the element the DEM key was derived from.
+func (sk *SecretKey) DecryptHybrid(c *HybridCiphertext) ([]byte, error) {
+	return OpenPayload(sk.DecapsulateKey(c.KEM), c.DEM)
+}
+
+// This function encrypts a payload with AES-GCM under the given key. The random
+// nonce is prepended to the output.
+func SealPayload(key, payload []byte) ([]byte, error) {
+
+	aead, err := newHybridAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, payload, []byte(hybridKeyLabel)), nil
+}
+
+// This function reverses SealPayload
+func OpenPayload(key, sealed []byte) ([]byte, error) {
+
+	aead, err := newHybridAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("sealed payload too short")
+	}
+	nonce, ct := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ct, []byte(hybridKeyLabel))
+}
+
+// This function encodes a hybrid ciphertext struct to bytes
+func (pk *PublicKey) Hybrid2Bytes(c *HybridCiphertext, pointCompression bool) *HybridCiphertextByte {
+	return &HybridCiphertextByte{pk.Ciphertext2Bytes(c.KEM, pointCompression), c.DEM}
+}
+
+// This function decodes hybrid ciphertext bytes back to a hybrid ciphertext
+// struct, validating the KEM part as Bytes2Ciphertext does
+func (pk *PublicKey) Bytes2Hybrid(cb *HybridCiphertextByte, pointCompression bool) (*HybridCiphertext, error) {
+	if cb == nil {
+		return nil, errors.New("missing hybrid ciphertext")
+	}
+	kem, err := pk.Bytes2Ciphertext(cb.KEM, pointCompression)
+	if err != nil {
+		return nil, err
+	}
+	return &HybridCiphertext{kem, cb.DEM}, nil
+}
+
+func newHybridAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func deriveHybridKey(group Group, x, y *big.Int) []byte {
+	buf := []byte(hybridKeyLabel)
+	buf = append(buf, group.Marshal(x, y, false)...)
+	return HashSha256(buf)
+}
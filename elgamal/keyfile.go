@@ -0,0 +1,215 @@
+package elgamal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	kdf "bhwmonitoring-go/internal/kdf"
+)
+
+// An encrypted key file is a PEM block of type EncryptedSecretKeyPEMType
+// whose bytes are
+//
+//	"BHWK" || version || kdf id || iterations (uint32) || salt length || salt
+//	       || nonce (12 bytes) || AES-256-GCM(binary secret key)
+//
+// Everything before the sealed key is authenticated as additional data, so a
+// tampered header fails to decrypt. The PEM headers repeat the group and the
+// fingerprint for operators and are not trusted on load.
+const EncryptedSecretKeyPEMType = "ENCRYPTED ELGAMAL PRIVATE KEY"
+
+const (
+	keyFileMagic   = "BHWK"
+	keyFileVersion = 1
+
+	kdfPBKDF2SHA256 = 1
+
+	// PBKDF2-HMAC-SHA256 work factor for new files
+	DefaultKeyFileIterations = 600000
+
+	keyFileSaltLen = 16
+)
+
+// This function encrypts a secret key under a passphrase and returns the
+// PEM-armored key file
+func EncryptSecretKey(sk *SecretKey, passphrase []byte) ([]byte, error) {
+	return EncryptSecretKeyWithIterations(sk, passphrase, DefaultKeyFileIterations)
+}
+
+// This function works as EncryptSecretKey with a custom PBKDF2 work factor
+func EncryptSecretKeyWithIterations(sk *SecretKey, passphrase []byte, iterations int) ([]byte, error) {
+
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	if iterations < 1 || iterations > 1<<31-1 {
+		return nil, errors.New("invalid PBKDF2 iteration count")
+	}
+
+	plain, err := sk.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	defer kdf.Wipe(plain)
+
+	salt := make([]byte, keyFileSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	header := []byte(keyFileMagic)
+	header = append(header, keyFileVersion, kdfPBKDF2SHA256)
+	header = append(header, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(header[len(header)-4:], uint32(iterations))
+	header = append(header, byte(len(salt)))
+	header = append(header, salt...)
+
+	aead, err := keyFileAEAD(passphrase, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := append(header, nonce...)
+	out = aead.Seal(out, nonce, plain, header)
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:    EncryptedSecretKeyPEMType,
+		Headers: map[string]string{"Group": sk.Group().Name(), "Fingerprint": sk.Fingerprint()},
+		Bytes:   out,
+	}), nil
+}
+
+// This function decrypts a PEM-armored key file with the passphrase
+func DecryptSecretKey(data, passphrase []byte) (*SecretKey, error) {
+
+	block, err := decodePEM(data, EncryptedSecretKeyPEMType)
+	if err != nil {
+		return nil, err
+	}
+	b := block.Bytes
+
+	if len(b) < len(keyFileMagic)+7 || string(b[:len(keyFileMagic)]) != keyFileMagic {
+		return nil, errors.New("not an encrypted key file")
+	}
+	pos := len(keyFileMagic)
+	if b[pos] != keyFileVersion {
+		return nil, errors.New("unsupported key file version")
+	}
+	if b[pos+1] != kdfPBKDF2SHA256 {
+		return nil, errors.New("unsupported key derivation function")
+	}
+	iterations := binary.BigEndian.Uint32(b[pos+2:])
+	if iterations < 1 || iterations > 1<<31-1 {
+		return nil, errors.New("invalid PBKDF2 iteration count")
+	}
+	saltLen := int(b[pos+6])
+	pos += 7
+	if len(b) < pos+saltLen {
+		return nil, errors.New("truncated key file")
+	}
+	salt := b[pos : pos+saltLen]
+	pos += saltLen
+	header := b[:pos]
+
+	aead, err := keyFileAEAD(passphrase, salt, int(iterations))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < pos+aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("truncated key file")
+	}
+	nonce := b[pos : pos+aead.NonceSize()]
+
+	plain, err := aead.Open(nil, nonce, b[pos+aead.NonceSize():], header)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted key file")
+	}
+	defer kdf.Wipe(plain)
+
+	return ParseSecretKey(plain)
+}
+
+// This function writes a secret key, encrypted under the passphrase, to path.
+// The file is created with mode 0600 and replaced atomically.
+func SaveSecretKey(path string, sk *SecretKey, passphrase []byte) error {
+	data, err := EncryptSecretKey(sk, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// This function reads and decrypts a key file written by SaveSecretKey
+func LoadSecretKey(path string, passphrase []byte) (*SecretKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptSecretKey(data, passphrase)
+}
+
+// This function re-encrypts the key file at path under a new passphrase
+func ChangeKeyFilePassphrase(path string, oldPassphrase, newPassphrase []byte) error {
+	sk, err := LoadSecretKey(path, oldPassphrase)
+	if err != nil {
+		return err
+	}
+	defer sk.Wipe()
+	return SaveSecretKey(path, sk, newPassphrase)
+}
+
+// This function overwrites the private scalar of a secret key with zeros. The
+// key is unusable afterwards. Copies the runtime made of it, e.g. inside
+// big.Int arithmetic, cannot be reached and are not wiped.
+func (sk *SecretKey) Wipe() {
+	kdf.Wipe(sk.Priv)
+}
+
+func keyFileAEAD(passphrase, salt []byte, iterations int) (cipher.AEAD, error) {
+	key := kdf.PBKDF2(sha256.New, passphrase, salt, iterations, 32)
+	defer kdf.Wipe(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,133 @@
+package elgamal
+
+import (
+	"encoding/binary"
+	"encoding/pem"
+	"testing"
+)
+
+// A low work factor keeps the tests fast; the format is the same
+const testKeyFileIterations = 1000
+
+// Offsets into the bytes of an encrypted key file
+const (
+	keyFileIterationsAt = len(keyFileMagic) + 2
+	keyFileSaltLenAt    = len(keyFileMagic) + 6
+	keyFileSaltAt       = keyFileSaltLenAt + 1
+	keyFileNonceAt      = keyFileSaltAt + keyFileSaltLen
+	keyFileSealedAt     = keyFileNonceAt + 12
+)
+
+// This function re-armors the key file after tamper changed its bytes
+func tamperedKeyFile(t *testing.T, file []byte, tamper func(b []byte) []byte) []byte {
+	t.Helper()
+	block, _ := pem.Decode(file)
+	if block == nil {
+		t.Fatal("key file is not PEM")
+	}
+	b := append([]byte(nil), block.Bytes...)
+	block.Bytes = tamper(b)
+	return pem.EncodeToMemory(block)
+}
+
+func TestKeyFileRoundTrip(t *testing.T) {
+	forEachGroup(t, func(t *testing.T, pk *PublicKey, sk *SecretKey, table *DlogTable) {
+
+		file, err := EncryptSecretKeyWithIterations(sk, []byte("Simba"), testKeyFileIterations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(file)
+		if block == nil || block.Type != EncryptedSecretKeyPEMType || block.Headers["Fingerprint"] != sk.Fingerprint() {
+			t.Fatal("key file is not armored as an encrypted key")
+		}
+		if got := binary.BigEndian.Uint32(block.Bytes[keyFileIterationsAt:]); got != testKeyFileIterations {
+			t.Fatalf("key file records %d iterations", got)
+		}
+
+		decrypted, err := DecryptSecretKey(file, []byte("Simba"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sameSecretKey(t, sk, decrypted)
+
+		// fresh salt and nonce for every file
+		again, err := EncryptSecretKeyWithIterations(sk, []byte("Simba"), testKeyFileIterations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(again) == string(file) {
+			t.Fatal("two encryptions of the key gave the same file")
+		}
+	})
+}
+
+func TestKeyFileRejects(t *testing.T) {
+
+	group, err := GroupByName("P-256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, sk, err := KeyGenInGroup(group, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := EncryptSecretKeyWithIterations(sk, []byte("Simba"), testKeyFileIterations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flip := func(at int) func(b []byte) []byte {
+		return func(b []byte) []byte {
+			b[at] ^= 1
+			return b
+		}
+	}
+	iterations := func(n uint32) func(b []byte) []byte {
+		return func(b []byte) []byte {
+			binary.BigEndian.PutUint32(b[keyFileIterationsAt:], n)
+			return b
+		}
+	}
+	truncate := func(n int) func(b []byte) []byte {
+		return func(b []byte) []byte { return b[:n] }
+	}
+
+	for _, c := range []struct {
+		name       string
+		file       []byte
+		passphrase string
+	}{
+		{"wrong passphrase", file, "Nala"},
+		{"empty passphrase", file, ""},
+		{"magic", tamperedKeyFile(t, file, flip(0)), "Simba"},
+		{"version", tamperedKeyFile(t, file, flip(len(keyFileMagic))), "Simba"},
+		{"key derivation function", tamperedKeyFile(t, file, flip(len(keyFileMagic)+1)), "Simba"},
+		{"fewer iterations", tamperedKeyFile(t, file, iterations(testKeyFileIterations-1)), "Simba"},
+		{"more iterations", tamperedKeyFile(t, file, iterations(testKeyFileIterations+1)), "Simba"},
+		{"no iterations", tamperedKeyFile(t, file, iterations(0)), "Simba"},
+		{"iterations overflow", tamperedKeyFile(t, file, iterations(1<<31)), "Simba"},
+		{"salt length", tamperedKeyFile(t, file, flip(keyFileSaltLenAt)), "Simba"},
+		{"salt", tamperedKeyFile(t, file, flip(keyFileSaltAt)), "Simba"},
+		{"nonce", tamperedKeyFile(t, file, flip(keyFileNonceAt)), "Simba"},
+		{"sealed key", tamperedKeyFile(t, file, flip(keyFileSealedAt)), "Simba"},
+		{"tag", tamperedKeyFile(t, file, func(b []byte) []byte { return flip(len(b) - 1)(b) }), "Simba"},
+		{"empty", tamperedKeyFile(t, file, truncate(0)), "Simba"},
+		{"truncated in the header", tamperedKeyFile(t, file, truncate(keyFileSaltLenAt)), "Simba"},
+		{"truncated in the salt", tamperedKeyFile(t, file, truncate(keyFileNonceAt-1)), "Simba"},
+		{"truncated in the nonce", tamperedKeyFile(t, file, truncate(keyFileSealedAt-1)), "Simba"},
+		{"truncated in the sealed key", tamperedKeyFile(t, file, func(b []byte) []byte { return b[:len(b)-1] }), "Simba"},
+		{"truncated armor", file[:len(file)/2], "Simba"},
+		{"plain key", func() []byte {
+			b, err := sk.MarshalPEM()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}(), "Simba"},
+	} {
+		if _, err := DecryptSecretKey(c.file, []byte(c.passphrase)); err == nil {
+			t.Errorf("%s: key file decrypted", c.name)
+		}
+	}
+}
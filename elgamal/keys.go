@@ -0,0 +1,336 @@
+package elgamal
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+)
+
+// Binary key encodings are
+//
+//	public key: version || 0x01 || group id || H (compressed encoding)
+//	secret key: version || 0x02 || group id || private scalar (big-endian,
+//	            padded to the byte length of the group order)
+//
+// PointCompression is a protocol setting rather than key material, so it is
+// not encoded; parsed public keys have it enabled.
+const (
+	keyEncodingVersion = 1
+	keyTypePublic      = 1
+	keyTypeSecret      = 2
+)
+
+const (
+	PublicKeyPEMType = "ELGAMAL PUBLIC KEY"
+	SecretKeyPEMType = "ELGAMAL PRIVATE KEY"
+)
+
+// Wire identifiers of the groups in binary key encodings
+var groupIDs = map[string]byte{
+	"P-224":        1,
+	"P-256":        2,
+	"P-384":        3,
+	"P-521":        4,
+	"ristretto255": 5,
+}
+
+// JWK is the JSON Web Key form of a key. NIST curve keys use kty "EC" with
+// affine x and y as in RFC 7518; ristretto255 keys use kty "OKP" with the
+// 32-byte element encoding in x. The private scalar d is big-endian and padded
+// to the byte length of the group order. Kid is the key fingerprint.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	Kid string `json:"kid,omitempty"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+	D   string `json:"d,omitempty"`
+}
+
+// This function returns the canonical binary encoding of a public key
+func (pk *PublicKey) MarshalBinary() ([]byte, error) {
+	group, err := resolveGroup(pk.GroupName, pk.SecParam)
+	if err != nil {
+		return nil, err
+	}
+	out := []byte{keyEncodingVersion, keyTypePublic, groupIDs[group.Name()]}
+	return append(out, group.Marshal(pk.Hx, pk.Hy, true)...), nil
+}
+
+// This function parses the binary encoding of a public key and checks that H is
+// a valid element of the encoded group
+func ParsePublicKey(data []byte) (*PublicKey, error) {
+
+	group, payload, err := parseKeyHeader(data, keyTypePublic)
+	if err != nil {
+		return nil, err
+	}
+	Hx, Hy, err := group.Unmarshal(payload, true)
+	if err != nil {
+		return nil, err
+	}
+	return newPublicKey(group, Hx, Hy)
+}
+
+// This function returns the canonical binary encoding of a secret key
+func (sk *SecretKey) MarshalBinary() ([]byte, error) {
+	group, err := GroupByName(sk.GroupName)
+	if err != nil {
+		return nil, err
+	}
+	out := []byte{keyEncodingVersion, keyTypeSecret, groupIDs[group.Name()]}
+	return append(out, paddedScalar(group, sk.Priv)...), nil
+}
+
+// This function parses the binary encoding of a secret key and recomputes its
+// public part
+func ParseSecretKey(data []byte) (*SecretKey, error) {
+
+	group, payload, err := parseKeyHeader(data, keyTypeSecret)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) != len(group.Order().Bytes()) {
+		return nil, errors.New("secret key has the wrong length for " + group.Name())
+	}
+	return newSecretKey(group, payload)
+}
+
+// This function returns the public key matching a secret key
+func (sk *SecretKey) PublicKey() *PublicKey {
+	group := sk.Group()
+	Gx, Gy := group.Generator()
+	return &PublicKey{GroupName: group.Name(), SecParam: group.Order().BitLen(), Gx: Gx, Gy: Gy, Hx: sk.Hx, Hy: sk.Hy, PointCompression: true, group: group}
+}
+
+// This function returns the hex-encoded SHA-256 of the binary public key
+func (pk *PublicKey) Fingerprint() string {
+	enc, err := pk.MarshalBinary()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(enc)
+	return hex.EncodeToString(sum[:])
+}
+
+// This function returns the fingerprint of the matching public key
+func (sk *SecretKey) Fingerprint() string {
+	return sk.PublicKey().Fingerprint()
+}
+
+// This function returns the PEM armoring of a public key
+func (pk *PublicKey) MarshalPEM() ([]byte, error) {
+	enc, err := pk.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:    PublicKeyPEMType,
+		Headers: map[string]string{"Group": pk.Group().Name(), "Fingerprint": pk.Fingerprint()},
+		Bytes:   enc,
+	}), nil
+}
+
+// This function parses the first PEM block of data as a public key. The
+// headers are informational; the group is read from the encoded key.
+func ParsePublicKeyPEM(data []byte) (*PublicKey, error) {
+	block, err := decodePEM(data, PublicKeyPEMType)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePublicKey(block.Bytes)
+}
+
+// This function returns the PEM armoring of a secret key
+func (sk *SecretKey) MarshalPEM() ([]byte, error) {
+	enc, err := sk.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:    SecretKeyPEMType,
+		Headers: map[string]string{"Group": sk.Group().Name(), "Fingerprint": sk.Fingerprint()},
+		Bytes:   enc,
+	}), nil
+}
+
+// This function parses the first PEM block of data as a secret key
+func ParseSecretKeyPEM(data []byte) (*SecretKey, error) {
+	block, err := decodePEM(data, SecretKeyPEMType)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSecretKey(block.Bytes)
+}
+
+// This function returns the JWK form of a public key
+func (pk *PublicKey) MarshalJWK() ([]byte, error) {
+	jwk, err := publicJWK(pk)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jwk)
+}
+
+// This function returns the JWK form of a secret key, including its public part
+func (sk *SecretKey) MarshalJWK() ([]byte, error) {
+	jwk, err := publicJWK(sk.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	jwk.D = base64.RawURLEncoding.EncodeToString(paddedScalar(sk.Group(), sk.Priv))
+	return json.Marshal(jwk)
+}
+
+// This function parses the JWK form of a public key. A private member d, if
+// present, is ignored.
+func ParsePublicKeyJWK(data []byte) (*PublicKey, error) {
+	var jwk JWK
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, err
+	}
+	group, Hx, Hy, err := parseJWKPoint(&jwk)
+	if err != nil {
+		return nil, err
+	}
+	return newPublicKey(group, Hx, Hy)
+}
+
+// This function parses the JWK form of a secret key and checks that its
+// public members match the private scalar
+func ParseSecretKeyJWK(data []byte) (*SecretKey, error) {
+	var jwk JWK
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, err
+	}
+	group, Hx, Hy, err := parseJWKPoint(&jwk)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := base64.RawURLEncoding.DecodeString(jwk.D)
+	if err != nil {
+		return nil, err
+	}
+	if len(priv) != len(group.Order().Bytes()) {
+		return nil, errors.New("secret key has the wrong length for " + group.Name())
+	}
+	sk, err := newSecretKey(group, priv)
+	if err != nil {
+		return nil, err
+	}
+	if sk.Hx.Cmp(Hx) != 0 || sk.Hy.Cmp(Hy) != 0 {
+		return nil, errors.New("public and private members of the key do not match")
+	}
+	return sk, nil
+}
+
+func publicJWK(pk *PublicKey) (*JWK, error) {
+	group, err := resolveGroup(pk.GroupName, pk.SecParam)
+	if err != nil {
+		return nil, err
+	}
+	jwk := &JWK{Crv: group.Name(), Kid: pk.Fingerprint()}
+	if nist, ok := group.(*nistGroup); ok {
+		size := (nist.curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pk.Hx.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(pk.Hy.FillBytes(make([]byte, size)))
+	} else {
+		jwk.Kty = "OKP"
+		jwk.X = base64.RawURLEncoding.EncodeToString(group.Marshal(pk.Hx, pk.Hy, true))
+	}
+	return jwk, nil
+}
+
+func parseJWKPoint(jwk *JWK) (Group, *big.Int, *big.Int, error) {
+
+	group, err := GroupByName(jwk.Crv)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	nist, isNIST := group.(*nistGroup)
+	switch {
+	case isNIST && jwk.Kty == "EC":
+		size := (nist.curve.Params().BitSize + 7) / 8
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if len(x) != size || len(y) != size {
+			return nil, nil, nil, errors.New("JWK coordinates have the wrong length for " + group.Name())
+		}
+		uncompressed := append(append([]byte{4}, x...), y...)
+		Hx, Hy, err := group.Unmarshal(uncompressed, false)
+		return group, Hx, Hy, err
+	case !isNIST && jwk.Kty == "OKP":
+		Hx, Hy, err := group.Unmarshal(x, true)
+		return group, Hx, Hy, err
+	}
+	return nil, nil, nil, errors.New("JWK type " + jwk.Kty + " does not match curve " + jwk.Crv)
+}
+
+func parseKeyHeader(data []byte, keyType byte) (Group, []byte, error) {
+
+	if len(data) < 3 {
+		return nil, nil, errors.New("key encoding too short")
+	}
+	if data[0] != keyEncodingVersion {
+		return nil, nil, errors.New("unsupported key encoding version")
+	}
+	if data[1] != keyType {
+		return nil, nil, errors.New("wrong key type")
+	}
+	for name, id := range groupIDs {
+		if id == data[2] {
+			group, err := GroupByName(name)
+			return group, data[3:], err
+		}
+	}
+	return nil, nil, errors.New("unknown group identifier")
+}
+
+func decodePEM(data []byte, pemType string) (*pem.Block, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if block.Type != pemType {
+		return nil, errors.New("expected PEM block " + pemType + ", found " + block.Type)
+	}
+	return block, nil
+}
+
+// This function builds a public key around H after checking that H is a valid
+// non-identity element of the group
+func newPublicKey(group Group, Hx, Hy *big.Int) (*PublicKey, error) {
+	if !group.IsOnGroup(Hx, Hy) || group.IsIdentity(Hx, Hy) {
+		return nil, errors.New("public key is not a valid " + group.Name() + " element")
+	}
+	Gx, Gy := group.Generator()
+	return &PublicKey{GroupName: group.Name(), SecParam: group.Order().BitLen(), Gx: Gx, Gy: Gy, Hx: Hx, Hy: Hy, PointCompression: true, group: group}, nil
+}
+
+// This function builds a secret key from a private scalar in [1, N)
+func newSecretKey(group Group, priv []byte) (*SecretKey, error) {
+	scalar := big.NewInt(0).SetBytes(priv)
+	if scalar.Sign() == 0 || scalar.Cmp(group.Order()) >= 0 {
+		return nil, errors.New("secret key out of range for " + group.Name())
+	}
+	priv = paddedScalar(group, priv)
+	Hx, Hy := group.ScalarBaseMult(priv)
+	return &SecretKey{GroupName: group.Name(), Hx: Hx, Hy: Hy, Priv: priv, group: group}, nil
+}
+
+// This function left-pads a big-endian scalar to the byte length of the order
+func paddedScalar(group Group, k []byte) []byte {
+	return big.NewInt(0).SetBytes(k).FillBytes(make([]byte, len(group.Order().Bytes())))
+}
@@ -0,0 +1,182 @@
+package elgamal
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func samePublicKey(t *testing.T, want, got *PublicKey) {
+	t.Helper()
+	if got.GroupName != want.GroupName || got.Hx.Cmp(want.Hx) != 0 || got.Hy.Cmp(want.Hy) != 0 {
+		t.Fatalf("public key changed: %s (%v, %v)", got.GroupName, got.Hx, got.Hy)
+	}
+	if got.Fingerprint() != want.Fingerprint() {
+		t.Fatal("fingerprint changed")
+	}
+}
+
+func sameSecretKey(t *testing.T, want, got *SecretKey) {
+	t.Helper()
+	if got.GroupName != want.GroupName || string(got.Priv) != string(want.Priv) || got.Hx.Cmp(want.Hx) != 0 || got.Hy.Cmp(want.Hy) != 0 {
+		t.Fatalf("secret key changed: %s", got.GroupName)
+	}
+}
+
+func TestKeyRoundTrip(t *testing.T) {
+	forEachGroup(t, func(t *testing.T, pk *PublicKey, sk *SecretKey, table *DlogTable) {
+
+		bin, err := pk.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsedPK, err := ParsePublicKey(bin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		samePublicKey(t, pk, parsedPK)
+
+		bin, err = sk.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsedSK, err := ParseSecretKey(bin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sameSecretKey(t, sk, parsedSK)
+
+		pemBytes, err := pk.MarshalPEM()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsedPK, err = ParsePublicKeyPEM(pemBytes); err != nil {
+			t.Fatal(err)
+		}
+		samePublicKey(t, pk, parsedPK)
+
+		pemBytes, err = sk.MarshalPEM()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsedSK, err = ParseSecretKeyPEM(pemBytes); err != nil {
+			t.Fatal(err)
+		}
+		sameSecretKey(t, sk, parsedSK)
+		if _, err := ParsePublicKeyPEM(pemBytes); err == nil {
+			t.Fatal("secret key PEM parsed as a public key")
+		}
+
+		jwk, err := pk.MarshalJWK()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsedPK, err = ParsePublicKeyJWK(jwk); err != nil {
+			t.Fatal(err)
+		}
+		samePublicKey(t, pk, parsedPK)
+
+		jwk, err = sk.MarshalJWK()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsedSK, err = ParseSecretKeyJWK(jwk); err != nil {
+			t.Fatal(err)
+		}
+		sameSecretKey(t, sk, parsedSK)
+	})
+}
+
+func TestKeyFingerprint(t *testing.T) {
+	forEachGroup(t, func(t *testing.T, pk *PublicKey, sk *SecretKey, table *DlogTable) {
+
+		if len(pk.Fingerprint()) != 64 {
+			t.Fatalf("fingerprint %q is not a hex SHA-256", pk.Fingerprint())
+		}
+		if sk.Fingerprint() != pk.Fingerprint() || sk.PublicKey().Fingerprint() != pk.Fingerprint() {
+			t.Fatal("secret key fingerprint differs from its public key's")
+		}
+
+		var jwk JWK
+		data, err := pk.MarshalJWK()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &jwk); err != nil {
+			t.Fatal(err)
+		}
+		if jwk.Kid != pk.Fingerprint() {
+			t.Fatal("JWK kid is not the fingerprint")
+		}
+
+		other, _, err := KeyGenInGroup(pk.Group(), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other.Fingerprint() == pk.Fingerprint() {
+			t.Fatal("two keys share a fingerprint")
+		}
+	})
+}
+
+// A P-256 key relabeled as another group must not parse
+func TestKeyCrossCurveRejection(t *testing.T) {
+
+	group, err := GroupByName("P-256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, sk, err := KeyGenInGroup(group, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkBin, err := pk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	skBin, err := sk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkJWK, err := pk.MarshalJWK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	skJWK, err := sk.MarshalJWK()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"P-384", "ristretto255"} {
+		t.Run(name, func(t *testing.T) {
+
+			relabeled := append([]byte{}, pkBin...)
+			relabeled[2] = groupIDs[name]
+			if _, err := ParsePublicKey(relabeled); err == nil {
+				t.Fatal("P-256 public key parsed as " + name)
+			}
+			if name != "ristretto255" {
+				// a ristretto255 scalar has the length of a P-256 one
+				relabeled = append([]byte{}, skBin...)
+				relabeled[2] = groupIDs[name]
+				if _, err := ParseSecretKey(relabeled); err == nil {
+					t.Fatal("P-256 secret key parsed as " + name)
+				}
+			}
+
+			for _, data := range [][]byte{pkJWK, skJWK} {
+				var jwk JWK
+				if err := json.Unmarshal(data, &jwk); err != nil {
+					t.Fatal(err)
+				}
+				jwk.Crv = name
+				relabeledJWK, _ := json.Marshal(jwk)
+				if _, err := ParsePublicKeyJWK(relabeledJWK); err == nil {
+					t.Fatal("P-256 JWK parsed as " + name)
+				}
+				if _, err := ParseSecretKeyJWK(relabeledJWK); err == nil {
+					t.Fatal("P-256 secret JWK parsed as " + name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package elgamal
+
+import (
+	"math/big"
+	"sync"
+)
+
+// This function homomorphically adds all input ciphertexts and returns a
+// ciphertext of the sum. The work is split across numThreads goroutines.
+func (pk *PublicKey) Sum(cs []*Ciphertext, numThreads int) *Ciphertext {
+
+	group := pk.Group()
+
+	return pk.combineChunks(len(cs), numThreads, func(start, end int) *Ciphertext {
+		c1xs, c1ys, c2xs, c2ys := splitCiphertexts(cs[start:end])
+		c1x, c1y := groupSum(group, c1xs, c1ys)
+		c2x, c2y := groupSum(group, c2xs, c2ys)
+		return &Ciphertext{c1x, c1y, c2x, c2y}
+	})
+}
+
+// This function returns a ciphertext of Σ ks[i]·m_i, where cs[i] encrypts m_i.
+// The work is split across numThreads goroutines.
+func (pk *PublicKey) LinearCombination(cs []*Ciphertext, ks []*big.Int, numThreads int) *Ciphertext {
+
+	group := pk.Group()
+
+	return pk.combineChunks(len(cs), numThreads, func(start, end int) *Ciphertext {
+		c1xs, c1ys, c2xs, c2ys := splitCiphertexts(cs[start:end])
+		c1x, c1y := groupMultiScalarMult(group, c1xs, c1ys, ks[start:end])
+		c2x, c2y := groupMultiScalarMult(group, c2xs, c2ys, ks[start:end])
+		return &Ciphertext{c1x, c1y, c2x, c2y}
+	})
+}
+
+// This function runs fn over numThreads contiguous chunks of [0, n) and adds
+// up the partial ciphertexts
+func (pk *PublicKey) combineChunks(n, numThreads int, fn func(start, end int) *Ciphertext) *Ciphertext {
+
+	if numThreads < 1 {
+		numThreads = 1
+	}
+	if numThreads > n {
+		numThreads = n
+	}
+	if numThreads <= 1 {
+		if n == 0 {
+			return pk.Identity()
+		}
+		return fn(0, n)
+	}
+
+	var wg sync.WaitGroup
+	partials := make([]*Ciphertext, numThreads)
+	for t := 0; t < numThreads; t++ {
+		wg.Add(1)
+		go func(t int) {
+			defer wg.Done()
+			partials[t] = fn(t*n/numThreads, (t+1)*n/numThreads)
+		}(t)
+	}
+	wg.Wait()
+
+	return pk.Sum(partials, 1)
+}
+
+func splitCiphertexts(cs []*Ciphertext) (c1xs, c1ys, c2xs, c2ys []*big.Int) {
+	c1xs = make([]*big.Int, len(cs))
+	c1ys = make([]*big.Int, len(cs))
+	c2xs = make([]*big.Int, len(cs))
+	c2ys = make([]*big.Int, len(cs))
+	for i, c := range cs {
+		c1xs[i], c1ys[i], c2xs[i], c2ys[i] = c.C1x, c.C1y, c.C2x, c.C2y
+	}
+	return
+}
@@ -0,0 +1,232 @@
+package elgamal
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	ecarith "bhwmonitoring-go/internal/ecarith"
+)
+
+// nistGroup runs the scheme over one of the crypto/elliptic NIST curves.
+// crypto/elliptic encodes the point at infinity as (0, 0).
+type nistGroup struct {
+	curve elliptic.Curve
+
+	// which fixed-base tables and whether ecarith's multi-scalar
+	// multiplication beat crypto/elliptic on this curve (see precompute.go)
+	tables struct{ g, h bool }
+	msm    bool
+}
+
+// crypto/elliptic already has fast fixed-base code for G on every curve and
+// assembly for P-256, so a windowed table does not pay off everywhere.
+// Measured on amd64 with the constant-time table lookup, the tables win for H
+// on P-224, P-384 and P-521 (2.5-4x) and for G on P-521 only (about 1.1x).
+// Multi-scalar multiplication through ecarith wins on the same curves as the
+// H tables; on P-256 one crypto/elliptic ScalarMult per term is still faster.
+var (
+	nistP224 = newNISTGroup(elliptic.P224(), false, true, true)
+	nistP256 = newNISTGroup(elliptic.P256(), false, false, false)
+	nistP384 = newNISTGroup(elliptic.P384(), false, true, true)
+	nistP521 = newNISTGroup(elliptic.P521(), true, true, true)
+)
+
+func newNISTGroup(curve elliptic.Curve, gTable, hTable, msm bool) *nistGroup {
+	g := &nistGroup{curve: curve, msm: msm}
+	g.tables.g, g.tables.h = gTable, hTable
+	return g
+}
+
+func (g *nistGroup) Name() string {
+	return g.curve.Params().Name
+}
+
+func (g *nistGroup) Order() *big.Int {
+	return g.curve.Params().N
+}
+
+func (g *nistGroup) Generator() (*big.Int, *big.Int) {
+	return g.curve.ScalarBaseMult(big.NewInt(1).Bytes())
+}
+
+func (g *nistGroup) Identity() (*big.Int, *big.Int) {
+	return big.NewInt(0), big.NewInt(0)
+}
+
+func (g *nistGroup) IsIdentity(x, y *big.Int) bool {
+	return x.Sign() == 0 && y.Sign() == 0
+}
+
+func (g *nistGroup) IsOnGroup(x, y *big.Int) bool {
+	if x == nil || y == nil {
+		return false
+	}
+	return g.IsIdentity(x, y) || g.curve.IsOnCurve(x, y)
+}
+
+func (g *nistGroup) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	return g.curve.Add(x1, y1, x2, y2)
+}
+
+func (g *nistGroup) Neg(x, y *big.Int) (*big.Int, *big.Int) {
+	if g.IsIdentity(x, y) {
+		return big.NewInt(0), big.NewInt(0)
+	}
+	p := g.curve.Params().P
+	negY := big.NewInt(0).Sub(p, y)
+	return big.NewInt(0).Set(x), negY.Mod(negY, p)
+}
+
+func (g *nistGroup) ScalarMult(x, y *big.Int, k []byte) (*big.Int, *big.Int) {
+	return g.curve.ScalarMult(x, y, k)
+}
+
+func (g *nistGroup) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	return g.curve.ScalarBaseMult(k)
+}
+
+func (g *nistGroup) Marshal(x, y *big.Int, compressed bool) []byte {
+	if compressed {
+		return elliptic.MarshalCompressed(g.curve, x, y)
+	}
+	return elliptic.Marshal(g.curve, x, y)
+}
+
+func (g *nistGroup) Unmarshal(data []byte, compressed bool) (*big.Int, *big.Int, error) {
+	var x, y *big.Int
+	if compressed {
+		x, y = elliptic.UnmarshalCompressed(g.curve, data)
+	} else {
+		x, y = elliptic.Unmarshal(g.curve, data)
+	}
+	if x == nil {
+		return nil, nil, errors.New("invalid " + g.Name() + " point encoding")
+	}
+	return x, y, nil
+}
+
+// This function hashes into the curve by try-and-increment: the first
+// candidate x with a square right-hand side wins, and the even root is taken
+func (g *nistGroup) HashToGroup(msg, dst []byte) (*big.Int, *big.Int) {
+
+	params := g.curve.Params()
+	byteLen := (params.BitSize + 7) / 8
+	topMask := byte(0xff >> uint(8*byteLen-params.BitSize))
+
+	for ctr := uint32(0); ; ctr++ {
+		var xBytes []byte
+		for block := byte(0); len(xBytes) < byteLen; block++ {
+			h := sha256.New()
+			h.Write([]byte{byte(len(dst))})
+			h.Write(dst)
+			h.Write([]byte{byte(ctr >> 24), byte(ctr >> 16), byte(ctr >> 8), byte(ctr), block})
+			h.Write(msg)
+			xBytes = h.Sum(xBytes)
+		}
+		xBytes = xBytes[:byteLen]
+		xBytes[0] &= topMask
+
+		x := big.NewInt(0).SetBytes(xBytes)
+		if x.Cmp(params.P) >= 0 {
+			continue
+		}
+		y := big.NewInt(0).ModSqrt(g.polynomial(x), params.P)
+		if y == nil {
+			continue
+		}
+		if y.Bit(0) == 1 {
+			y.Sub(params.P, y)
+		}
+		return x, y
+	}
+}
+
+// This function embeds msg as the x-coordinate msg || counter, where the
+// PaddingBytes-byte counter is the first value giving a point on the curve
+func (g *nistGroup) Embed(msg []byte) (*big.Int, *big.Int, error) {
+
+	params := g.curve.Params()
+	if len(msg)+PaddingBytes >= (params.BitSize+7)/8 {
+		return nil, nil, errors.New("message too long to embed in " + g.Name())
+	}
+
+	msgExt := append(append([]byte{}, msg...), make([]byte, PaddingBytes)...)
+	mx := big.NewInt(0).SetBytes(msgExt)
+	for i := 0; i < 1<<(PaddingBytes*8); i++ {
+		my := big.NewInt(0).ModSqrt(g.polynomial(mx), params.P)
+		if my != nil {
+			return mx, my, nil
+		}
+		mx = mx.Add(mx, big.NewInt(1))
+	}
+	return nil, nil, errors.New("no embedding found in " + g.Name())
+}
+
+// This function strips the counter from the x-coordinate. Leading zero bytes
+// of the embedded message are lost.
+func (g *nistGroup) Extract(x, y *big.Int) []byte {
+	xBytes := x.Bytes()
+	if len(xBytes) < PaddingBytes {
+		return nil
+	}
+	return xBytes[:len(xBytes)-PaddingBytes]
+}
+
+func (g *nistGroup) Sum(xs, ys []*big.Int) (*big.Int, *big.Int) {
+	return ecarith.For(g.curve).Sum(xs, ys)
+}
+
+func (g *nistGroup) MultiScalarMult(xs, ys, ks []*big.Int) (*big.Int, *big.Int) {
+	if g.msm {
+		return ecarith.For(g.curve).MultiScalarMult(xs, ys, ks)
+	}
+	x, y := g.Identity()
+	for i := range xs {
+		k := big.NewInt(0).Mod(ks[i], g.Order())
+		tx, ty := g.curve.ScalarMult(xs[i], ys[i], k.Bytes())
+		x, y = g.curve.Add(x, y, tx, ty)
+	}
+	return x, y
+}
+
+// Tables only pay off where ecarith's multi-scalar multiplication is used
+func (g *nistGroup) newMSMTable(x, y *big.Int) msmTable {
+	if !g.msm {
+		return nil
+	}
+	return ecarith.For(g.curve).NewMSMTable(x, y)
+}
+
+func (g *nistGroup) multiScalarMultTables(ts []msmTable, ks []*big.Int) (*big.Int, *big.Int) {
+	tables := make([]*ecarith.MSMTable, len(ts))
+	for i := range ts {
+		tables[i] = ts[i].(*ecarith.MSMTable)
+	}
+	return ecarith.For(g.curve).MultiScalarMultTables(tables, ks)
+}
+
+func (g *nistGroup) fixedBasePolicy() (bool, bool) {
+	return g.tables.g, g.tables.h
+}
+
+func (g *nistGroup) newFixedBaseTable(x, y *big.Int) *ecarith.FixedBaseTable {
+	return ecarith.For(g.curve).NewFixedBaseTable(x, y, fixedBaseWindow)
+}
+
+// This function returns x^3 - 3x + b mod p
+func (g *nistGroup) polynomial(x *big.Int) *big.Int {
+	params := g.curve.Params()
+	x3 := new(big.Int).Mul(x, x)
+	x3.Mul(x3, x)
+
+	threeX := new(big.Int).Lsh(x, 1)
+	threeX.Add(threeX, x)
+
+	x3.Sub(x3, threeX)
+	x3.Add(x3, params.B)
+	x3.Mod(x3, params.P)
+
+	return x3
+}
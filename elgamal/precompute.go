@@ -0,0 +1,95 @@
+package elgamal
+
+import (
+	"math/big"
+	"sync"
+
+	ecarith "bhwmonitoring-go/internal/ecarith"
+)
+
+// Window width of the fixed-base tables: 2^6-1 points per window
+const fixedBaseWindow = 6
+
+// Groups that can build windowed fixed-base tables report on which bases the
+// tables beat their plain scalar multiplication
+type fixedBaseGroup interface {
+	fixedBasePolicy() (g, h bool)
+	newFixedBaseTable(x, y *big.Int) *ecarith.FixedBaseTable
+}
+
+const (
+	precompAuto = iota
+	precompOn
+	precompOff
+)
+
+type precomputation struct {
+	g *ecarith.FixedBaseTable
+	h *ecarith.FixedBaseTable
+}
+
+var generatorTables = struct {
+	sync.Mutex
+	m map[string]*ecarith.FixedBaseTable
+}{m: make(map[string]*ecarith.FixedBaseTable)}
+
+// This function forces the fixed-base tables on or off for this key. Without
+// a call, tables are used only on the curves where they beat crypto/elliptic.
+// Groups without table support ignore the setting.
+func (pk *PublicKey) SetPrecomputation(enabled bool) {
+	if enabled {
+		pk.precompMode = precompOn
+	} else {
+		pk.precompMode = precompOff
+	}
+}
+
+func (pk *PublicKey) useTables() (g, h bool) {
+	fb, ok := pk.Group().(fixedBaseGroup)
+	if !ok {
+		return false, false
+	}
+	switch pk.precompMode {
+	case precompOn:
+		return true, true
+	case precompOff:
+		return false, false
+	}
+	return fb.fixedBasePolicy()
+}
+
+// This function lazily builds the table for H, and fetches the process-wide
+// table for G, on first use
+func (pk *PublicKey) precomputed() *precomputation {
+	pk.precompOnce.Do(func() {
+		group := pk.Group()
+		fb := group.(fixedBaseGroup)
+
+		generatorTables.Lock()
+		g, ok := generatorTables.m[group.Name()]
+		if !ok {
+			g = fb.newFixedBaseTable(group.Generator())
+			generatorTables.m[group.Name()] = g
+		}
+		generatorTables.Unlock()
+
+		pk.precomp = &precomputation{g, fb.newFixedBaseTable(pk.Hx, pk.Hy)}
+	})
+	return pk.precomp
+}
+
+// This function returns kG
+func (pk *PublicKey) baseMult(k []byte) (*big.Int, *big.Int) {
+	if g, _ := pk.useTables(); g {
+		return pk.precomputed().g.ScalarMult(k)
+	}
+	return pk.Group().ScalarBaseMult(k)
+}
+
+// This function returns kH
+func (pk *PublicKey) pubMult(k []byte) (*big.Int, *big.Int) {
+	if _, h := pk.useTables(); h {
+		return pk.precomputed().h.ScalarMult(k)
+	}
+	return pk.Group().ScalarMult(pk.Hx, pk.Hy, k)
+}
@@ -0,0 +1,63 @@
+package elgamal
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+// The Bloom filter lengths evaluated in the paper, with its default of 30 ones
+var benchBFLengths = []int{128, 256}
+
+const benchBFNumOnes = 30
+
+// This function returns a filter of bfLength bits with the first 30 set, as
+// EncryptSeqWithZKP receives it from QueryGen
+func benchFilter(bfLength int) []*big.Int {
+	ms := make([]*big.Int, bfLength)
+	for i := range ms {
+		if i < benchBFNumOnes {
+			ms[i] = big.NewInt(1)
+		} else {
+			ms[i] = big.NewInt(-1)
+		}
+	}
+	return ms
+}
+
+// Run with -benchtime=Nx on the larger curves; one P-521 operation takes
+// seconds
+func BenchmarkEncryptSeqWithZKP(b *testing.B) {
+	for _, name := range GroupNames() {
+		group, err := GroupByName(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, ok := group.(fixedBaseGroup); !ok {
+			continue
+		}
+		for _, bfLength := range benchBFLengths {
+			for _, fixedBase := range []bool{false, true} {
+				mode := "off"
+				if fixedBase {
+					mode = "on"
+				}
+				b.Run(fmt.Sprintf("%s/BFLength=%d/fixedBase=%s", name, bfLength, mode), func(b *testing.B) {
+					pk, _, err := KeyGenInGroup(group, true)
+					if err != nil {
+						b.Fatal(err)
+					}
+					pk.SetPrecomputation(fixedBase)
+					pk.Encrypt(big.NewInt(0)) // builds the tables outside the timing
+
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						// a fresh filter each time, as EncryptSeqWithZKP
+						// reduces its input in place
+						pk.EncryptSeqWithZKP(benchFilter(bfLength), 1)
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package elgamal
+
+import (
+	"math/big"
+)
+
+// PreparedCiphertext is a ciphertext together with the tables of multiples of
+// its two points that a multi-scalar multiplication looks up. A ciphertext
+// that takes part in many linear combinations, like an EBF entry of a deployed
+// query, is prepared once. Groups where tables do not pay off (P-256) keep
+// only the ciphertext.
+type PreparedCiphertext struct {
+	*Ciphertext
+	c1, c2 msmTable
+}
+
+// This function prepares a ciphertext for LinearCombinationPrepared
+func (pk *PublicKey) Prepare(c *Ciphertext) *PreparedCiphertext {
+	p := &PreparedCiphertext{Ciphertext: c}
+	if g, ok := pk.Group().(tableGroup); ok {
+		p.c1 = g.newMSMTable(c.C1x, c.C1y)
+		p.c2 = g.newMSMTable(c.C2x, c.C2y)
+	}
+	return p
+}
+
+// This function works as LinearCombination on prepared ciphertexts. Terms with
+// tables go through one table-driven multi-scalar multiplication, the others
+// through LinearCombination.
+func (pk *PublicKey) LinearCombinationPrepared(cs []*PreparedCiphertext, ks []*big.Int) *Ciphertext {
+
+	g, ok := pk.Group().(tableGroup)
+
+	var c1s, c2s []msmTable
+	var tableKs, plainKs []*big.Int
+	var plain []*Ciphertext
+	for i, c := range cs {
+		if ok && c.c1 != nil && c.c2 != nil {
+			c1s = append(c1s, c.c1)
+			c2s = append(c2s, c.c2)
+			tableKs = append(tableKs, ks[i])
+		} else {
+			plain = append(plain, c.Ciphertext)
+			plainKs = append(plainKs, ks[i])
+		}
+	}
+
+	res := pk.LinearCombination(plain, plainKs, 1)
+	if len(c1s) > 0 {
+		c1x, c1y := g.multiScalarMultTables(c1s, tableKs)
+		c2x, c2y := g.multiScalarMultTables(c2s, tableKs)
+		res = pk.Add(res, &Ciphertext{c1x, c1y, c2x, c2y}, false)
+	}
+	return res
+}
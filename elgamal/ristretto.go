@@ -0,0 +1,129 @@
+package elgamal
+
+import (
+	"crypto/sha512"
+	"errors"
+	"math/big"
+
+	ecarith "bhwmonitoring-go/internal/ecarith"
+)
+
+// ristrettoGroup runs the scheme over ristretto255 (RFC 9496). Elements are
+// the canonical Edwards coordinates chosen by ecarith, the identity is (0, 1)
+// and the wire encoding is always the 32-byte ristretto255 encoding.
+type ristrettoGroup struct {
+	r *ecarith.Ristretto255
+}
+
+var ristretto255 = &ristrettoGroup{ecarith.NewRistretto255()}
+
+// Embedded messages sit in bytes 4..30 of the little-endian encoding; bytes
+// 0..3 hold an even counter and byte 31 stays zero so the value is below p
+const ristrettoEmbedLen = 27
+
+func (g *ristrettoGroup) Name() string {
+	return "ristretto255"
+}
+
+func (g *ristrettoGroup) Order() *big.Int {
+	return g.r.Order()
+}
+
+func (g *ristrettoGroup) Generator() (*big.Int, *big.Int) {
+	return g.r.Generator()
+}
+
+func (g *ristrettoGroup) Identity() (*big.Int, *big.Int) {
+	return g.r.Identity()
+}
+
+func (g *ristrettoGroup) IsIdentity(x, y *big.Int) bool {
+	return g.r.IsIdentity(x, y)
+}
+
+func (g *ristrettoGroup) IsOnGroup(x, y *big.Int) bool {
+	return g.r.IsValid(x, y)
+}
+
+func (g *ristrettoGroup) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	return g.r.Add(x1, y1, x2, y2)
+}
+
+func (g *ristrettoGroup) Neg(x, y *big.Int) (*big.Int, *big.Int) {
+	return g.r.Neg(x, y)
+}
+
+func (g *ristrettoGroup) ScalarMult(x, y *big.Int, k []byte) (*big.Int, *big.Int) {
+	return g.r.ScalarMult(x, y, k)
+}
+
+func (g *ristrettoGroup) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	return g.r.ScalarBaseMult(k)
+}
+
+func (g *ristrettoGroup) Marshal(x, y *big.Int, compressed bool) []byte {
+	return g.r.Encode(x, y)
+}
+
+func (g *ristrettoGroup) Unmarshal(data []byte, compressed bool) (*big.Int, *big.Int, error) {
+	return g.r.Decode(data)
+}
+
+func (g *ristrettoGroup) HashToGroup(msg, dst []byte) (*big.Int, *big.Int) {
+	h := sha512.New()
+	h.Write([]byte{byte(len(dst))})
+	h.Write(dst)
+	h.Write(msg)
+	return g.r.FromUniformBytes(h.Sum(nil))
+}
+
+// This function embeds msg into the encoding of an element, trying counters
+// until the encoding decodes
+func (g *ristrettoGroup) Embed(msg []byte) (*big.Int, *big.Int, error) {
+
+	if len(msg) > ristrettoEmbedLen {
+		return nil, nil, errors.New("message too long to embed in ristretto255")
+	}
+
+	s := make([]byte, 32)
+	copy(s[PaddingBytes:], msg)
+	for ctr := uint32(0); ctr < 1<<31; ctr++ {
+		c := ctr << 1
+		s[0], s[1], s[2], s[3] = byte(c), byte(c>>8), byte(c>>16), byte(c>>24)
+		if x, y, err := g.r.Decode(s); err == nil {
+			return x, y, nil
+		}
+	}
+	return nil, nil, errors.New("no embedding found in ristretto255")
+}
+
+// This function recovers an embedded message. Trailing zero bytes of the
+// message are lost.
+func (g *ristrettoGroup) Extract(x, y *big.Int) []byte {
+	msg := g.r.Encode(x, y)[PaddingBytes:]
+	end := len(msg)
+	for end > 0 && msg[end-1] == 0 {
+		end--
+	}
+	return msg[:end]
+}
+
+func (g *ristrettoGroup) Sum(xs, ys []*big.Int) (*big.Int, *big.Int) {
+	return g.r.Sum(xs, ys)
+}
+
+func (g *ristrettoGroup) MultiScalarMult(xs, ys, ks []*big.Int) (*big.Int, *big.Int) {
+	return g.r.MultiScalarMult(xs, ys, ks)
+}
+
+func (g *ristrettoGroup) newMSMTable(x, y *big.Int) msmTable {
+	return g.r.NewMSMTable(x, y)
+}
+
+func (g *ristrettoGroup) multiScalarMultTables(ts []msmTable, ks []*big.Int) (*big.Int, *big.Int) {
+	tables := make([]*ecarith.RistrettoMSMTable, len(ts))
+	for i := range ts {
+		tables[i] = ts[i].(*ecarith.RistrettoMSMTable)
+	}
+	return g.r.MultiScalarMultTables(tables, ks)
+}
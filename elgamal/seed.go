@@ -0,0 +1,119 @@
+package elgamal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/big"
+	"sync"
+
+	kdf "bhwmonitoring-go/internal/kdf"
+)
+
+const (
+	keyGenSeedSalt  = "bhwmonitoring-go/keygen/v1"
+	seededReaderTag = "bhwmonitoring-go/seeded-reader/v1"
+
+	// Seeds shorter than this are refused
+	MinSeedLength = 16
+)
+
+// This function derives a key pair deterministically from a seed, so that a
+// deployment or a test can be reproduced. HKDF-SHA256 stretches the seed to
+// 16 bytes more than the group order, bound to the group name, and the result
+// is reduced into [1, N). The bias of the reduction is below 2^-128.
+func KeyGenFromSeed(group Group, seed []byte, pointCompression bool) (*PublicKey, *SecretKey, error) {
+
+	if len(seed) < MinSeedLength {
+		return nil, nil, errors.New("seed must be at least 16 bytes")
+	}
+
+	order := group.Order()
+	okm := kdf.HKDF(sha256.New, seed, []byte(keyGenSeedSalt), []byte(group.Name()), len(order.Bytes())+16)
+	defer kdf.Wipe(okm)
+
+	// priv = okm mod (N - 1) + 1
+	nMinusOne := big.NewInt(0).Sub(order, big.NewInt(1))
+	privInt := big.NewInt(0).SetBytes(okm)
+	privInt.Mod(privInt, nMinusOne)
+	privInt.Add(privInt, big.NewInt(1))
+
+	sk, err := newSecretKey(group, privInt.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	pk, err := newPublicKey(group, sk.Hx, sk.Hy)
+	if err != nil {
+		return nil, nil, err
+	}
+	pk.PointCompression = pointCompression
+	return pk, sk, nil
+}
+
+// This function returns an endless deterministic byte stream for
+// SetRandomSource: the AES-256-CTR keystream under a key derived from the
+// seed. It must never be used outside tests and benchmarks.
+func NewSeededReader(seed []byte) io.Reader {
+	key := kdf.HKDF(sha256.New, seed, nil, []byte(seededReaderTag), 32)
+	defer kdf.Wipe(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	return &seededReader{stream: cipher.NewCTR(block, make([]byte, aes.BlockSize))}
+}
+
+type seededReader struct {
+	stream cipher.Stream
+}
+
+func (r *seededReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	r.stream.XORKeyStream(p, p)
+	return len(p), nil
+}
+
+// lockedReader lets the goroutines of one operation share a source
+type lockedReader struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+func (l *lockedReader) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Read(p)
+}
+
+// This function sets the source of the randomness drawn through this key by
+// Encrypt, EncryptMul, EncryptSeqWithZKP, ScalarMultRandomizer, RandomScalar,
+// EncapsulateKey and pcr.ReqBFGen. A nil source restores crypto/rand. The
+// source is read under a lock; EncryptSeqWithZKP draws in sequence order, so a
+// deterministic source gives reproducible queries for any number of threads.
+// Drawing from a source that fails, e.g. a reader at EOF, panics.
+func (pk *PublicKey) SetRandomSource(r io.Reader) {
+	if r == nil {
+		pk.random = nil
+		return
+	}
+	pk.random = &lockedReader{r: r}
+}
+
+// This function returns the randomness source of this key
+func (pk *PublicKey) RandomSource() io.Reader {
+	if pk.random == nil {
+		return rand.Reader
+	}
+	return pk.random
+}
+
+// This function returns a random scalar in [0, N) drawn from the key's source
+func (pk *PublicKey) randomBytes() []byte {
+	return newCryptoRandomFrom(pk.RandomSource(), pk.Group().Order().Bytes())
+}
@@ -0,0 +1,89 @@
+package elgamal
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+)
+
+// Signature is a Schnorr signature (R, s) with R = k·G and s = k + e·x, where
+// e hashes the key, R and the message. It lets the holder of an ElGamal key
+// authenticate control messages, e.g. revoking a query, to anyone holding the
+// public key.
+type Signature struct {
+	Rx *big.Int
+	Ry *big.Int
+	S  *big.Int
+}
+
+type SignatureByte struct {
+	R []byte
+	S []byte
+}
+
+const signatureLabel = "bhwmonitoring-go/schnorr-signature/v1"
+
+// This function signs msg with the secret key
+func (sk *SecretKey) Sign(msg []byte) *Signature {
+
+	group := sk.Group()
+	k := newCryptoRandom(group.Order().Bytes())
+	Rx, Ry := group.ScalarBaseMult(k)
+
+	e := signatureChallenge(group, sk.Hx, sk.Hy, Rx, Ry, msg)
+	s := big.NewInt(0).Mul(e, big.NewInt(0).SetBytes(sk.Priv))
+	s.Add(s, big.NewInt(0).SetBytes(k))
+	s.Mod(s, group.Order())
+
+	return &Signature{Rx, Ry, s}
+}
+
+// This function checks a signature made by Sign with the secret key of pk
+func (pk *PublicKey) VerifySignature(msg []byte, sig *Signature) bool {
+
+	group := pk.Group()
+	e := signatureChallenge(group, pk.Hx, pk.Hy, sig.Rx, sig.Ry, msg)
+
+	// s·G = R + e·H
+	sGx, sGy := group.ScalarBaseMult(sig.S.Bytes())
+	eHx, eHy := group.ScalarMult(pk.Hx, pk.Hy, e.Bytes())
+	rx, ry := addPoints(group, sig.Rx, sig.Ry, eHx, eHy)
+	return sGx.Cmp(rx) == 0 && sGy.Cmp(ry) == 0
+}
+
+// This function encodes a signature to bytes
+func (pk *PublicKey) Signature2Bytes(sig *Signature, pointCompression bool) *SignatureByte {
+	return &SignatureByte{pk.Group().Marshal(sig.Rx, sig.Ry, pointCompression), sig.S.Bytes()}
+}
+
+// This function decodes a signature, rejecting invalid points and
+// out-of-range scalars as Bytes2ZKP does
+func (pk *PublicKey) Bytes2Signature(sigBytes *SignatureByte, pointCompression bool) (*Signature, error) {
+	if sigBytes == nil {
+		return nil, errors.New("missing signature")
+	}
+	Rx, Ry, err := pk.decodeElement(sigBytes.R, pointCompression)
+	if err != nil {
+		return nil, err
+	}
+	S, err := pk.DecodeScalar(sigBytes.S)
+	if err != nil {
+		return nil, err
+	}
+	return &Signature{Rx, Ry, S}, nil
+}
+
+// This function hashes the key, the commitment and the message, with the
+// points in their fixed-length uncompressed encodings
+func signatureChallenge(group Group, Hx, Hy, Rx, Ry *big.Int, msg []byte) *big.Int {
+	buf := &bytes.Buffer{}
+	buf.WriteString(signatureLabel)
+	buf.WriteString(group.Name())
+	Gx, Gy := group.Generator()
+	buf.Write(group.Marshal(Gx, Gy, false))
+	buf.Write(group.Marshal(Hx, Hy, false))
+	buf.Write(group.Marshal(Rx, Ry, false))
+	buf.Write(msg)
+	e := big.NewInt(0).SetBytes(HashSha256(buf.Bytes()))
+	return e.Mod(e, group.Order())
+}
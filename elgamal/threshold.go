@@ -0,0 +1,427 @@
+package elgamal
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	kdf "bhwmonitoring-go/internal/kdf"
+)
+
+// Threshold ElGamal splits the target's secret key among n parties so that
+// any t of them can decrypt and fewer learn nothing about the key.
+//
+// The key is generated without a trusted dealer by Pedersen's DKG: every
+// party deals a random secret with Feldman VSS, dealers whose shares do not
+// match their commitments are disqualified, and the joint key is the sum of
+// the qualified secrets. The joint PublicKey is an ordinary key, so QueryGen
+// and the monitor use it unchanged. As noted by Gennaro et al., a rushing
+// adversary can bias the joint key of this DKG, which does not affect the
+// security of ElGamal encryption.
+//
+// To decrypt, each party publishes D_i = x_i·C1 with the DecryptionProof that
+// log_G(Y_i) = log_C1(D_i) for its verification key Y_i = x_i·G, and t valid
+// shares are combined by Lagrange interpolation at zero.
+
+// ThresholdPublicKey is the public outcome of a DKG: the joint key and the
+// verification key of every party's share
+type ThresholdPublicKey struct {
+	PK        *PublicKey
+	Threshold int
+	N         int
+	// verification key of party i at index i-1
+	VKx []*big.Int
+	VKy []*big.Int
+}
+
+// KeyShare is the secret share x_i of party Index
+type KeyShare struct {
+	Index  int
+	Priv   []byte
+	Public *ThresholdPublicKey
+}
+
+// DecryptionShare is the partial decryption of a ciphertext by one party
+type DecryptionShare struct {
+	Index int
+	Proof *DecryptionProof
+}
+
+// DKGCommitment is what a dealer broadcasts: a_k·G for the coefficients a_k
+// of its secret polynomial, constant term first
+type DKGCommitment struct {
+	Dealer int
+	Cx     []*big.Int
+	Cy     []*big.Int
+}
+
+// DKGParty is one participant of the key generation
+type DKGParty struct {
+	group     Group
+	index     int
+	threshold int
+	n         int
+
+	coeffs      []*big.Int
+	commitment  *DKGCommitment
+	shares      map[int]*big.Int
+	commitments map[int]*DKGCommitment
+}
+
+// This function starts the key generation for party index in [1, n] with
+// threshold t and draws its secret polynomial
+func NewDKGParty(group Group, index, threshold, n int) (*DKGParty, error) {
+
+	if threshold < 1 || threshold > n {
+		return nil, errors.New("threshold must be between 1 and the number of parties")
+	}
+	if index < 1 || index > n {
+		return nil, errors.New("party index must be between 1 and the number of parties")
+	}
+
+	coeffs := make([]*big.Int, threshold)
+	commitment := &DKGCommitment{Dealer: index, Cx: make([]*big.Int, threshold), Cy: make([]*big.Int, threshold)}
+	for k := range coeffs {
+		coeffs[k] = big.NewInt(0)
+		for coeffs[k].Sign() == 0 {
+			coeffs[k].SetBytes(newCryptoRandom(group.Order().Bytes()))
+		}
+		commitment.Cx[k], commitment.Cy[k] = group.ScalarBaseMult(coeffs[k].Bytes())
+	}
+
+	return &DKGParty{
+		group:       group,
+		index:       index,
+		threshold:   threshold,
+		n:           n,
+		coeffs:      coeffs,
+		commitment:  commitment,
+		shares:      make(map[int]*big.Int),
+		commitments: make(map[int]*DKGCommitment),
+	}, nil
+}
+
+// This function returns the commitment to broadcast to all parties
+func (p *DKGParty) Commitment() *DKGCommitment {
+	return p.commitment
+}
+
+// This function returns the share f(j) to send privately to party j
+func (p *DKGParty) ShareFor(j int) *big.Int {
+	return evalPolynomial(p.coeffs, big.NewInt(int64(j)), p.group.Order())
+}
+
+// This function checks the share received from a dealer against the dealer's
+// commitment and keeps both. An error means the dealer must be reported and
+// disqualified.
+func (p *DKGParty) ReceiveShare(commitment *DKGCommitment, share *big.Int) error {
+
+	if commitment == nil || share == nil {
+		return errors.New("missing DKG commitment or share")
+	}
+	if len(commitment.Cx) != p.threshold || len(commitment.Cy) != p.threshold {
+		return fmt.Errorf("dealer %d committed to a polynomial of the wrong degree", commitment.Dealer)
+	}
+	for k := range commitment.Cx {
+		if !p.group.IsOnGroup(commitment.Cx[k], commitment.Cy[k]) || p.group.IsIdentity(commitment.Cx[k], commitment.Cy[k]) {
+			return fmt.Errorf("dealer %d committed to an invalid element", commitment.Dealer)
+		}
+	}
+	if share.Sign() < 0 || share.Cmp(p.group.Order()) >= 0 {
+		return fmt.Errorf("share from dealer %d is out of range", commitment.Dealer)
+	}
+
+	// s·G = Σ_k index^k·C_k
+	sx, sy := p.group.ScalarBaseMult(share.Bytes())
+	ex, ey := evalCommitment(p.group, commitment, p.index)
+	if sx.Cmp(ex) != 0 || sy.Cmp(ey) != 0 {
+		return fmt.Errorf("share from dealer %d does not match its commitment", commitment.Dealer)
+	}
+
+	p.shares[commitment.Dealer] = share
+	p.commitments[commitment.Dealer] = commitment
+	return nil
+}
+
+// This function ends the key generation once the set of qualified dealers is
+// agreed on and returns this party's key share
+func (p *DKGParty) Finish(qualified []int, pointCompression bool) (*KeyShare, error) {
+
+	if len(qualified) == 0 {
+		return nil, errors.New("no qualified dealers")
+	}
+
+	order := p.group.Order()
+	x := big.NewInt(0)
+	commitments := make([]*DKGCommitment, 0, len(qualified))
+	seen := make(map[int]bool)
+	for _, dealer := range qualified {
+		if seen[dealer] {
+			return nil, fmt.Errorf("dealer %d qualified twice", dealer)
+		}
+		seen[dealer] = true
+		share, ok := p.shares[dealer]
+		if !ok {
+			return nil, fmt.Errorf("no valid share from qualified dealer %d", dealer)
+		}
+		x.Add(x, share)
+		commitments = append(commitments, p.commitments[dealer])
+	}
+	x.Mod(x, order)
+
+	public, err := thresholdPublicKey(p.group, commitments, p.threshold, p.n, pointCompression)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyShare{Index: p.index, Priv: x.FillBytes(make([]byte, len(order.Bytes()))), Public: public}, nil
+}
+
+// This function derives the joint key and the verification keys from the
+// commitments of the qualified dealers
+func thresholdPublicKey(group Group, commitments []*DKGCommitment, threshold, n int, pointCompression bool) (*ThresholdPublicKey, error) {
+
+	xs := make([]*big.Int, len(commitments))
+	ys := make([]*big.Int, len(commitments))
+	for i, c := range commitments {
+		xs[i], ys[i] = c.Cx[0], c.Cy[0]
+	}
+	Hx, Hy := groupSum(group, xs, ys)
+	pk, err := newPublicKey(group, Hx, Hy)
+	if err != nil {
+		return nil, err
+	}
+	pk.PointCompression = pointCompression
+
+	tpk := &ThresholdPublicKey{PK: pk, Threshold: threshold, N: n, VKx: make([]*big.Int, n), VKy: make([]*big.Int, n)}
+	for j := 1; j <= n; j++ {
+		for i, c := range commitments {
+			xs[i], ys[i] = evalCommitment(group, c, j)
+		}
+		tpk.VKx[j-1], tpk.VKy[j-1] = groupSum(group, xs, ys)
+	}
+	return tpk, nil
+}
+
+// This function computes the partial decryption of a ciphertext with its proof
+func (ks *KeyShare) PartialDecrypt(c *Ciphertext) (*DecryptionShare, error) {
+	group := ks.Public.PK.Group()
+	vkx, vky := ks.Public.VKx[ks.Index-1], ks.Public.VKy[ks.Index-1]
+	return &DecryptionShare{ks.Index, proveDecryption(group, ks.Priv, vkx, vky, c)}, nil
+}
+
+// This function overwrites the secret share with zeros
+func (ks *KeyShare) Wipe() {
+	kdf.Wipe(ks.Priv)
+}
+
+// This function checks a party's decryption share of a ciphertext
+func (tpk *ThresholdPublicKey) VerifyShare(c *Ciphertext, share *DecryptionShare) bool {
+	if share == nil || share.Proof == nil || share.Index < 1 || share.Index > tpk.N {
+		return false
+	}
+	group := tpk.PK.Group()
+	return verifyDecryption(group, tpk.VKx[share.Index-1], tpk.VKy[share.Index-1], c, share.Proof)
+}
+
+// This function verifies the decryption shares and combines the first
+// Threshold valid ones from distinct parties into the plaintext element
+// C2 - x·C1. Invalid and duplicate shares are skipped.
+func (tpk *ThresholdPublicKey) Combine(c *Ciphertext, shares []*DecryptionShare) (*big.Int, *big.Int, error) {
+
+	valid := make([]*DecryptionShare, 0, tpk.Threshold)
+	seen := make(map[int]bool)
+	for _, share := range shares {
+		if len(valid) == tpk.Threshold {
+			break
+		}
+		if !tpk.VerifyShare(c, share) || seen[share.Index] {
+			continue
+		}
+		seen[share.Index] = true
+		valid = append(valid, share)
+	}
+	if len(valid) < tpk.Threshold {
+		return nil, nil, fmt.Errorf("%d valid decryption shares, %d needed", len(valid), tpk.Threshold)
+	}
+	return tpk.interpolate(c, valid)
+}
+
+// This function combines verified shares from distinct parties
+func (tpk *ThresholdPublicKey) interpolate(c *Ciphertext, valid []*DecryptionShare) (*big.Int, *big.Int, error) {
+
+	group := tpk.PK.Group()
+
+	indices := make([]int, len(valid))
+	for i, share := range valid {
+		indices[i] = share.Index
+	}
+	xs := make([]*big.Int, len(valid))
+	ys := make([]*big.Int, len(valid))
+	ks := make([]*big.Int, len(valid))
+	for i, share := range valid {
+		xs[i], ys[i] = share.Proof.Dx, share.Proof.Dy
+		ks[i] = lagrangeAtZero(indices, i, group.Order())
+	}
+	Dx, Dy := groupMultiScalarMult(group, xs, ys, ks)
+
+	nDx, nDy := group.Neg(Dx, Dy)
+	mx, my := addPoints(group, c.C2x, c.C2y, nDx, nDy)
+	return mx, my, nil
+}
+
+// PartialDecrypter is a party that answers partial decryption requests, e.g. a
+// KeyShare in the same process
+type PartialDecrypter interface {
+	PartialDecrypt(c *Ciphertext) (*DecryptionShare, error)
+}
+
+// ThresholdDecryptor is a Decryptor that asks parties for decryption shares
+// until Threshold of them verify
+type ThresholdDecryptor struct {
+	tpk     *ThresholdPublicKey
+	parties []PartialDecrypter
+}
+
+// This function returns a Decryptor for the joint key that collects shares
+// from the parties in order
+func NewThresholdDecryptor(tpk *ThresholdPublicKey, parties []PartialDecrypter) *ThresholdDecryptor {
+	return &ThresholdDecryptor{tpk, parties}
+}
+
+func (d *ThresholdDecryptor) PublicKey() *PublicKey {
+	return d.tpk.PK
+}
+
+func (d *ThresholdDecryptor) DecryptAndCheck0(c *Ciphertext) (bool, error) {
+	mx, my, err := d.combine(c)
+	if err != nil {
+		return false, err
+	}
+	return d.tpk.PK.Group().IsIdentity(mx, my), nil
+}
+
+func (d *ThresholdDecryptor) Decrypt(c *Ciphertext) ([]byte, error) {
+	mx, my, err := d.combine(c)
+	if err != nil {
+		return nil, err
+	}
+	return d.tpk.PK.Group().Extract(mx, my), nil
+}
+
+func (d *ThresholdDecryptor) DecapsulateKey(c *Ciphertext) ([]byte, error) {
+	mx, my, err := d.combine(c)
+	if err != nil {
+		return nil, err
+	}
+	return deriveHybridKey(d.tpk.PK.Group(), mx, my), nil
+}
+
+// No single party knows the joint key, so the proof that a combined share is
+// correct is the set of verified DecryptionShares, not one DecryptionProof
+func (d *ThresholdDecryptor) ProveDecryption(c *Ciphertext) (*DecryptionProof, error) {
+	return nil, errors.New("threshold decryption is proven by the individual decryption shares")
+}
+
+func (d *ThresholdDecryptor) combine(c *Ciphertext) (*big.Int, *big.Int, error) {
+
+	shares := make([]*DecryptionShare, 0, d.tpk.Threshold)
+	seen := make(map[int]bool)
+	for _, party := range d.parties {
+		share, err := party.PartialDecrypt(c)
+		if err != nil || !d.tpk.VerifyShare(c, share) || seen[share.Index] {
+			continue
+		}
+		seen[share.Index] = true
+		shares = append(shares, share)
+		if len(shares) == d.tpk.Threshold {
+			return d.tpk.interpolate(c, shares)
+		}
+	}
+	return nil, nil, fmt.Errorf("%d valid decryption shares, %d needed", len(shares), d.tpk.Threshold)
+}
+
+// This function runs the key generation among n honest parties in one process
+// and returns the threshold key with every party's share. It is meant for tests
+// and benchmarks; a deployment runs one DKGParty per server.
+func SimulateDKG(group Group, threshold, n int, pointCompression bool) (*ThresholdPublicKey, []*KeyShare, error) {
+
+	parties := make([]*DKGParty, n)
+	for i := range parties {
+		p, err := NewDKGParty(group, i+1, threshold, n)
+		if err != nil {
+			return nil, nil, err
+		}
+		parties[i] = p
+	}
+
+	// every dealer sends a share to every party, itself included, and is
+	// disqualified on any complaint
+	disqualified := make(map[int]bool)
+	for _, dealer := range parties {
+		for _, receiver := range parties {
+			if err := receiver.ReceiveShare(dealer.Commitment(), dealer.ShareFor(receiver.index)); err != nil {
+				disqualified[dealer.index] = true
+			}
+		}
+	}
+	qualified := make([]int, 0, n)
+	for _, dealer := range parties {
+		if !disqualified[dealer.index] {
+			qualified = append(qualified, dealer.index)
+		}
+	}
+
+	shares := make([]*KeyShare, n)
+	for i, p := range parties {
+		ks, err := p.Finish(qualified, pointCompression)
+		if err != nil {
+			return nil, nil, err
+		}
+		shares[i] = ks
+	}
+	return shares[0].Public, shares, nil
+}
+
+// This function returns Σ_k coeffs[k]·x^k mod order
+func evalPolynomial(coeffs []*big.Int, x, order *big.Int) *big.Int {
+	res := big.NewInt(0)
+	for k := len(coeffs) - 1; k >= 0; k-- {
+		res.Mul(res, x)
+		res.Add(res, coeffs[k])
+		res.Mod(res, order)
+	}
+	return res
+}
+
+// This function returns Σ_k j^k·C_k, the public image of the dealer's share
+// for party j
+func evalCommitment(group Group, commitment *DKGCommitment, j int) (*big.Int, *big.Int) {
+	ks := make([]*big.Int, len(commitment.Cx))
+	pow := big.NewInt(1)
+	for k := range ks {
+		ks[k] = big.NewInt(0).Set(pow)
+		pow.Mul(pow, big.NewInt(int64(j)))
+		pow.Mod(pow, group.Order())
+	}
+	return groupMultiScalarMult(group, commitment.Cx, commitment.Cy, ks)
+}
+
+// This function returns the Lagrange coefficient at zero of indices[i] over
+// the given party indices
+func lagrangeAtZero(indices []int, i int, order *big.Int) *big.Int {
+	num := big.NewInt(1)
+	den := big.NewInt(1)
+	xi := big.NewInt(int64(indices[i]))
+	for m, index := range indices {
+		if m == i {
+			continue
+		}
+		xm := big.NewInt(int64(index))
+		num.Mul(num, xm)
+		num.Mod(num, order)
+		den.Mul(den, big.NewInt(0).Sub(xm, xi))
+		den.Mod(den, order)
+	}
+	den.ModInverse(den, order)
+	return num.Mul(num, den).Mod(num, order)
+}
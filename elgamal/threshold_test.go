@@ -0,0 +1,160 @@
+package elgamal
+
+import (
+	"math/big"
+	"testing"
+)
+
+const (
+	testThreshold = 3
+	testParties   = 5
+)
+
+func thresholdGroups(t *testing.T) []Group {
+	var groups []Group
+	for _, name := range []string{"P-256", "ristretto255"} {
+		group, err := GroupByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// This function returns a copy of the share whose proof tamper may change
+func tamperedShare(share *DecryptionShare, tamper func(share *DecryptionShare)) *DecryptionShare {
+	proof := *share.Proof
+	out := &DecryptionShare{share.Index, &proof}
+	tamper(out)
+	return out
+}
+
+// Any t of the n shares decrypt, and the shares interpolate to the secret key
+// of the joint public key
+func TestSimulateDKGCombine(t *testing.T) {
+	for _, group := range thresholdGroups(t) {
+		t.Run(group.Name(), func(t *testing.T) {
+
+			tpk, shares, err := SimulateDKG(group, testThreshold, testParties, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(shares) != testParties || tpk.Threshold != testThreshold || tpk.N != testParties {
+				t.Fatalf("got %d shares of a %d-of-%d key", len(shares), tpk.Threshold, tpk.N)
+			}
+
+			c := tpk.PK.Encrypt(big.NewInt(5))
+			wantX, wantY := group.ScalarBaseMult(big.NewInt(5).Bytes())
+			for _, subset := range [][]int{{1, 2, 3}, {2, 4, 5}, {5, 3, 1}} {
+				decShares := make([]*DecryptionShare, 0, len(subset))
+				indices := make([]int, 0, len(subset))
+				for _, i := range subset {
+					share, err := shares[i-1].PartialDecrypt(c)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !tpk.VerifyShare(c, share) {
+						t.Fatalf("share of party %d does not verify", i)
+					}
+					decShares = append(decShares, share)
+					indices = append(indices, i)
+				}
+				mx, my, err := tpk.Combine(c, decShares)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if mx.Cmp(wantX) != 0 || my.Cmp(wantY) != 0 {
+					t.Fatalf("parties %v combine to another plaintext", subset)
+				}
+
+				x := big.NewInt(0)
+				for k, i := range indices {
+					xi := new(big.Int).SetBytes(shares[i-1].Priv)
+					x.Add(x, xi.Mul(xi, lagrangeAtZero(indices, k, group.Order())))
+				}
+				x.Mod(x, group.Order())
+				if hx, hy := group.ScalarBaseMult(x.Bytes()); hx.Cmp(tpk.PK.Hx) != 0 || hy.Cmp(tpk.PK.Hy) != 0 {
+					t.Fatalf("shares of parties %v interpolate to another key", subset)
+				}
+			}
+
+			parties := make([]PartialDecrypter, 0, testThreshold)
+			for _, ks := range shares[testParties-testThreshold:] {
+				parties = append(parties, ks)
+			}
+			d := NewThresholdDecryptor(tpk, parties)
+			for _, m := range []int64{0, 1} {
+				isZero, err := d.DecryptAndCheck0(tpk.PK.Encrypt(big.NewInt(m)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if isZero != (m == 0) {
+					t.Fatalf("DecryptAndCheck0(Enc(%d)) = %v", m, isZero)
+				}
+			}
+		})
+	}
+}
+
+// Fewer than t valid shares from distinct parties must not decrypt, whether
+// they are missing, repeated or tampered with
+func TestSimulateDKGRejects(t *testing.T) {
+	for _, group := range thresholdGroups(t) {
+		t.Run(group.Name(), func(t *testing.T) {
+
+			tpk, shares, err := SimulateDKG(group, testThreshold, testParties, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := tpk.PK.Encrypt(big.NewInt(5))
+			decShares := make([]*DecryptionShare, testParties)
+			for i, ks := range shares {
+				if decShares[i], err = ks.PartialDecrypt(c); err != nil {
+					t.Fatal(err)
+				}
+			}
+			gx, gy := group.Generator()
+			one := big.NewInt(1)
+
+			for _, tc := range []struct {
+				name     string
+				share    *DecryptionShare
+				verifies bool
+				combines bool
+			}{
+				{"honest", decShares[2], true, true},
+				{"other D", tamperedShare(decShares[2], func(s *DecryptionShare) {
+					s.Proof.Dx, s.Proof.Dy = addPoints(group, s.Proof.Dx, s.Proof.Dy, gx, gy)
+				}), false, false},
+				{"other A", tamperedShare(decShares[2], func(s *DecryptionShare) {
+					s.Proof.Ax, s.Proof.Ay = addPoints(group, s.Proof.Ax, s.Proof.Ay, gx, gy)
+				}), false, false},
+				{"other S", tamperedShare(decShares[2], func(s *DecryptionShare) {
+					s.Proof.S = new(big.Int).Add(s.Proof.S, one)
+				}), false, false},
+				{"other party", tamperedShare(decShares[2], func(s *DecryptionShare) { s.Index = 4 }), false, false},
+				{"unknown party", tamperedShare(decShares[2], func(s *DecryptionShare) { s.Index = testParties + 1 }), false, false},
+				{"no proof", &DecryptionShare{3, nil}, false, false},
+				{"repeated", decShares[0], true, false},
+			} {
+				if got := tpk.VerifyShare(c, tc.share); got != tc.verifies {
+					t.Errorf("%s: VerifyShare = %v", tc.name, got)
+				}
+				// t-1 honest shares and the one under test
+				_, _, err := tpk.Combine(c, []*DecryptionShare{decShares[0], decShares[1], tc.share})
+				if (err == nil) != tc.combines {
+					t.Errorf("%s: Combine returned %v", tc.name, err)
+				}
+			}
+
+			if _, _, err := tpk.Combine(c, decShares[:testThreshold-1]); err == nil {
+				t.Fatal("t-1 shares decrypted")
+			}
+			parties := []PartialDecrypter{shares[0], shares[1]}
+			if _, err := NewThresholdDecryptor(tpk, parties).DecryptAndCheck0(c); err == nil {
+				t.Fatal("t-1 parties decrypted")
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package ecarith
+
+import (
+	"crypto/elliptic"
+	"math/big"
+	"sync"
+)
+
+// Curve is a short Weierstrass curve y^2 = x^3 - 3x + b over GF(p)
+type Curve struct {
+	Params *elliptic.CurveParams
+	f      *field
+	b      fe
+}
+
+// Point is a point in Jacobian coordinates (X/Z^2, Y/Z^3); Z = 0 is infinity
+type Point struct {
+	x, y, z fe
+}
+
+// affine is a precomputed point with Z = 1
+type affine struct {
+	x, y fe
+	inf  bool
+}
+
+var curves = struct {
+	sync.Mutex
+	m map[string]*Curve
+}{m: make(map[string]*Curve)}
+
+// This function returns the arithmetic for the given NIST curve
+func For(curve elliptic.Curve) *Curve {
+
+	params := curve.Params()
+
+	curves.Lock()
+	defer curves.Unlock()
+	if c, ok := curves.m[params.Name]; ok {
+		return c
+	}
+
+	c := &Curve{Params: params, f: newField(params.P)}
+	c.f.fromBig(&c.b, params.B)
+	curves.m[params.Name] = c
+	return c
+}
+
+// This function returns the point at infinity
+func (c *Curve) NewPoint() *Point {
+	return &Point{}
+}
+
+// This function converts affine coordinates to a Jacobian point
+func (c *Curve) SetAffine(p *Point, x, y *big.Int) *Point {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		*p = Point{}
+		return p
+	}
+	c.f.fromBig(&p.x, x)
+	c.f.fromBig(&p.y, y)
+	p.z = c.f.one
+	return p
+}
+
+// This function converts a Jacobian point back to affine coordinates
+func (c *Curve) Affine(p *Point) (*big.Int, *big.Int) {
+	if c.IsInfinity(p) {
+		return new(big.Int), new(big.Int)
+	}
+	f := c.f
+	var zInv, zInv2, zInv3, x, y fe
+	f.inv(&zInv, &p.z)
+	f.sqr(&zInv2, &zInv)
+	f.mul(&zInv3, &zInv2, &zInv)
+	f.mul(&x, &p.x, &zInv2)
+	f.mul(&y, &p.y, &zInv3)
+	return f.toBig(&x), f.toBig(&y)
+}
+
+func (c *Curve) IsInfinity(p *Point) bool {
+	return c.f.isZero(&p.z)
+}
+
+// This function sets r = 2p (dbl-2001-b)
+func (c *Curve) Double(r, p *Point) *Point {
+
+	f := c.f
+	if f.isZero(&p.z) || f.isZero(&p.y) {
+		*r = Point{}
+		return r
+	}
+
+	var delta, gamma, beta, alpha, t1, t2, x3, y3, z3 fe
+	f.sqr(&delta, &p.z)
+	f.sqr(&gamma, &p.y)
+	f.mul(&beta, &p.x, &gamma)
+
+	f.sub(&t1, &p.x, &delta)
+	f.add(&t2, &p.x, &delta)
+	f.mul(&alpha, &t1, &t2)
+	f.add(&t1, &alpha, &alpha)
+	f.add(&alpha, &alpha, &t1)
+
+	f.sqr(&x3, &alpha)
+	f.add(&t1, &beta, &beta)
+	f.add(&t1, &t1, &t1)
+	f.add(&t2, &t1, &t1)
+	f.sub(&x3, &x3, &t2)
+
+	f.add(&z3, &p.y, &p.z)
+	f.sqr(&z3, &z3)
+	f.sub(&z3, &z3, &gamma)
+	f.sub(&z3, &z3, &delta)
+
+	f.sub(&t1, &t1, &x3)
+	f.mul(&y3, &alpha, &t1)
+	f.sqr(&t2, &gamma)
+	f.add(&t2, &t2, &t2)
+	f.add(&t2, &t2, &t2)
+	f.add(&t2, &t2, &t2)
+	f.sub(&y3, &y3, &t2)
+
+	r.x, r.y, r.z = x3, y3, z3
+	return r
+}
+
+// This function sets r = p + q (add-2007-bl)
+func (c *Curve) Add(r, p, q *Point) *Point {
+
+	f := c.f
+	if f.isZero(&p.z) {
+		*r = *q
+		return r
+	}
+	if f.isZero(&q.z) {
+		*r = *p
+		return r
+	}
+
+	var z1z1, z2z2, u1, u2, s1, s2, h, i, j, rr, v, t, x3, y3, z3 fe
+	f.sqr(&z1z1, &p.z)
+	f.sqr(&z2z2, &q.z)
+	f.mul(&u1, &p.x, &z2z2)
+	f.mul(&u2, &q.x, &z1z1)
+	f.mul(&s1, &p.y, &q.z)
+	f.mul(&s1, &s1, &z2z2)
+	f.mul(&s2, &q.y, &p.z)
+	f.mul(&s2, &s2, &z1z1)
+
+	f.sub(&h, &u2, &u1)
+	f.sub(&rr, &s2, &s1)
+	if f.isZero(&h) {
+		if f.isZero(&rr) {
+			return c.Double(r, p)
+		}
+		*r = Point{}
+		return r
+	}
+	f.add(&rr, &rr, &rr)
+
+	f.add(&i, &h, &h)
+	f.sqr(&i, &i)
+	f.mul(&j, &h, &i)
+	f.mul(&v, &u1, &i)
+
+	f.sqr(&x3, &rr)
+	f.sub(&x3, &x3, &j)
+	f.sub(&x3, &x3, &v)
+	f.sub(&x3, &x3, &v)
+
+	f.sub(&t, &v, &x3)
+	f.mul(&y3, &rr, &t)
+	f.mul(&t, &s1, &j)
+	f.add(&t, &t, &t)
+	f.sub(&y3, &y3, &t)
+
+	f.add(&z3, &p.z, &q.z)
+	f.sqr(&z3, &z3)
+	f.sub(&z3, &z3, &z1z1)
+	f.sub(&z3, &z3, &z2z2)
+	f.mul(&z3, &z3, &h)
+
+	r.x, r.y, r.z = x3, y3, z3
+	return r
+}
+
+// This function sets r = p + q for an affine q (madd-2007-bl)
+func (c *Curve) addMixed(r, p *Point, q *affine) *Point {
+
+	f := c.f
+	if q.inf {
+		*r = *p
+		return r
+	}
+	if f.isZero(&p.z) {
+		r.x, r.y, r.z = q.x, q.y, f.one
+		return r
+	}
+	return c.madd(r, p, q)
+}
+
+// This function sets r = p + q for an affine q without branching on whether p
+// is the point at infinity, which it must hold as (X:Y:0) with X, Y != 0 so
+// that the formula does not mistake it for q. Equal or opposite operands,
+// which a fixed-base multiplication meets for a negligible fraction of the
+// scalars only, still branch.
+func (c *Curve) addMixedConst(r, p *Point, q *affine) *Point {
+	f := c.f
+	inf := f.zeroFlag(&p.z)
+	c.madd(r, p, q)
+	f.cmov(&r.x, &q.x, inf)
+	f.cmov(&r.y, &q.y, inf)
+	f.cmov(&r.z, &f.one, inf)
+	return r
+}
+
+// madd is the addition formula of addMixed for a finite affine q
+func (c *Curve) madd(r, p *Point, q *affine) *Point {
+
+	f := c.f
+	var z1z1, u2, s2, h, hh, i, j, rr, v, t, x3, y3, z3 fe
+	f.sqr(&z1z1, &p.z)
+	f.mul(&u2, &q.x, &z1z1)
+	f.mul(&s2, &q.y, &p.z)
+	f.mul(&s2, &s2, &z1z1)
+
+	f.sub(&h, &u2, &p.x)
+	f.sub(&rr, &s2, &p.y)
+	if f.isZero(&h) {
+		if f.isZero(&rr) {
+			return c.Double(r, p)
+		}
+		*r = Point{}
+		return r
+	}
+	f.add(&rr, &rr, &rr)
+
+	f.sqr(&hh, &h)
+	f.add(&i, &hh, &hh)
+	f.add(&i, &i, &i)
+	f.mul(&j, &h, &i)
+	f.mul(&v, &p.x, &i)
+
+	f.sqr(&x3, &rr)
+	f.sub(&x3, &x3, &j)
+	f.sub(&x3, &x3, &v)
+	f.sub(&x3, &x3, &v)
+
+	f.sub(&t, &v, &x3)
+	f.mul(&y3, &rr, &t)
+	f.mul(&t, &p.y, &j)
+	f.add(&t, &t, &t)
+	f.sub(&y3, &y3, &t)
+
+	f.add(&z3, &p.z, &h)
+	f.sqr(&z3, &z3)
+	f.sub(&z3, &z3, &z1z1)
+	f.sub(&z3, &z3, &hh)
+
+	r.x, r.y, r.z = x3, y3, z3
+	return r
+}
+
+// This function sets r = -p
+func (c *Curve) Neg(r, p *Point) *Point {
+	r.x, r.z = p.x, p.z
+	c.f.neg(&r.y, &p.y)
+	return r
+}
+
+// This function converts Jacobian points to affine form with a single field
+// inversion (Montgomery's trick)
+func (c *Curve) normalize(ps []*Point) []affine {
+
+	f := c.f
+	out := make([]affine, len(ps))
+	prefix := make([]fe, len(ps))
+
+	acc := f.one
+	for i, p := range ps {
+		prefix[i] = acc
+		if !f.isZero(&p.z) {
+			f.mul(&acc, &acc, &p.z)
+		}
+	}
+
+	var accInv fe
+	f.inv(&accInv, &acc)
+
+	for i := len(ps) - 1; i >= 0; i-- {
+		p := ps[i]
+		if f.isZero(&p.z) {
+			out[i].inf = true
+			continue
+		}
+		var zInv, zInv2, zInv3 fe
+		f.mul(&zInv, &accInv, &prefix[i])
+		f.mul(&accInv, &accInv, &p.z)
+		f.sqr(&zInv2, &zInv)
+		f.mul(&zInv3, &zInv2, &zInv)
+		f.mul(&out[i].x, &p.x, &zInv2)
+		f.mul(&out[i].y, &p.y, &zInv3)
+	}
+
+	return out
+}
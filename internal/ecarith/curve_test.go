@@ -0,0 +1,160 @@
+package ecarith
+
+import (
+	"crypto/elliptic"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+var testCurves = []elliptic.Curve{elliptic.P224(), elliptic.P256(), elliptic.P384(), elliptic.P521()}
+
+// This function returns points and scalars that exercise the exceptional
+// cases: the point at infinity, a point repeated and next to its negation,
+// and scalars that are zero, negative or at least the group order
+func msmInputs(curve elliptic.Curve, n int, rnd *rand.Rand) (xs, ys, ks []*big.Int) {
+
+	params := curve.Params()
+	for i := 0; i < n; i++ {
+		var x, y *big.Int
+		switch i % 8 {
+		case 3:
+			x, y = new(big.Int), new(big.Int)
+		case 5:
+			x, y = xs[i-1], ys[i-1]
+		case 6:
+			x, y = xs[i-2], new(big.Int).Sub(params.P, ys[i-2])
+		default:
+			x, y = curve.ScalarBaseMult(new(big.Int).Rand(rnd, params.N).Bytes())
+		}
+		xs, ys = append(xs, x), append(ys, y)
+
+		var k *big.Int
+		switch i % 7 {
+		case 0:
+			k = new(big.Int)
+		case 1:
+			k = new(big.Int).Set(params.N)
+		case 2:
+			k = new(big.Int).Add(params.N, big.NewInt(int64(i)))
+		case 3:
+			k = new(big.Int).Neg(new(big.Int).Rand(rnd, params.N))
+		case 4:
+			k = new(big.Int).Lsh(big.NewInt(int64(i)+1), 600)
+		default:
+			k = new(big.Int).Rand(rnd, params.N)
+		}
+		ks = append(ks, k)
+	}
+	return xs, ys, ks
+}
+
+// This function computes Σ ks[i]·(xs[i], ys[i]) with crypto/elliptic alone
+func referenceMSM(curve elliptic.Curve, xs, ys, ks []*big.Int) (*big.Int, *big.Int) {
+	accX, accY := new(big.Int), new(big.Int)
+	for i := range xs {
+		k := new(big.Int).Mod(ks[i], curve.Params().N)
+		if xs[i].Sign() == 0 && ys[i].Sign() == 0 || k.Sign() == 0 {
+			continue
+		}
+		x, y := curve.ScalarMult(xs[i], ys[i], k.Bytes())
+		accX, accY = curve.Add(accX, accY, x, y)
+	}
+	return accX, accY
+}
+
+func TestMultiScalarMult(t *testing.T) {
+
+	rnd := rand.New(rand.NewSource(1))
+	for _, curve := range testCurves {
+		c := For(curve)
+		// both sides of strausThreshold
+		for _, n := range []int{1, 8, strausThreshold + 9} {
+			xs, ys, ks := msmInputs(curve, n, rnd)
+			wantX, wantY := referenceMSM(curve, xs, ys, ks)
+
+			if x, y := c.MultiScalarMult(xs, ys, ks); x.Cmp(wantX) != 0 || y.Cmp(wantY) != 0 {
+				t.Errorf("%s: MultiScalarMult of %d points differs from crypto/elliptic", curve.Params().Name, n)
+			}
+			if x, y := c.Affine(c.pippenger(xs, ys, c.reduceScalars(ks))); x.Cmp(wantX) != 0 || y.Cmp(wantY) != 0 {
+				t.Errorf("%s: Pippenger over %d points differs from crypto/elliptic", curve.Params().Name, n)
+			}
+			if x, y := c.Affine(c.straus(xs, ys, c.reduceScalars(ks))); x.Cmp(wantX) != 0 || y.Cmp(wantY) != 0 {
+				t.Errorf("%s: Straus over %d points differs from crypto/elliptic", curve.Params().Name, n)
+			}
+
+			tables := make([]*MSMTable, n)
+			for i := range tables {
+				tables[i] = c.NewMSMTable(xs[i], ys[i])
+			}
+			if x, y := c.MultiScalarMultTables(tables, ks); x.Cmp(wantX) != 0 || y.Cmp(wantY) != 0 {
+				t.Errorf("%s: MultiScalarMultTables of %d points differs from crypto/elliptic", curve.Params().Name, n)
+			}
+		}
+	}
+}
+
+func TestSum(t *testing.T) {
+
+	rnd := rand.New(rand.NewSource(2))
+	for _, curve := range testCurves {
+		c := For(curve)
+		name := curve.Params().Name
+		xs, ys, _ := msmInputs(curve, 16, rnd)
+
+		wantX, wantY := new(big.Int), new(big.Int)
+		for i := range xs {
+			wantX, wantY = curve.Add(wantX, wantY, xs[i], ys[i])
+		}
+		if x, y := c.Sum(xs, ys); x.Cmp(wantX) != 0 || y.Cmp(wantY) != 0 {
+			t.Errorf("%s: Sum differs from crypto/elliptic", name)
+		}
+
+		// P + P doubles, P + (-P) cancels
+		px, py := xs[0], ys[0]
+		dx, dy := curve.Double(px, py)
+		if x, y := c.Sum([]*big.Int{px, px}, []*big.Int{py, py}); x.Cmp(dx) != 0 || y.Cmp(dy) != 0 {
+			t.Errorf("%s: P + P is not 2P", name)
+		}
+		negY := new(big.Int).Sub(curve.Params().P, py)
+		if x, y := c.Sum([]*big.Int{px, px}, []*big.Int{py, negY}); x.Sign() != 0 || y.Sign() != 0 {
+			t.Errorf("%s: P + (-P) is not the point at infinity", name)
+		}
+		if x, y := c.Sum(nil, nil); x.Sign() != 0 || y.Sign() != 0 {
+			t.Errorf("%s: the empty sum is not the point at infinity", name)
+		}
+	}
+}
+
+// Zero windows, a zero scalar and scalars of the order or above must all
+// agree with crypto/elliptic, now that every window performs an addition
+func TestFixedBaseTable(t *testing.T) {
+
+	rnd := rand.New(rand.NewSource(3))
+	for _, curve := range testCurves {
+		c := For(curve)
+		params := curve.Params()
+		hx, hy := curve.ScalarBaseMult(new(big.Int).Rand(rnd, params.N).Bytes())
+		table := c.NewFixedBaseTable(hx, hy, 6)
+
+		ks := []*big.Int{
+			new(big.Int),
+			big.NewInt(1),
+			new(big.Int).Lsh(big.NewInt(1), 64),
+			new(big.Int).Lsh(big.NewInt(63), uint(params.N.BitLen()-6)),
+			new(big.Int).Sub(params.N, big.NewInt(1)),
+			new(big.Int).Set(params.N),
+			new(big.Int).Add(params.N, big.NewInt(2)),
+			new(big.Int).Rand(rnd, params.N),
+		}
+		for _, k := range ks {
+			wantX, wantY := new(big.Int), new(big.Int)
+			if r := new(big.Int).Mod(k, params.N); r.Sign() != 0 {
+				wantX, wantY = curve.ScalarMult(hx, hy, r.Bytes())
+			}
+			if x, y := table.ScalarMult(k.Bytes()); x.Cmp(wantX) != 0 || y.Cmp(wantY) != 0 {
+				t.Errorf("%s: table gives another %v·H than crypto/elliptic", params.Name, k)
+			}
+		}
+	}
+}
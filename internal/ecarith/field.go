@@ -0,0 +1,241 @@
+// Package ecarith implements variable-time arithmetic on the NIST prime
+// curves (a = -3) with Montgomery-form field elements and Jacobian
+// coordinates. It backs the precomputation and multi-scalar paths of the
+// elgamal package; callers hand in and get back affine big.Int coordinates
+// in the crypto/elliptic convention, where (0, 0) is the point at infinity.
+//
+// Ristretto255 scalar multiplication and FixedBaseTable take time independent
+// of the scalar, as their scalars include secret keys and encryption
+// randomness; conversions to and from affine coordinates remain variable-time
+// in the point, and so does multi-scalar multiplication in its scalars.
+package ecarith
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// maxLimbs is enough for P-521
+const maxLimbs = 9
+
+type fe [maxLimbs]uint64
+
+// field is arithmetic modulo an odd prime p in Montgomery form with R = 2^(64n)
+type field struct {
+	n    int
+	p    fe
+	pInv uint64 // -p^-1 mod 2^64
+	r2   fe     // R^2 mod p
+	one  fe     // R mod p
+	pBig *big.Int
+}
+
+func newField(p *big.Int) *field {
+
+	f := &field{n: (p.BitLen() + 63) / 64, pBig: new(big.Int).Set(p)}
+	f.p = f.limbs(p)
+
+	// Newton iteration for p^-1 mod 2^64
+	inv := uint64(1)
+	for i := 0; i < 6; i++ {
+		inv *= 2 - f.p[0]*inv
+	}
+	f.pInv = -inv
+
+	r := new(big.Int).Lsh(big.NewInt(1), uint(64*f.n))
+	f.one = f.limbs(new(big.Int).Mod(r, p))
+	f.r2 = f.limbs(new(big.Int).Mod(new(big.Int).Mul(r, r), p))
+
+	return f
+}
+
+// limbs returns the little-endian limbs of 0 <= x < 2^(64n)
+func (f *field) limbs(x *big.Int) fe {
+	var z fe
+	buf := make([]byte, 8*f.n)
+	x.FillBytes(buf)
+	for i := 0; i < f.n; i++ {
+		off := len(buf) - 8*(i+1)
+		for j := 0; j < 8; j++ {
+			z[i] = z[i]<<8 | uint64(buf[off+j])
+		}
+	}
+	return z
+}
+
+// fromBig converts 0 <= x < p to Montgomery form
+func (f *field) fromBig(z *fe, x *big.Int) {
+	if x.Sign() < 0 || x.Cmp(f.pBig) >= 0 {
+		x = new(big.Int).Mod(x, f.pBig)
+	}
+	l := f.limbs(x)
+	f.mul(z, &l, &f.r2)
+}
+
+// toBig converts out of Montgomery form
+func (f *field) toBig(x *fe) *big.Int {
+	var one, t fe
+	one[0] = 1
+	f.mul(&t, x, &one)
+	buf := make([]byte, 8*f.n)
+	for i := 0; i < f.n; i++ {
+		off := len(buf) - 8*(i+1)
+		for j := 0; j < 8; j++ {
+			buf[off+j] = byte(t[i] >> (56 - 8*uint(j)))
+		}
+	}
+	return new(big.Int).SetBytes(buf)
+}
+
+func (f *field) isZero(x *fe) bool {
+	var acc uint64
+	for i := 0; i < f.n; i++ {
+		acc |= x[i]
+	}
+	return acc == 0
+}
+
+// zeroFlag returns 1 if x is zero and 0 otherwise, in time independent of x
+func (f *field) zeroFlag(x *fe) uint64 {
+	var acc uint64
+	for i := 0; i < f.n; i++ {
+		acc |= x[i]
+	}
+	return 1 ^ (acc|-acc)>>63
+}
+
+// mul sets z = x·y·R^-1 mod p (CIOS Montgomery multiplication)
+func (f *field) mul(z, x, y *fe) {
+
+	n := f.n
+	var t [maxLimbs + 2]uint64
+	xs, ps, ts := x[:n], f.p[:n], t[:n+2]
+	pInv := f.pInv
+
+	for i := 0; i < n; i++ {
+		var c, cc, hi, lo uint64
+		yi := y[i]
+		for j, xj := range xs {
+			hi, lo = bits.Mul64(xj, yi)
+			lo, cc = bits.Add64(lo, ts[j], 0)
+			hi += cc
+			lo, cc = bits.Add64(lo, c, 0)
+			ts[j] = lo
+			c = hi + cc
+		}
+		ts[n], cc = bits.Add64(ts[n], c, 0)
+		ts[n+1] = cc
+
+		m := ts[0] * pInv
+		hi, lo = bits.Mul64(m, ps[0])
+		_, cc = bits.Add64(lo, ts[0], 0)
+		c = hi + cc
+		for j := 1; j < n; j++ {
+			hi, lo = bits.Mul64(m, ps[j])
+			lo, cc = bits.Add64(lo, ts[j], 0)
+			hi += cc
+			lo, cc = bits.Add64(lo, c, 0)
+			ts[j-1] = lo
+			c = hi + cc
+		}
+		ts[n-1], cc = bits.Add64(ts[n], c, 0)
+		ts[n] = ts[n+1] + cc
+	}
+
+	f.reduceOnce(z, ts[:n+1])
+}
+
+func (f *field) sqr(z, x *fe) {
+	f.mul(z, x, x)
+}
+
+// reduceOnce sets z = t mod p for t < 2p, where t has n+1 limbs
+func (f *field) reduceOnce(z *fe, t []uint64) {
+	n := f.n
+	var d fe
+	var b uint64
+	for i := 0; i < n; i++ {
+		d[i], b = bits.Sub64(t[i], f.p[i], b)
+	}
+	_, b = bits.Sub64(t[n], 0, b)
+	// keep t on a borrow, without branching on it
+	mask := -b
+	for i := 0; i < n; i++ {
+		z[i] = d[i] ^ mask&(d[i]^t[i])
+	}
+	for i := n; i < maxLimbs; i++ {
+		z[i] = 0
+	}
+}
+
+func (f *field) add(z, x, y *fe) {
+	var t [maxLimbs + 1]uint64
+	var c uint64
+	for i := 0; i < f.n; i++ {
+		t[i], c = bits.Add64(x[i], y[i], c)
+	}
+	t[f.n] = c
+	f.reduceOnce(z, t[:f.n+1])
+}
+
+func (f *field) sub(z, x, y *fe) {
+	var t fe
+	var b uint64
+	for i := 0; i < f.n; i++ {
+		t[i], b = bits.Sub64(x[i], y[i], b)
+	}
+	// add p back on a borrow, without branching on it
+	mask := -b
+	var c uint64
+	for i := 0; i < f.n; i++ {
+		t[i], c = bits.Add64(t[i], f.p[i]&mask, c)
+	}
+	*z = t
+}
+
+// cmov sets z = x if cond is 1 and leaves z unchanged if cond is 0, in time
+// independent of cond
+func (f *field) cmov(z, x *fe, cond uint64) {
+	mask := -cond
+	for i := 0; i < maxLimbs; i++ {
+		z[i] ^= mask & (z[i] ^ x[i])
+	}
+}
+
+func (f *field) neg(z, x *fe) {
+	var zero fe
+	f.sub(z, &zero, x)
+}
+
+// inv sets z = x^-1; x must be non-zero
+func (f *field) inv(z, x *fe) {
+	xi := new(big.Int).ModInverse(f.toBig(x), f.pBig)
+	f.fromBig(z, xi)
+}
+
+// exp sets z = x^e for a non-negative exponent e
+func (f *field) exp(z, x *fe, e *big.Int) {
+	acc := f.one
+	base := *x
+	for i := e.BitLen() - 1; i >= 0; i-- {
+		f.sqr(&acc, &acc)
+		if e.Bit(i) == 1 {
+			f.mul(&acc, &acc, &base)
+		}
+	}
+	*z = acc
+}
+
+// isNegative reports whether the canonical value of x is odd (RFC 9496)
+func (f *field) isNegative(x *fe) bool {
+	return f.toBig(x).Bit(0) == 1
+}
+
+// abs sets z = -x if x is negative and z = x otherwise
+func (f *field) abs(z, x *fe) {
+	if f.isNegative(x) {
+		f.neg(z, x)
+	} else {
+		*z = *x
+	}
+}
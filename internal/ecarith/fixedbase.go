@@ -0,0 +1,87 @@
+package ecarith
+
+import (
+	"crypto/subtle"
+	"math/big"
+)
+
+// FixedBaseTable holds d·2^(w·j)·P for every window j and digit 1 <= d < 2^w,
+// so that a scalar multiplication of P costs one mixed addition per window
+// and no doublings.
+type FixedBaseTable struct {
+	c       *Curve
+	w       uint
+	windows int
+	points  []affine // windows × (2^w - 1), row-major
+}
+
+// This function precomputes the windowed table for the affine point (x, y)
+// and window width w
+func (c *Curve) NewFixedBaseTable(x, y *big.Int, w uint) *FixedBaseTable {
+
+	digits := 1<<w - 1
+	windows := (c.Params.N.BitLen() + int(w) - 1) / int(w)
+
+	jac := make([]*Point, 0, windows*digits)
+	base := c.SetAffine(c.NewPoint(), x, y)
+	for j := 0; j < windows; j++ {
+		acc := *base
+		for d := 1; d <= digits; d++ {
+			p := acc
+			jac = append(jac, &p)
+			c.Add(&acc, &acc, base)
+		}
+		// acc = 2^w · base
+		*base = acc
+	}
+
+	return &FixedBaseTable{c, w, windows, c.normalize(jac)}
+}
+
+// This function returns k·P for the table's point P. Scalars larger than the
+// table covers are reduced modulo the group order first. The scalar is
+// usually encryption randomness, so every window reads its whole row of the
+// table and performs one addition, whatever its digit.
+func (t *FixedBaseTable) ScalarMult(k []byte) (*big.Int, *big.Int) {
+	return t.c.Affine(t.mult(k))
+}
+
+func (t *FixedBaseTable) mult(k []byte) *Point {
+
+	c, f := t.c, t.c.f
+	scalar := new(big.Int).SetBytes(k)
+	if scalar.BitLen() > t.windows*int(t.w) {
+		scalar.Mod(scalar, c.Params.N)
+	}
+
+	digits := 1<<t.w - 1
+	// the point at infinity as addMixedConst needs it
+	acc := &Point{x: f.one, y: f.one}
+	var q affine
+	var sum Point
+	for j := 0; j < t.windows; j++ {
+		d := int32(window(scalar, uint(j)*t.w, t.w))
+		row := t.points[j*digits : (j+1)*digits]
+		q = row[0]
+		for i := 1; i < digits; i++ {
+			eq := uint64(subtle.ConstantTimeEq(d, int32(i+1)))
+			f.cmov(&q.x, &row[i].x, eq)
+			f.cmov(&q.y, &row[i].y, eq)
+		}
+		c.addMixedConst(&sum, acc, &q)
+		nonzero := 1 ^ uint64(subtle.ConstantTimeEq(d, 0))
+		f.cmov(&acc.x, &sum.x, nonzero)
+		f.cmov(&acc.y, &sum.y, nonzero)
+		f.cmov(&acc.z, &sum.z, nonzero)
+	}
+	return acc
+}
+
+// window returns bits [off, off+w) of k
+func window(k *big.Int, off, w uint) uint {
+	var d uint
+	for i := w; i > 0; i-- {
+		d = d<<1 | k.Bit(int(off+i-1))
+	}
+	return d
+}
@@ -0,0 +1,178 @@
+package ecarith
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// Below this many points Straus' interleaved windows beat Pippenger's buckets
+const strausThreshold = 64
+
+const strausWindow = 4
+
+// This function returns the sum of the affine points (xs[i], ys[i])
+func (c *Curve) Sum(xs, ys []*big.Int) (*big.Int, *big.Int) {
+
+	acc := c.NewPoint()
+	var q affine
+	for i := range xs {
+		c.setAffine(&q, xs[i], ys[i])
+		c.addMixed(acc, acc, &q)
+	}
+	return c.Affine(acc)
+}
+
+// This function returns Σ ks[i]·(xs[i], ys[i]). Scalars are reduced modulo the
+// group order.
+func (c *Curve) MultiScalarMult(xs, ys []*big.Int, ks []*big.Int) (*big.Int, *big.Int) {
+
+	scalars := c.reduceScalars(ks)
+	if len(xs) < strausThreshold {
+		return c.Affine(c.straus(xs, ys, scalars))
+	}
+	return c.Affine(c.pippenger(xs, ys, scalars))
+}
+
+func (c *Curve) setAffine(q *affine, x, y *big.Int) {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		*q = affine{inf: true}
+		return
+	}
+	c.f.fromBig(&q.x, x)
+	c.f.fromBig(&q.y, y)
+	q.inf = false
+}
+
+// straus interleaves fixed-window expansions of all scalars so that the
+// doublings are shared; each point gets its own table of 2^w-1 multiples
+func (c *Curve) straus(xs, ys []*big.Int, ks []*big.Int) *Point {
+
+	digits := 1<<strausWindow - 1
+
+	jac := make([]*Point, 0, len(xs)*digits)
+	for i := range xs {
+		jac = c.appendMultiples(jac, xs[i], ys[i])
+	}
+	tables := c.normalize(jac)
+
+	rows := make([][]affine, len(xs))
+	for i := range rows {
+		rows[i] = tables[i*digits : (i+1)*digits]
+	}
+	return c.strausRows(rows, ks)
+}
+
+// This function appends 1·P, ..., (2^w-1)·P for the affine point P = (x, y)
+func (c *Curve) appendMultiples(jac []*Point, x, y *big.Int) []*Point {
+	base := c.SetAffine(c.NewPoint(), x, y)
+	acc := *base
+	for d := 1; d < 1<<strausWindow; d++ {
+		p := acc
+		jac = append(jac, &p)
+		c.Add(&acc, &acc, base)
+	}
+	return jac
+}
+
+// This function runs the shared-doubling loop of Straus' method, where rows[i]
+// holds the multiples of the i-th point
+func (c *Curve) strausRows(rows [][]affine, ks []*big.Int) *Point {
+
+	w := uint(strausWindow)
+	windows := (c.Params.N.BitLen() + int(w) - 1) / int(w)
+	acc := c.NewPoint()
+	for j := windows - 1; j >= 0; j-- {
+		for s := uint(0); s < w; s++ {
+			c.Double(acc, acc)
+		}
+		for i, k := range ks {
+			d := window(k, uint(j)*w, w)
+			if d != 0 {
+				c.addMixed(acc, acc, &rows[i][d-1])
+			}
+		}
+	}
+	return acc
+}
+
+// MSMTable holds the multiples of one point that Straus' method looks up, in
+// affine form. A point that takes part in many multi-scalar multiplications
+// can keep its table instead of rebuilding it every time.
+type MSMTable struct {
+	multiples []affine
+}
+
+// This function precomputes the table of the affine point (x, y)
+func (c *Curve) NewMSMTable(x, y *big.Int) *MSMTable {
+	return &MSMTable{c.normalize(c.appendMultiples(nil, x, y))}
+}
+
+// This function returns Σ ks[i]·P_i for the points P_i of the tables.
+// Scalars are reduced modulo the group order.
+func (c *Curve) MultiScalarMultTables(ts []*MSMTable, ks []*big.Int) (*big.Int, *big.Int) {
+
+	rows := make([][]affine, len(ts))
+	for i := range ts {
+		rows[i] = ts[i].multiples
+	}
+	return c.Affine(c.strausRows(rows, c.reduceScalars(ks)))
+}
+
+// This function returns the scalars reduced modulo the group order
+func (c *Curve) reduceScalars(ks []*big.Int) []*big.Int {
+	scalars := make([]*big.Int, len(ks))
+	for i, k := range ks {
+		if k.Sign() < 0 || k.Cmp(c.Params.N) >= 0 {
+			k = new(big.Int).Mod(k, c.Params.N)
+		}
+		scalars[i] = k
+	}
+	return scalars
+}
+
+// pippenger sorts the points into buckets by window digit, so each window
+// costs one addition per point plus two per bucket
+func (c *Curve) pippenger(xs, ys []*big.Int, ks []*big.Int) *Point {
+
+	lg := bits.Len(uint(len(xs))) - 2
+	if lg < 2 {
+		lg = 2
+	}
+	if lg > 16 {
+		lg = 16
+	}
+	w := uint(lg)
+
+	points := make([]affine, len(xs))
+	for i := range xs {
+		c.setAffine(&points[i], xs[i], ys[i])
+	}
+
+	buckets := make([]Point, 1<<w-1)
+	windows := (c.Params.N.BitLen() + int(w) - 1) / int(w)
+	acc := c.NewPoint()
+	for j := windows - 1; j >= 0; j-- {
+		for s := uint(0); s < w; s++ {
+			c.Double(acc, acc)
+		}
+
+		for b := range buckets {
+			buckets[b] = Point{}
+		}
+		for i, k := range ks {
+			d := window(k, uint(j)*w, w)
+			if d != 0 {
+				c.addMixed(&buckets[d-1], &buckets[d-1], &points[i])
+			}
+		}
+
+		// Σ b·bucket[b] as a running sum from the top bucket down
+		sum, total := c.NewPoint(), c.NewPoint()
+		for b := len(buckets) - 1; b >= 0; b-- {
+			c.Add(sum, sum, &buckets[b])
+			c.Add(total, total, sum)
+		}
+		c.Add(acc, acc, total)
+	}
+	return acc
+}
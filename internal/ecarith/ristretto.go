@@ -0,0 +1,646 @@
+package ecarith
+
+import (
+	"crypto/subtle"
+	"errors"
+	"math/big"
+	"sync"
+)
+
+// Ristretto255 is the prime-order group of RFC 9496, built on the twisted
+// Edwards curve -x^2 + y^2 = 1 + d·x^2·y^2 over GF(2^255 - 19).
+//
+// Every group element is a coset of the 4-torsion E[4] in 2E, so it has four
+// Edwards representatives. The affine coordinates handed out by this type are
+// always the canonical one (see affine), which lets callers compare and
+// hash coordinates exactly as they do for the NIST curves.
+type Ristretto255 struct {
+	f     *field
+	order *big.Int
+
+	d, d2, sqrtM1, sqrtADMinusOne, invSqrtAMinusD, oneMinusDSq, dMinusOneSq fe
+	pMinus5Over8                                                            *big.Int
+
+	gx, gy *big.Int
+}
+
+// edPoint is a point in extended coordinates (X:Y:Z:T) with x = X/Z, y = Y/Z
+// and x·y = T/Z
+type edPoint struct {
+	x, y, z, t fe
+}
+
+var (
+	ristrettoOnce sync.Once
+	ristretto     *Ristretto255
+)
+
+func decimal(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("ecarith: bad constant " + s)
+	}
+	return v
+}
+
+// This function returns the ristretto255 group
+func NewRistretto255() *Ristretto255 {
+	ristrettoOnce.Do(func() {
+		p := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+		r := &Ristretto255{
+			f:     newField(p),
+			order: new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), 252), decimal("27742317777372353535851937790883648493")),
+		}
+		f := r.f
+
+		d := new(big.Int).ModInverse(big.NewInt(121666), p)
+		d.Mul(d, big.NewInt(-121665)).Mod(d, p)
+		f.fromBig(&r.d, d)
+		f.add(&r.d2, &r.d, &r.d)
+
+		f.fromBig(&r.sqrtM1, decimal("19681161376707505956807079304988542015446066515923890162744021073123829784752"))
+		f.fromBig(&r.sqrtADMinusOne, decimal("25063068953384623474111414158702152701244531502492656460079210482610430750235"))
+		f.fromBig(&r.invSqrtAMinusD, decimal("54469307008909316920995813868745141605393597292927456921205312896311721017578"))
+		f.fromBig(&r.oneMinusDSq, decimal("1159843021668779879193775521855586647937357759715417654439879720876111806838"))
+		f.fromBig(&r.dMinusOneSq, decimal("40440834346308536858101042469323190826248399146238708352240133220865137265952"))
+		r.pMinus5Over8 = new(big.Int).Rsh(new(big.Int).Sub(p, big.NewInt(5)), 3)
+
+		var b edPoint
+		f.fromBig(&b.x, decimal("15112221349535400772501151409588531511454012693041857206046113283949847762202"))
+		f.fromBig(&b.y, decimal("46316835694926478169428394003475163141307993866256225615783033603165251855960"))
+		b.z = f.one
+		f.mul(&b.t, &b.x, &b.y)
+		r.gx, r.gy = r.affine(&b)
+
+		ristretto = r
+	})
+	return ristretto
+}
+
+func (r *Ristretto255) Order() *big.Int {
+	return r.order
+}
+
+func (r *Ristretto255) Generator() (*big.Int, *big.Int) {
+	return new(big.Int).Set(r.gx), new(big.Int).Set(r.gy)
+}
+
+// The identity is represented by the Edwards neutral element (0, 1)
+func (r *Ristretto255) Identity() (*big.Int, *big.Int) {
+	return big.NewInt(0), big.NewInt(1)
+}
+
+func (r *Ristretto255) IsIdentity(x, y *big.Int) bool {
+	return x.Sign() == 0 && y.Cmp(big.NewInt(1)) == 0
+}
+
+// This function checks that (x, y) is the canonical representative of a
+// ristretto255 element
+func (r *Ristretto255) IsValid(x, y *big.Int) bool {
+	if x == nil || y == nil || x.Sign() < 0 || y.Sign() < 0 || x.Cmp(r.f.pBig) >= 0 || y.Cmp(r.f.pBig) >= 0 {
+		return false
+	}
+	if r.IsIdentity(x, y) {
+		return true
+	}
+	cx, cy, err := r.Decode(r.Encode(x, y))
+	return err == nil && cx.Cmp(x) == 0 && cy.Cmp(y) == 0
+}
+
+func (r *Ristretto255) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	var p, q edPoint
+	r.fromAffine(&p, x1, y1)
+	r.fromAffine(&q, x2, y2)
+	r.add(&p, &p, &q)
+	return r.affine(&p)
+}
+
+func (r *Ristretto255) Neg(x, y *big.Int) (*big.Int, *big.Int) {
+	var p edPoint
+	r.fromAffine(&p, x, y)
+	r.f.neg(&p.x, &p.x)
+	r.f.neg(&p.t, &p.t)
+	return r.affine(&p)
+}
+
+// This function returns the sum of all input elements with one final
+// conversion to affine form
+func (r *Ristretto255) Sum(xs, ys []*big.Int) (*big.Int, *big.Int) {
+	acc := r.identity()
+	var q edPoint
+	for i := range xs {
+		r.fromAffine(&q, xs[i], ys[i])
+		r.add(&acc, &acc, &q)
+	}
+	return r.affine(&acc)
+}
+
+// This function returns k·(x, y) for a big-endian scalar k
+func (r *Ristretto255) ScalarMult(x, y *big.Int, k []byte) (*big.Int, *big.Int) {
+	var p edPoint
+	r.fromAffine(&p, x, y)
+	return r.affine(r.scalarMult(&p, k))
+}
+
+func (r *Ristretto255) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	return r.ScalarMult(r.gx, r.gy, k)
+}
+
+// This function returns the canonical 32-byte encoding of an element
+func (r *Ristretto255) Encode(x, y *big.Int) []byte {
+	var p edPoint
+	r.fromAffine(&p, x, y)
+	return r.encode(&p)
+}
+
+// This function decodes a 32-byte encoding, rejecting non-canonical inputs
+func (r *Ristretto255) Decode(s []byte) (*big.Int, *big.Int, error) {
+	var p edPoint
+	if err := r.decode(&p, s); err != nil {
+		return nil, nil, err
+	}
+	x, y := r.affine(&p)
+	return x, y, nil
+}
+
+// This function maps 64 uniformly random bytes to an element (RFC 9496,
+// Section 4.3.4)
+func (r *Ristretto255) FromUniformBytes(b []byte) (*big.Int, *big.Int) {
+	var p1, p2 edPoint
+	r.elligator(&p1, b[:32])
+	r.elligator(&p2, b[32:64])
+	r.add(&p1, &p1, &p2)
+	return r.affine(&p1)
+}
+
+func (r *Ristretto255) identity() edPoint {
+	return edPoint{y: r.f.one, z: r.f.one}
+}
+
+func (r *Ristretto255) fromAffine(p *edPoint, x, y *big.Int) {
+	f := r.f
+	f.fromBig(&p.x, x)
+	f.fromBig(&p.y, y)
+	p.z = f.one
+	f.mul(&p.t, &p.x, &p.y)
+}
+
+// affine converts to affine coordinates and picks the canonical member of the
+// coset {(x, y), (-x, -y), (i·y, i·x), (-i·y, -i·x)}: the one with the
+// smallest y, ties broken by the smallest x. The coset of the identity is
+// always mapped to (0, 1).
+func (r *Ristretto255) affine(p *edPoint) (*big.Int, *big.Int) {
+
+	f := r.f
+	if f.isZero(&p.x) || f.isZero(&p.y) {
+		return r.Identity()
+	}
+
+	var zInv, x, y, ix, iy, nx, ny, nix, niy fe
+	f.inv(&zInv, &p.z)
+	f.mul(&x, &p.x, &zInv)
+	f.mul(&y, &p.y, &zInv)
+	f.mul(&ix, &x, &r.sqrtM1)
+	f.mul(&iy, &y, &r.sqrtM1)
+	f.neg(&nx, &x)
+	f.neg(&ny, &y)
+	f.neg(&nix, &ix)
+	f.neg(&niy, &iy)
+
+	candidates := [4][2]*big.Int{
+		{f.toBig(&x), f.toBig(&y)},
+		{f.toBig(&nx), f.toBig(&ny)},
+		{f.toBig(&iy), f.toBig(&ix)},
+		{f.toBig(&niy), f.toBig(&nix)},
+	}
+	best := 0
+	for i := 1; i < 4; i++ {
+		c := candidates[i][1].Cmp(candidates[best][1])
+		if c < 0 || (c == 0 && candidates[i][0].Cmp(candidates[best][0]) < 0) {
+			best = i
+		}
+	}
+	return candidates[best][0], candidates[best][1]
+}
+
+// add sets s = p + q (add-2008-hwcd-3, complete for a = -1)
+func (r *Ristretto255) add(s, p, q *edPoint) {
+	f := r.f
+	var a, b, c, d, e, ff, g, h, t fe
+	f.sub(&a, &p.y, &p.x)
+	f.sub(&t, &q.y, &q.x)
+	f.mul(&a, &a, &t)
+	f.add(&b, &p.y, &p.x)
+	f.add(&t, &q.y, &q.x)
+	f.mul(&b, &b, &t)
+	f.mul(&c, &p.t, &r.d2)
+	f.mul(&c, &c, &q.t)
+	f.mul(&d, &p.z, &q.z)
+	f.add(&d, &d, &d)
+	f.sub(&e, &b, &a)
+	f.sub(&ff, &d, &c)
+	f.add(&g, &d, &c)
+	f.add(&h, &b, &a)
+	f.mul(&s.x, &e, &ff)
+	f.mul(&s.y, &g, &h)
+	f.mul(&s.t, &e, &h)
+	f.mul(&s.z, &ff, &g)
+}
+
+// double sets s = 2p (dbl-2008-hwcd with a = -1)
+func (r *Ristretto255) double(s, p *edPoint) {
+	f := r.f
+	var a, b, c, d, e, g, ff, h fe
+	f.sqr(&a, &p.x)
+	f.sqr(&b, &p.y)
+	f.sqr(&c, &p.z)
+	f.add(&c, &c, &c)
+	f.neg(&d, &a)
+	f.add(&e, &p.x, &p.y)
+	f.sqr(&e, &e)
+	f.sub(&e, &e, &a)
+	f.sub(&e, &e, &b)
+	f.add(&g, &d, &b)
+	f.sub(&ff, &g, &c)
+	f.sub(&h, &d, &b)
+	f.mul(&s.x, &e, &ff)
+	f.mul(&s.y, &g, &h)
+	f.mul(&s.t, &e, &h)
+	f.mul(&s.z, &ff, &g)
+}
+
+// scalarMult uses a fixed 4-bit window over the scalar reduced to 32 bytes.
+// Scalars may be secret keys or encryption randomness, so every window reads
+// all 16 table entries and performs one addition, with table[0] the identity.
+func (r *Ristretto255) scalarMult(p *edPoint, k []byte) *edPoint {
+
+	var table [16]edPoint
+	table[0] = r.identity()
+	table[1] = *p
+	for i := 2; i < 16; i++ {
+		r.add(&table[i], &table[i-1], p)
+	}
+
+	scalar := new(big.Int).SetBytes(k)
+	if scalar.Cmp(r.order) >= 0 {
+		scalar.Mod(scalar, r.order)
+	}
+	var buf [32]byte
+	scalar.FillBytes(buf[:])
+
+	acc := r.identity()
+	var q edPoint
+	for _, b := range buf {
+		for _, nibble := range [2]byte{b >> 4, b & 0x0f} {
+			for j := 0; j < 4; j++ {
+				r.double(&acc, &acc)
+			}
+			r.lookup(&q, &table, nibble)
+			r.add(&acc, &acc, &q)
+		}
+	}
+	return &acc
+}
+
+// lookup sets q = table[i] in time independent of i
+func (r *Ristretto255) lookup(q *edPoint, table *[16]edPoint, i byte) {
+	f := r.f
+	*q = table[0]
+	for j := 1; j < 16; j++ {
+		eq := uint64(subtle.ConstantTimeByteEq(i, byte(j)))
+		f.cmov(&q.x, &table[j].x, eq)
+		f.cmov(&q.y, &table[j].y, eq)
+		f.cmov(&q.z, &table[j].z, eq)
+		f.cmov(&q.t, &table[j].t, eq)
+	}
+}
+
+// sqrtRatioM1 returns (was_square, r) with r = sqrt(u/v) or sqrt(i·u/v)
+func (r *Ristretto255) sqrtRatioM1(u, v *fe) (bool, fe) {
+
+	f := r.f
+	var v3, v7, res, check, t, negU, negUI fe
+	f.sqr(&v3, v)
+	f.mul(&v3, &v3, v)
+	f.sqr(&v7, &v3)
+	f.mul(&v7, &v7, v)
+
+	f.mul(&t, u, &v7)
+	f.exp(&t, &t, r.pMinus5Over8)
+	f.mul(&res, u, &v3)
+	f.mul(&res, &res, &t)
+
+	f.sqr(&check, &res)
+	f.mul(&check, &check, v)
+
+	f.neg(&negU, u)
+	f.mul(&negUI, &negU, &r.sqrtM1)
+	correct := check == *u
+	flipped := check == negU
+	flippedI := check == negUI
+
+	if flipped || flippedI {
+		f.mul(&res, &res, &r.sqrtM1)
+	}
+	f.abs(&res, &res)
+	return correct || flipped, res
+}
+
+func (r *Ristretto255) encode(p *edPoint) []byte {
+
+	f := r.f
+	var u1, u2, t, den1, den2, zInv, ix, iy, enchanted, x, y, denInv fe
+	f.add(&u1, &p.z, &p.y)
+	f.sub(&t, &p.z, &p.y)
+	f.mul(&u1, &u1, &t)
+	f.mul(&u2, &p.x, &p.y)
+
+	f.sqr(&t, &u2)
+	f.mul(&t, &t, &u1)
+	_, invsqrt := r.sqrtRatioM1(&f.one, &t)
+	f.mul(&den1, &invsqrt, &u1)
+	f.mul(&den2, &invsqrt, &u2)
+	f.mul(&zInv, &den1, &den2)
+	f.mul(&zInv, &zInv, &p.t)
+
+	f.mul(&ix, &p.x, &r.sqrtM1)
+	f.mul(&iy, &p.y, &r.sqrtM1)
+	f.mul(&enchanted, &den1, &r.invSqrtAMinusD)
+
+	f.mul(&t, &p.t, &zInv)
+	if f.isNegative(&t) {
+		x, y, denInv = iy, ix, enchanted
+	} else {
+		x, y, denInv = p.x, p.y, den2
+	}
+
+	f.mul(&t, &x, &zInv)
+	if f.isNegative(&t) {
+		f.neg(&y, &y)
+	}
+
+	var s fe
+	f.sub(&s, &p.z, &y)
+	f.mul(&s, &s, &denInv)
+	f.abs(&s, &s)
+
+	return littleEndian(f.toBig(&s))
+}
+
+func (r *Ristretto255) decode(p *edPoint, b []byte) error {
+
+	f := r.f
+	if len(b) != 32 {
+		return errors.New("ristretto255: encoding must be 32 bytes")
+	}
+	sBig := fromLittleEndian(b)
+	if sBig.Cmp(f.pBig) >= 0 || sBig.Bit(0) == 1 {
+		return errors.New("ristretto255: non-canonical encoding")
+	}
+
+	var s, ss, u1, u2, u2sq, v, t, denX, denY, x, y fe
+	f.fromBig(&s, sBig)
+	f.sqr(&ss, &s)
+	f.sub(&u1, &f.one, &ss)
+	f.add(&u2, &f.one, &ss)
+	f.sqr(&u2sq, &u2)
+
+	f.sqr(&v, &u1)
+	f.mul(&v, &v, &r.d)
+	f.neg(&v, &v)
+	f.sub(&v, &v, &u2sq)
+
+	f.mul(&t, &v, &u2sq)
+	wasSquare, invsqrt := r.sqrtRatioM1(&f.one, &t)
+
+	f.mul(&denX, &invsqrt, &u2)
+	f.mul(&denY, &invsqrt, &denX)
+	f.mul(&denY, &denY, &v)
+
+	f.add(&x, &s, &s)
+	f.mul(&x, &x, &denX)
+	f.abs(&x, &x)
+	f.mul(&y, &u1, &denY)
+	f.mul(&t, &x, &y)
+
+	if !wasSquare || f.isNegative(&t) || f.isZero(&y) {
+		return errors.New("ristretto255: invalid encoding")
+	}
+
+	p.x, p.y, p.z, p.t = x, y, f.one, t
+	return nil
+}
+
+// elligator is the MAP function of RFC 9496, Section 4.3.4
+func (r *Ristretto255) elligator(p *edPoint, b []byte) {
+
+	f := r.f
+	buf := make([]byte, 32)
+	copy(buf, b)
+	buf[31] &= 0x7f
+
+	var t, rr, u, v, tmp, s, c, n, w0, w1, w2, w3 fe
+	f.fromBig(&t, fromLittleEndian(buf))
+
+	f.sqr(&rr, &t)
+	f.mul(&rr, &rr, &r.sqrtM1)
+
+	f.add(&u, &rr, &f.one)
+	f.mul(&u, &u, &r.oneMinusDSq)
+
+	var minusOne fe
+	f.neg(&minusOne, &f.one)
+	f.mul(&tmp, &rr, &r.d)
+	f.sub(&v, &minusOne, &tmp)
+	f.add(&tmp, &rr, &r.d)
+	f.mul(&v, &v, &tmp)
+
+	wasSquare, s := r.sqrtRatioM1(&u, &v)
+	var sPrime fe
+	f.mul(&sPrime, &s, &t)
+	f.abs(&sPrime, &sPrime)
+	f.neg(&sPrime, &sPrime)
+	if wasSquare {
+		c = minusOne
+	} else {
+		s = sPrime
+		c = rr
+	}
+
+	f.sub(&n, &rr, &f.one)
+	f.mul(&n, &n, &c)
+	f.mul(&n, &n, &r.dMinusOneSq)
+	f.sub(&n, &n, &v)
+
+	f.add(&w0, &s, &s)
+	f.mul(&w0, &w0, &v)
+	f.mul(&w1, &n, &r.sqrtADMinusOne)
+	f.sqr(&tmp, &s)
+	f.sub(&w2, &f.one, &tmp)
+	f.add(&w3, &f.one, &tmp)
+
+	f.mul(&p.x, &w0, &w3)
+	f.mul(&p.y, &w2, &w1)
+	f.mul(&p.z, &w1, &w3)
+	f.mul(&p.t, &w0, &w2)
+}
+
+func littleEndian(x *big.Int) []byte {
+	out := make([]byte, 32)
+	x.FillBytes(out)
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+func fromLittleEndian(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+// edAffine is a precomputed point with Z = 1 in the form (y+x, y-x, 2d·x·y)
+// taken by addAffine
+type edAffine struct {
+	ypx, ymx, t2d fe
+}
+
+// addAffine sets s = p + q for a precomputed q (madd-2008-hwcd-3)
+func (r *Ristretto255) addAffine(s, p *edPoint, q *edAffine) {
+	f := r.f
+	var a, b, c, d, e, ff, g, h fe
+	f.sub(&a, &p.y, &p.x)
+	f.mul(&a, &a, &q.ymx)
+	f.add(&b, &p.y, &p.x)
+	f.mul(&b, &b, &q.ypx)
+	f.mul(&c, &p.t, &q.t2d)
+	f.add(&d, &p.z, &p.z)
+	f.sub(&e, &b, &a)
+	f.sub(&ff, &d, &c)
+	f.add(&g, &d, &c)
+	f.add(&h, &b, &a)
+	f.mul(&s.x, &e, &ff)
+	f.mul(&s.y, &g, &h)
+	f.mul(&s.t, &e, &h)
+	f.mul(&s.z, &ff, &g)
+}
+
+// This function converts points to the form of addAffine with a single field
+// inversion (Montgomery's trick). Z is never zero in extended coordinates.
+func (r *Ristretto255) normalize(ps []edPoint) []edAffine {
+
+	f := r.f
+	out := make([]edAffine, len(ps))
+	prefix := make([]fe, len(ps))
+
+	acc := f.one
+	for i := range ps {
+		prefix[i] = acc
+		f.mul(&acc, &acc, &ps[i].z)
+	}
+
+	var accInv fe
+	f.inv(&accInv, &acc)
+
+	for i := len(ps) - 1; i >= 0; i-- {
+		var zInv, x, y fe
+		f.mul(&zInv, &accInv, &prefix[i])
+		f.mul(&accInv, &accInv, &ps[i].z)
+		f.mul(&x, &ps[i].x, &zInv)
+		f.mul(&y, &ps[i].y, &zInv)
+		f.add(&out[i].ypx, &y, &x)
+		f.sub(&out[i].ymx, &y, &x)
+		f.mul(&out[i].t2d, &x, &y)
+		f.mul(&out[i].t2d, &out[i].t2d, &r.d2)
+	}
+
+	return out
+}
+
+// This function appends 1·P, ..., (2^w-1)·P for the element P = (x, y)
+func (r *Ristretto255) appendMultiples(ps []edPoint, x, y *big.Int) []edPoint {
+	var base edPoint
+	r.fromAffine(&base, x, y)
+	acc := base
+	for d := 1; d < 1<<strausWindow; d++ {
+		ps = append(ps, acc)
+		r.add(&acc, &acc, &base)
+	}
+	return ps
+}
+
+// This function returns Σ ks[i]·(xs[i], ys[i]) with Straus' method.
+// Scalars are reduced modulo the group order.
+func (r *Ristretto255) MultiScalarMult(xs, ys []*big.Int, ks []*big.Int) (*big.Int, *big.Int) {
+
+	digits := 1<<strausWindow - 1
+
+	ps := make([]edPoint, 0, len(xs)*digits)
+	for i := range xs {
+		ps = r.appendMultiples(ps, xs[i], ys[i])
+	}
+	tables := r.normalize(ps)
+
+	rows := make([][]edAffine, len(xs))
+	for i := range rows {
+		rows[i] = tables[i*digits : (i+1)*digits]
+	}
+	return r.affine(r.strausRows(rows, r.reduceScalars(ks)))
+}
+
+// RistrettoMSMTable holds the multiples of one element that MultiScalarMult
+// looks up, in the precomputed form of addAffine
+type RistrettoMSMTable struct {
+	multiples []edAffine
+}
+
+// This function precomputes the table of the element (x, y)
+func (r *Ristretto255) NewMSMTable(x, y *big.Int) *RistrettoMSMTable {
+	return &RistrettoMSMTable{r.normalize(r.appendMultiples(nil, x, y))}
+}
+
+// This function returns Σ ks[i]·P_i for the elements P_i of the tables.
+// Scalars are reduced modulo the group order.
+func (r *Ristretto255) MultiScalarMultTables(ts []*RistrettoMSMTable, ks []*big.Int) (*big.Int, *big.Int) {
+	rows := make([][]edAffine, len(ts))
+	for i := range ts {
+		rows[i] = ts[i].multiples
+	}
+	return r.affine(r.strausRows(rows, r.reduceScalars(ks)))
+}
+
+// This function runs the shared-doubling loop of Straus' method, where rows[i]
+// holds the multiples of the i-th element
+func (r *Ristretto255) strausRows(rows [][]edAffine, ks []*big.Int) *edPoint {
+
+	w := uint(strausWindow)
+	windows := (r.order.BitLen() + int(w) - 1) / int(w)
+	acc := r.identity()
+	for j := windows - 1; j >= 0; j-- {
+		for s := uint(0); s < w; s++ {
+			r.double(&acc, &acc)
+		}
+		for i, k := range ks {
+			d := window(k, uint(j)*w, w)
+			if d != 0 {
+				r.addAffine(&acc, &acc, &rows[i][d-1])
+			}
+		}
+	}
+	return &acc
+}
+
+// This function returns the scalars reduced modulo the group order
+func (r *Ristretto255) reduceScalars(ks []*big.Int) []*big.Int {
+	scalars := make([]*big.Int, len(ks))
+	for i, k := range ks {
+		if k.Sign() < 0 || k.Cmp(r.order) >= 0 {
+			k = new(big.Int).Mod(k, r.order)
+		}
+		scalars[i] = k
+	}
+	return scalars
+}
@@ -0,0 +1,51 @@
+package kdf
+
+import (
+	"crypto/hmac"
+	"hash"
+)
+
+// This function returns the pseudorandom key HKDF-Extract(salt, secret)
+// (RFC 5869, Section 2.2). A nil salt stands for a string of zeros.
+func HKDFExtract(h func() hash.Hash, secret, salt []byte) []byte {
+	if salt == nil {
+		salt = make([]byte, h().Size())
+	}
+	prf := hmac.New(h, salt)
+	prf.Write(secret)
+	return prf.Sum(nil)
+}
+
+// This function expands a pseudorandom key into length bytes bound to info
+// (RFC 5869, Section 2.3). It panics if length exceeds 255 hash outputs.
+func HKDFExpand(h func() hash.Hash, prk, info []byte, length int) []byte {
+
+	prf := hmac.New(h, prk)
+	if length > 255*prf.Size() {
+		panic("kdf: HKDF output too long")
+	}
+
+	out := make([]byte, 0, length+prf.Size())
+	var t []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		prf.Reset()
+		prf.Write(t)
+		prf.Write(info)
+		prf.Write([]byte{counter})
+		t = prf.Sum(t[:0])
+		out = append(out, t...)
+	}
+	Wipe(t)
+	res := make([]byte, length)
+	copy(res, out)
+	Wipe(out)
+	return res
+}
+
+// This function derives length bytes from a secret with HKDF-Extract followed
+// by HKDF-Expand
+func HKDF(h func() hash.Hash, secret, salt, info []byte, length int) []byte {
+	prk := HKDFExtract(h, secret, salt)
+	defer Wipe(prk)
+	return HKDFExpand(h, prk, info, length)
+}
@@ -0,0 +1,99 @@
+package kdf
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 6070: PBKDF2-HMAC-SHA1, leaving out the 2^24-iteration case
+func TestPBKDF2(t *testing.T) {
+
+	for _, c := range []struct {
+		password, salt string
+		iter           int
+		dk             string
+	}{
+		{"password", "salt", 1, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{"password", "salt", 2, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{"password", "salt", 4096, "4b007901b765489abead49d926f721d065a429c1"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"},
+		{"pass\x00word", "sa\x00lt", 4096, "56fa6aa75548099dcc37d7f03425e0c3"},
+	} {
+		want := unhex(t, c.dk)
+		if got := PBKDF2(sha1.New, []byte(c.password), []byte(c.salt), c.iter, len(want)); !bytes.Equal(got, want) {
+			t.Errorf("PBKDF2(%q, %q, %d) = %x, want %s", c.password, c.salt, c.iter, got, c.dk)
+		}
+	}
+}
+
+// RFC 5869, Appendix A: test cases 1, 3 and 4
+func TestHKDF(t *testing.T) {
+
+	for _, c := range []struct {
+		name            string
+		h               func() hash.Hash
+		ikm, salt, info string
+		prk, okm        string
+	}{
+		{
+			"SHA-256", sha256.New,
+			"0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b", "000102030405060708090a0b0c", "f0f1f2f3f4f5f6f7f8f9",
+			"077709362c2e32df0ddc3f0dc47bba6390b6c73bb50f9c3122ec844ad7c2b3e5",
+			"3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865",
+		},
+		{
+			"SHA-256 without salt or info", sha256.New,
+			"0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b", "", "",
+			"19ef24a32c717b167f33a91d6f648bdf96596776afdb6377ac434c1c293ccb04",
+			"8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8",
+		},
+		{
+			"SHA-1", sha1.New,
+			"0b0b0b0b0b0b0b0b0b0b0b", "000102030405060708090a0b0c", "f0f1f2f3f4f5f6f7f8f9",
+			"9b6c18c432a7bf8f0e71c8eb88f4b30baa2ba243",
+			"085a01ea1b10f36933068b56efa5ad81a4f14b822f5b091568a9cdd4f155fda2c22e422478d305f3f896",
+		},
+	} {
+		ikm, info := unhex(t, c.ikm), unhex(t, c.info)
+		wantPRK, wantOKM := unhex(t, c.prk), unhex(t, c.okm)
+
+		salts := [][]byte{unhex(t, c.salt)}
+		if c.salt == "" {
+			// a nil salt stands for a string of zeros, as does an empty one
+			salts = append(salts, nil)
+		}
+		for _, salt := range salts {
+			if got := HKDFExtract(c.h, ikm, salt); !bytes.Equal(got, wantPRK) {
+				t.Errorf("%s: PRK = %x, want %s", c.name, got, c.prk)
+			}
+			if got := HKDF(c.h, ikm, salt, info, len(wantOKM)); !bytes.Equal(got, wantOKM) {
+				t.Errorf("%s: OKM = %x, want %s", c.name, got, c.okm)
+			}
+		}
+		if got := HKDFExpand(c.h, wantPRK, info, len(wantOKM)); !bytes.Equal(got, wantOKM) {
+			t.Errorf("%s: HKDF-Expand = %x, want %s", c.name, got, c.okm)
+		}
+	}
+}
+
+func TestHKDFExpandTooLong(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("HKDF-Expand returned more than 255 hash outputs")
+		}
+	}()
+	HKDFExpand(sha256.New, make([]byte, 32), nil, 255*32+1)
+}
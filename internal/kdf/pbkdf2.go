@@ -0,0 +1,54 @@
+// Package kdf implements the key derivation functions used by the module
+// (PBKDF2 from RFC 8018 and HKDF from RFC 5869) on top of crypto/hmac, so
+// that no dependency outside the standard library is needed.
+package kdf
+
+import (
+	"crypto/hmac"
+	"hash"
+)
+
+// This function derives a keyLen-byte key from a password with
+// PBKDF2-HMAC (RFC 8018, Section 5.2) using the given hash constructor.
+// The intermediate blocks are zeroed before returning.
+func PBKDF2(h func() hash.Hash, password, salt []byte, iter, keyLen int) []byte {
+
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, 0, hashLen)
+	t := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u = prf.Sum(u[:0])
+		copy(t, u)
+
+		for n := 1; n < iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		dk = append(dk, t...)
+	}
+
+	Wipe(u)
+	Wipe(t)
+	out := make([]byte, keyLen)
+	copy(out, dk)
+	Wipe(dk)
+	return out
+}
+
+// This function overwrites a secret buffer with zeros
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,299 @@
+// Package keyserver lets a key-holding daemon answer elgamal.Decryptor calls
+// over a Unix socket, so that the internet-facing service that receives
+// monitor responses never loads the secret key.
+//
+// The protocol is one JSON request and one JSON reply per line. Ciphertexts
+// travel as compressed CiphertextBytes and are validated by the daemon with
+// Bytes2Ciphertext before the key is touched. Access control is the socket's
+// file mode: only processes able to connect can ask for decryptions.
+package keyserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	elgamal "bhwmonitoring-go/elgamal"
+)
+
+const (
+	opPublicKey       = "publicKey"
+	opCheck0          = "decryptAndCheck0"
+	opDecrypt         = "decrypt"
+	opDecapsulate     = "decapsulateKey"
+	opProveDecryption = "proveDecryption"
+)
+
+// Requests and replies larger than this are refused
+const maxLineSize = 1 << 20
+
+type request struct {
+	Op         string
+	Ciphertext *elgamal.CiphertextByte `json:",omitempty"`
+}
+
+type reply struct {
+	Error string                       `json:",omitempty"`
+	Bool  bool                         `json:",omitempty"`
+	Data  []byte                       `json:",omitempty"`
+	Proof *elgamal.DecryptionProofByte `json:",omitempty"`
+}
+
+// Server answers Decryptor calls with the wrapped Decryptor
+type Server struct {
+	d  elgamal.Decryptor
+	pk *elgamal.PublicKey
+}
+
+// This function returns a server around a Decryptor, usually an
+// elgamal.LocalDecryptor holding the secret key
+func NewServer(d elgamal.Decryptor) *Server {
+	return &Server{d, d.PublicKey()}
+}
+
+// This function listens on a Unix socket at path, readable and writable by the
+// owner only, and serves connections until the listener fails. A stale socket
+// file left by a previous run is replaced, but not the socket of a daemon that
+// still accepts connections.
+func (s *Server) ListenAndServe(path string) error {
+	l, err := listenPrivate(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	defer l.Close()
+	return s.Serve(l)
+}
+
+// This function binds the socket in a fresh 0700 directory next to path, so
+// that nobody else can connect before it has mode 0600, and then moves it to
+// path
+func listenPrivate(path string) (net.Listener, error) {
+
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New(path + " exists and is not a socket")
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, errors.New("a key daemon is already listening on " + path)
+		}
+	}
+
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".keyd")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// the socket is unlinked at its final path by ListenAndServe
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// This function accepts connections on l and serves each in its own goroutine
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxLineSize)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req request
+		var rep *reply
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			rep = &reply{Error: err.Error()}
+		} else {
+			rep = s.handle(&req)
+		}
+		if err := enc.Encode(rep); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) handle(req *request) *reply {
+
+	if req.Op == opPublicKey {
+		enc, err := s.pk.MarshalBinary()
+		if err != nil {
+			return &reply{Error: err.Error()}
+		}
+		return &reply{Data: enc}
+	}
+
+	c, err := s.pk.Bytes2Ciphertext(req.Ciphertext, true)
+	if err != nil {
+		return &reply{Error: err.Error()}
+	}
+
+	switch req.Op {
+	case opCheck0:
+		isZero, err := s.d.DecryptAndCheck0(c)
+		return errorOr(err, &reply{Bool: isZero})
+	case opDecrypt:
+		pt, err := s.d.Decrypt(c)
+		return errorOr(err, &reply{Data: pt})
+	case opDecapsulate:
+		key, err := s.d.DecapsulateKey(c)
+		return errorOr(err, &reply{Data: key})
+	case opProveDecryption:
+		proof, err := s.d.ProveDecryption(c)
+		if err != nil {
+			return &reply{Error: err.Error()}
+		}
+		return &reply{Proof: s.pk.DecryptionProof2Bytes(proof, true)}
+	}
+	return &reply{Error: "unknown operation " + req.Op}
+}
+
+func errorOr(err error, rep *reply) *reply {
+	if err != nil {
+		return &reply{Error: err.Error()}
+	}
+	return rep
+}
+
+// Client is a Decryptor whose calls are answered by a Server. It is safe for
+// concurrent use; calls are serialized over one connection.
+type Client struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	scanner *bufio.Scanner
+	pk      *elgamal.PublicKey
+}
+
+// This function connects to the daemon at the socket path and fetches its
+// public key
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxLineSize)
+	c := &Client{conn: conn, scanner: scanner}
+
+	rep, err := c.call(&request{Op: opPublicKey})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	pk, err := elgamal.ParsePublicKey(rep.Data)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.pk = pk
+	return c, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) PublicKey() *elgamal.PublicKey {
+	return c.pk
+}
+
+func (c *Client) DecryptAndCheck0(ct *elgamal.Ciphertext) (bool, error) {
+	rep, err := c.callWith(opCheck0, ct)
+	if err != nil {
+		return false, err
+	}
+	return rep.Bool, nil
+}
+
+func (c *Client) Decrypt(ct *elgamal.Ciphertext) ([]byte, error) {
+	rep, err := c.callWith(opDecrypt, ct)
+	if err != nil {
+		return nil, err
+	}
+	return rep.Data, nil
+}
+
+func (c *Client) DecapsulateKey(ct *elgamal.Ciphertext) ([]byte, error) {
+	rep, err := c.callWith(opDecapsulate, ct)
+	if err != nil {
+		return nil, err
+	}
+	return rep.Data, nil
+}
+
+// This function asks the daemon for a decryption share and only returns it if
+// the proof verifies against the daemon's public key
+func (c *Client) ProveDecryption(ct *elgamal.Ciphertext) (*elgamal.DecryptionProof, error) {
+	rep, err := c.callWith(opProveDecryption, ct)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := c.pk.Bytes2DecryptionProof(rep.Proof, true)
+	if err != nil {
+		return nil, err
+	}
+	if !c.pk.VerifyDecryption(ct, proof) {
+		return nil, errors.New("key daemon returned an invalid decryption proof")
+	}
+	return proof, nil
+}
+
+func (c *Client) callWith(op string, ct *elgamal.Ciphertext) (*reply, error) {
+	return c.call(&request{Op: op, Ciphertext: c.pk.Ciphertext2Bytes(ct, true)})
+}
+
+func (c *Client) call(req *request) (*reply, error) {
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.conn.Write(append(line, '\n')); err != nil {
+		return nil, err
+	}
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("key daemon closed the connection")
+	}
+
+	var rep reply
+	if err := json.Unmarshal(c.scanner.Bytes(), &rep); err != nil {
+		return nil, err
+	}
+	if rep.Error != "" {
+		return nil, errors.New("key daemon: " + rep.Error)
+	}
+	return &rep, nil
+}
@@ -0,0 +1,172 @@
+package keyserver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	elgamal "bhwmonitoring-go/elgamal"
+)
+
+// A Decryptor whose decryption proofs do not verify, as a compromised or
+// buggy daemon might return them
+type lyingDecryptor struct {
+	*elgamal.LocalDecryptor
+}
+
+func (d lyingDecryptor) ProveDecryption(c *elgamal.Ciphertext) (*elgamal.DecryptionProof, error) {
+	proof, err := d.LocalDecryptor.ProveDecryption(c)
+	if err != nil {
+		return nil, err
+	}
+	proof.S = new(big.Int).Add(proof.S, big.NewInt(1))
+	return proof, nil
+}
+
+// This function starts serving d on the socket at path and returns a client
+// connected to it
+func serve(t *testing.T, d elgamal.Decryptor, path string) *Client {
+	t.Helper()
+	errs := make(chan error, 1)
+	go func() { errs <- NewServer(d).ListenAndServe(path) }()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		client, err := Dial(path)
+		if err == nil {
+			t.Cleanup(func() { client.Close() })
+			return client
+		}
+		select {
+		case err := <-errs:
+			t.Fatal(err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newKey(t *testing.T) (*elgamal.PublicKey, *elgamal.SecretKey) {
+	t.Helper()
+	group, err := elgamal.GroupByName("P-256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, sk, err := elgamal.KeyGenInGroup(group, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pk, sk
+}
+
+func TestClientServerRoundTrip(t *testing.T) {
+
+	pk, sk := newKey(t)
+	path := filepath.Join(t.TempDir(), "keyd.sock")
+	client := serve(t, elgamal.NewLocalDecryptor(sk), path)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0600 {
+		t.Fatalf("socket has mode %v", fi.Mode())
+	}
+	if client.PublicKey().Fingerprint() != pk.Fingerprint() {
+		t.Fatal("client got another public key")
+	}
+
+	for _, m := range []int64{0, 1} {
+		isZero, err := client.DecryptAndCheck0(pk.Encrypt(big.NewInt(m)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isZero != (m == 0) {
+			t.Fatalf("DecryptAndCheck0(Enc(%d)) = %v", m, isZero)
+		}
+	}
+
+	msg := []byte("Simba")
+	c := pk.EncryptMul(msg)
+	pt, err := client.Decrypt(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pt, sk.Decrypt(c)) {
+		t.Fatal("Decrypt differs from the local key")
+	}
+
+	kem, key := pk.EncapsulateKey()
+	got, err := client.DecapsulateKey(kem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Fatal("DecapsulateKey differs from the encapsulated key")
+	}
+
+	proof, err := client.ProveDecryption(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pk.VerifyDecryption(c, proof) {
+		t.Fatal("client returned a proof that does not verify")
+	}
+}
+
+// The client must not hand out a decryption share whose proof fails
+func TestClientRejectsInvalidProof(t *testing.T) {
+
+	pk, sk := newKey(t)
+	path := filepath.Join(t.TempDir(), "keyd.sock")
+	client := serve(t, lyingDecryptor{elgamal.NewLocalDecryptor(sk)}, path)
+
+	if _, err := client.ProveDecryption(pk.Encrypt(big.NewInt(1))); err == nil {
+		t.Fatal("client accepted an invalid decryption proof")
+	}
+}
+
+// A second daemon must neither take over nor remove the socket of a running
+// one, but it replaces a socket that nobody listens on
+func TestListenAndServeKeepsLiveSocket(t *testing.T) {
+
+	pk, sk := newKey(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keyd.sock")
+	client := serve(t, elgamal.NewLocalDecryptor(sk), path)
+
+	if err := NewServer(elgamal.NewLocalDecryptor(sk)).ListenAndServe(path); err == nil {
+		t.Fatal("second daemon listened on a live socket")
+	}
+	if _, err := client.DecryptAndCheck0(pk.Encrypt(big.NewInt(0))); err != nil {
+		t.Fatal(err)
+	}
+	if second, err := Dial(path); err != nil {
+		t.Fatalf("live socket gone: %v", err)
+	} else {
+		second.Close()
+	}
+
+	notSocket := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(notSocket, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewServer(elgamal.NewLocalDecryptor(sk)).ListenAndServe(notSocket); err == nil {
+		t.Fatal("daemon replaced a regular file")
+	}
+
+	stale := filepath.Join(dir, "stale.sock")
+	l, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	serve(t, elgamal.NewLocalDecryptor(sk), stale)
+}
@@ -0,0 +1,146 @@
+package pcr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	bloom "bhwmonitoring-go/bloom"
+	elgamal "bhwmonitoring-go/elgamal"
+)
+
+// BatchResponseMessage answers several passwords submitted for the same
+// account with one response each, in an order that does not depend on the
+// order of submission
+type BatchResponseMessage struct {
+	Responses []*ResponseMessage
+}
+
+// This function generates one response per submitted password against a
+// single deployed query and shuffles them. The responses share the decoded C1
+// and every EBF entry decoded for an earlier candidate, so a position set by
+// several candidates is decoded once. The target learns which candidates
+// matched, or in MembershipOnly mode how many, but not in which order they
+// were tried.
+func ResponseGenBatch(sk *elgamal.SecretKey, queryMessagePlus *QueryMessagePlus, submittedPWDs []string) *BatchResponseMessage {
+	batchResponseMessage, err := ResponseGenBatchContext(context.Background(), sk, queryMessagePlus, submittedPWDs)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	return batchResponseMessage
+}
+
+// This function works as ResponseGenBatch with the error handling of
+// ResponseGenContext. The query must be deployed, as RespDeployment and
+// ApplyQueryDelta leave it; a query loaded from storage gives
+// ErrQueryNotDeployed until Deploy is called on it.
+func ResponseGenBatchContext(ctx context.Context, sk *elgamal.SecretKey, queryMessagePlus *QueryMessagePlus, submittedPWDs []string) (*BatchResponseMessage, error) {
+
+	if err := queryMessagePlus.CheckLive(); err != nil {
+		return nil, err
+	}
+	if queryMessagePlus.deployment() == nil {
+		return nil, ErrQueryNotDeployed
+	}
+
+	// one response after the other, so that a deterministic source (see
+	// SetRandomSource) gives the same batch for any executor; each response
+	// still decodes its entries on the executor
+	responses := make([]*ResponseMessage, len(submittedPWDs))
+	for i, pwd := range submittedPWDs {
+		responseMessage, err := ResponseGenContext(ctx, sk, queryMessagePlus, pwd)
+		if err != nil {
+			return nil, err
+		}
+		responses[i] = responseMessage
+	}
+
+	shuffleResponses(queryMessagePlus.PK, responses)
+	return &BatchResponseMessage{Responses: responses}, nil
+}
+
+// This function applies a uniformly random permutation (Fisher-Yates) drawn
+// from the key's randomness source
+func shuffleResponses(pk *elgamal.PublicKey, responses []*ResponseMessage) {
+	source := pk.RandomSource()
+	for i := len(responses) - 1; i > 0; i-- {
+		j := randomIntn(source, i+1)
+		responses[i], responses[j] = responses[j], responses[i]
+	}
+}
+
+// This function decrypts every response of a batch, see ResponseDecrypt. The
+// results are in the order of the batch, which is not the order in which the
+// candidates were submitted.
+func ResponseDecryptBatch(pk *elgamal.PublicKey, sk *elgamal.SecretKey, reqPara *ReqPara, batchResponseMessage *BatchResponseMessage, bf *bloom.BloomFilter) []*Result {
+	// the local decryptor never fails
+	results, _ := ResponseDecryptBatchWith(elgamal.NewLocalDecryptor(sk), reqPara, batchResponseMessage, bf)
+	return results
+}
+
+// This function works as ResponseDecryptBatch but leaves every secret-key
+// operation to a Decryptor, see ResponseDecryptWith
+func ResponseDecryptBatchWith(d elgamal.Decryptor, reqPara *ReqPara, batchResponseMessage *BatchResponseMessage, bf *bloom.BloomFilter) ([]*Result, error) {
+	return ResponseDecryptBatchWithContext(context.Background(), d, reqPara, batchResponseMessage, bf)
+}
+
+// This function works as ResponseDecryptBatchWith, but checks ctx before each
+// call to the decryptor and returns ctx.Err() once it is done
+func ResponseDecryptBatchWithContext(ctx context.Context, d elgamal.Decryptor, reqPara *ReqPara, batchResponseMessage *BatchResponseMessage, bf *bloom.BloomFilter) ([]*Result, error) {
+
+	results := make([]*Result, len(batchResponseMessage.Responses))
+	for i, responseMessage := range batchResponseMessage.Responses {
+		result, err := ResponseDecryptWithContext(ctx, d, reqPara, responseMessage, bf)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+// This function encodes a batch of responses to bytes.
+func EncodeBatchResponse(batchResponseMessage *BatchResponseMessage) []byte {
+
+	batchJson, _ := json.Marshal(*batchResponseMessage)
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	_, err := w.Write(batchJson)
+	err = w.Close()
+	if err != nil {
+		panic(err)
+	}
+	return b.Bytes()
+}
+
+// This function decodes a batch of responses in bytes to struct. Like
+// DecodeResponse it never panics on malformed input.
+func DecodeBatchResponse(batchResponseMessageBytes []byte) (*BatchResponseMessage, error) {
+
+	var batchResponseMessage BatchResponseMessage
+
+	batchJson, err := gunzipMessage(batchResponseMessageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(batchJson, &batchResponseMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, responseMessage := range batchResponseMessage.Responses {
+		if responseMessage == nil {
+			return nil, errors.New("batch with missing response")
+		}
+		if err := checkResponse(responseMessage); err != nil {
+			return nil, err
+		}
+	}
+	return &batchResponseMessage, nil
+}
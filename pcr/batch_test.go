@@ -0,0 +1,91 @@
+package pcr
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	elgamal "bhwmonitoring-go/elgamal"
+)
+
+// A batch must not deploy the caller's query behind its back, since that
+// would race with other responses to it
+func TestResponseGenBatchNeedsDeployedQuery(t *testing.T) {
+
+	fx := decodeFixture(t)
+	deployed := fx.queryMessagePlus
+	stored := &QueryMessagePlus{BfLength: deployed.BfLength, BfNumOnes: deployed.BfNumOnes, NumHashFuncs: deployed.NumHashFuncs, NumThreads: deployed.NumThreads, PointCompression: deployed.PointCompression, PK: deployed.PK, EBF: deployed.EBF, C1: deployed.C1, ResponseMode: deployed.ResponseMode, Challenge: deployed.Challenge}
+
+	if _, err := ResponseGenBatchContext(context.Background(), nil, stored, []string{"Simba"}); !errors.Is(err, ErrQueryNotDeployed) {
+		t.Fatalf("got %v, want ErrQueryNotDeployed", err)
+	}
+	if stored.deployment() != nil {
+		t.Fatal("the batch deployed the query")
+	}
+
+	if err := stored.Deploy(false); err != nil {
+		t.Fatal(err)
+	}
+	batchResponseMessage, err := ResponseGenBatchContext(context.Background(), nil, stored, []string{"Simba"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results := ResponseDecryptBatch(fx.pk, fx.sk, fx.reqPara, batchResponseMessage, fx.bf); !results[0].Positive() {
+		t.Fatalf("response for Simba was %v", results[0].Outcome)
+	}
+}
+
+// A batch with exactly one matching password decrypts to exactly one match,
+// revealing that password, wherever the shuffle put it
+func TestResponseGenBatchOneMatch(t *testing.T) {
+
+	fx := decodeFixture(t)
+	var pwds []string
+	for _, pwd := range []string{"Nala", "Mufasa", "Scar", "Rafiki", "Timon", "Pumbaa"} {
+		if !fx.bf.Test(elgamal.HashSha256([]byte(pwd))) {
+			pwds = append(pwds, pwd)
+		}
+	}
+	if len(pwds) < 3 {
+		t.Fatal("too few candidate passwords outside the filter")
+	}
+	pwds = append(pwds[:2], append([]string{"Simba"}, pwds[2:]...)...)
+
+	matchedAt := make(map[int]bool)
+	for round := 0; round < 12; round++ {
+		batchResponseMessage, err := ResponseGenBatchContext(context.Background(), nil, fx.queryMessagePlus, pwds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rcvBatch, err := DecodeBatchResponse(EncodeBatchResponse(batchResponseMessage))
+		if err != nil {
+			t.Fatal(err)
+		}
+		results := ResponseDecryptBatch(fx.pk, fx.sk, fx.reqPara, rcvBatch, fx.bf)
+		if len(results) != len(pwds) {
+			t.Fatalf("%d results for %d passwords", len(results), len(pwds))
+		}
+		matches := 0
+		for i, result := range results {
+			switch result.Outcome {
+			case Positive:
+				matches++
+				matchedAt[i] = true
+				if string(result.Revealed) != "Simba" {
+					t.Fatalf("match revealed %q", result.Revealed)
+				}
+			case Negative:
+			default:
+				t.Fatalf("response %d was %v: %s", i, result.Outcome, result.Details)
+			}
+		}
+		if matches != 1 {
+			t.Fatalf("batch with one matching password decrypted to %d matches", matches)
+		}
+	}
+	// with n responses, twelve shuffles all leaving the match in one place
+	// happen with probability n^-11
+	if len(matchedAt) < 2 {
+		t.Fatal("the match was at the same place in every batch")
+	}
+}
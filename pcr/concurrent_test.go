@@ -0,0 +1,172 @@
+package pcr
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	elgamal "bhwmonitoring-go/elgamal"
+	workpool "bhwmonitoring-go/workpool"
+)
+
+// Far more workers than positions per response, so that the partial results
+// of one response are computed on different goroutines. Run with -race.
+const (
+	concurrentWorkers      = 64
+	concurrentResponders   = 4
+	concurrentChecks       = 4
+	concurrentBFLength     = 64
+	concurrentBFNumOnes    = 16
+	concurrentNumHashFuncs = 8
+)
+
+var concurrentOthers = []string{"Nala", "Mufasa", "Rafiki", "Sarabi"}
+
+// Responses generated at once against one deployed query must come out
+// positive for the query password and negative for any other, and a seeded
+// response must not depend on the number of workers. The partial sums were
+// once added to c2 by a goroutine the response did not wait for, which left
+// the positions of the password out of the response.
+func TestResponseGenConcurrentNeverMissesMatch(t *testing.T) {
+
+	pool := workpool.New(concurrentWorkers)
+	defer pool.Close()
+
+	for _, name := range elgamal.GroupNames() {
+		t.Run(name, func(t *testing.T) {
+
+			ctx := workpool.NewContext(context.Background(), pool)
+			pk, sk, reqPara := ReqInitGroup(name, concurrentBFLength, concurrentBFNumOnes, concurrentNumHashFuncs, concurrentWorkers, true)
+			bf := ReqBFGen(pk, reqPara, "Simba")
+			queryMessage, err := QueryGenContext(ctx, pk, reqPara, bf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rcvQuery, err := DecodeQuery(EncodeQuery(queryMessage))
+			if err != nil {
+				t.Fatal(err)
+			}
+			queryMessagePlus, err := RespDeploymentContext(ctx, rcvQuery)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, concurrentResponders*concurrentChecks)
+			for g := 0; g < concurrentResponders; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < concurrentChecks; i++ {
+						pwd := "Simba"
+						if (g+i)%2 == 1 {
+							pwd = concurrentOthers[(g+i)%len(concurrentOthers)]
+						}
+						responseMessage, err := ResponseGenContext(ctx, nil, queryMessagePlus, pwd)
+						if err != nil {
+							errs <- err
+							continue
+						}
+						result := ResponseDecrypt(pk, sk, reqPara, responseMessage, bf)
+						if pwd == "Simba" && !result.Positive() {
+							t.Errorf("response for Simba was %v", result.Outcome)
+						}
+						if pwd != "Simba" && result.Outcome != Negative {
+							t.Errorf("response for %s was %v", pwd, result.Outcome)
+						}
+					}
+				}(g)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Fatal(err)
+			}
+
+			var responses [][]byte
+			for _, workers := range []int{1, concurrentWorkers} {
+				responses = append(responses, seededResponse(t, name, workers))
+			}
+			if !bytes.Equal(responses[0], responses[1]) {
+				t.Fatalf("seeded response differs between 1 and %d workers", concurrentWorkers)
+			}
+		})
+	}
+}
+
+// This function returns the encoding of a response to a seeded query,
+// computed on a pool of the given number of workers
+func seededResponse(t *testing.T, groupName string, workers int) []byte {
+	t.Helper()
+
+	group, err := elgamal.GroupByName(groupName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := []byte("bhwmonitoring-go/concurrent/" + groupName)
+	pk, _, err := elgamal.KeyGenFromSeed(group, seed, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := workpool.New(workers)
+	defer pool.Close()
+	ctx := workpool.NewContext(context.Background(), pool)
+	pk.SetRandomSource(elgamal.NewSeededReader(seed))
+
+	reqPara := &ReqPara{Group: group.Name(), Params: pk.SecParam, BfLength: concurrentBFLength, BfNumOnes: concurrentBFNumOnes, NumHashFuncs: concurrentNumHashFuncs, NumThreads: concurrentWorkers, PointCompression: true}
+	bf := ReqBFGen(pk, reqPara, "Simba")
+	queryMessage, err := QueryGenContext(ctx, pk, reqPara, bf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryMessagePlus, err := RespDeploymentContext(ctx, queryMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responseMessage, err := ResponseGenContext(ctx, nil, queryMessagePlus, "Simba")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return EncodeResponse(responseMessage)
+}
+
+// Deploy may switch tables on while a precomputation pool is filled and
+// responses are generated
+func TestDeployWhilePrecomputing(t *testing.T) {
+
+	pk, sk, reqPara := ReqInitGroup("P-256", concurrentBFLength, concurrentBFNumOnes, concurrentNumHashFuncs, 1, true)
+	bf := ReqBFGen(pk, reqPara, "Simba")
+	rcvQuery, err := DecodeQuery(EncodeQuery(QueryGen(pk, reqPara, bf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryMessagePlus, err := RespDeploymentContext(context.Background(), rcvQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	precompute := queryMessagePlus.Precompute(PrecomputeConfig{Depth: 2})
+	defer precompute.Stop()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < concurrentChecks; i++ {
+			if err := queryMessagePlus.Deploy(i%2 == 0); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for i := 0; i < concurrentChecks; i++ {
+		pwd := []string{"Simba", "Nala"}[i%2]
+		responseMessage, err := ResponseGenContext(context.Background(), nil, queryMessagePlus, pwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := ResponseDecrypt(pk, sk, reqPara, responseMessage, bf); result.Positive() != (pwd == "Simba") {
+			t.Fatalf("response for %s was %v", pwd, result.Outcome)
+		}
+	}
+	wg.Wait()
+}
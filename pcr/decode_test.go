@@ -0,0 +1,186 @@
+package pcr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"sync"
+	"testing"
+
+	bloom "bhwmonitoring-go/bloom"
+	elgamal "bhwmonitoring-go/elgamal"
+)
+
+// The fuzz targets decode the JSON inside the gzip layer, which is what the
+// fuzzer mutates, and the raw input as a compressed message, then run the step
+// that consumes the decoded message. The seed corpus under testdata/fuzz holds
+// the messages of decodeFixture and edge cases derived from them, and runs
+// with every go test. To search for new crashing inputs, run e.g.
+//
+//	go test -run XXX -fuzz FuzzDecodeQuery -fuzzminimizetime 100x ./pcr
+//
+// Minimizing a query-sized input with the default time limit stalls the
+// fuzzer for a minute per new input.
+
+type fixture struct {
+	pk               *elgamal.PublicKey
+	sk               *elgamal.SecretKey
+	reqPara          *ReqPara
+	bf               *bloom.BloomFilter
+	queryMessagePlus *QueryMessagePlus
+	query            []byte // the JSON of each message, without the gzip layer
+	response         []byte
+	batch            []byte
+	delta            []byte
+}
+
+var (
+	fixtureOnce sync.Once
+	fixtureData *fixture
+)
+
+// This function returns a small P-256 query for "Simba" under a seeded key,
+// deployed, with a response, a batch and a delta to "Nala". The key is the
+// same on every run, so that responses in the corpus decrypt under it.
+func decodeFixture(tb testing.TB) *fixture {
+	fixtureOnce.Do(func() {
+		group, err := elgamal.GroupByName("P-256")
+		if err != nil {
+			tb.Fatal(err)
+		}
+		seed := []byte("bhwmonitoring-go/decode-fuzz/v1")
+		pk, sk, err := elgamal.KeyGenFromSeed(group, seed, true)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		pk.SetRandomSource(elgamal.NewSeededReader(seed))
+		// ReqBFGen places its noise bits correctly only in whole 64-bit words
+		reqPara := &ReqPara{Group: group.Name(), Params: pk.SecParam, BfLength: 64, BfNumOnes: 12, NumHashFuncs: 4, NumThreads: 1, PointCompression: true}
+		bf := ReqBFGen(pk, reqPara, "Simba")
+
+		queryMessage, err := QueryGenContext(context.Background(), pk, reqPara, bf)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		rcvQuery, err := DecodeQuery(EncodeQuery(queryMessage))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		queryMessagePlus, err := RespDeploymentContext(context.Background(), rcvQuery)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		responseMessage, err := ResponseGenContext(context.Background(), sk, queryMessagePlus, "Simba")
+		if err != nil {
+			tb.Fatal(err)
+		}
+		batchResponseMessage, err := ResponseGenBatchContext(context.Background(), sk, queryMessagePlus, []string{"Nala", "Simba"})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		queryDelta, _, err := QueryDeltaGen(sk, reqPara, rcvQuery, bf, ReqBFGen(pk, reqPara, "Nala"), false)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := ApplyQueryDelta(queryMessagePlus, queryDelta); err != nil {
+			tb.Fatal(err)
+		}
+
+		fixtureData = &fixture{
+			pk: pk, sk: sk, reqPara: reqPara, bf: bf, queryMessagePlus: queryMessagePlus,
+			query:    gunzipTest(tb, EncodeQuery(queryMessage)),
+			response: gunzipTest(tb, EncodeResponse(responseMessage)),
+			batch:    gunzipTest(tb, EncodeBatchResponse(batchResponseMessage)),
+			delta:    gunzipTest(tb, EncodeQueryDelta(queryDelta)),
+		}
+	})
+	if fixtureData == nil {
+		tb.Fatal("no fixture")
+	}
+	return fixtureData
+}
+
+func gunzipTest(tb testing.TB, msg []byte) []byte {
+	plain, err := gunzipMessage(msg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return plain
+}
+
+func gzipTest(plain []byte) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write(plain)
+	w.Close()
+	return b.Bytes()
+}
+
+// This function runs decode on the input both as the JSON of a message and
+// as a compressed message
+func fuzzMessage(data []byte, decode func(msg []byte)) {
+	decode(gzipTest(data))
+	decode(data)
+}
+
+func FuzzDecodeQuery(f *testing.F) {
+	fx := decodeFixture(f)
+	f.Add(fx.query)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzMessage(data, func(msg []byte) {
+			queryMessage, err := DecodeQuery(msg)
+			if err != nil {
+				return
+			}
+			// the decoders RespDeployment runs on every entry; the proofs are
+			// verified in FuzzDecodeQueryDelta
+			for i := range queryMessage.EBF {
+				queryMessage.PK.Bytes2Ciphertext(queryMessage.EBF[i], queryMessage.PointCompression)
+				queryMessage.PK.Bytes2ZKP(queryMessage.ZKPs[i], queryMessage.PointCompression)
+			}
+		})
+	})
+}
+
+func FuzzDecodeResponse(f *testing.F) {
+	fx := decodeFixture(f)
+	f.Add(fx.response)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzMessage(data, func(msg []byte) {
+			responseMessage, err := DecodeResponse(msg)
+			if err != nil {
+				return
+			}
+			ResponseDecrypt(fx.pk, fx.sk, fx.reqPara, responseMessage, fx.bf)
+		})
+	})
+}
+
+func FuzzDecodeBatchResponse(f *testing.F) {
+	fx := decodeFixture(f)
+	f.Add(fx.batch)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzMessage(data, func(msg []byte) {
+			batchResponseMessage, err := DecodeBatchResponse(msg)
+			if err != nil {
+				return
+			}
+			ResponseDecryptBatch(fx.pk, fx.sk, fx.reqPara, batchResponseMessage, fx.bf)
+		})
+	})
+}
+
+func FuzzDecodeQueryDelta(f *testing.F) {
+	fx := decodeFixture(f)
+	f.Add(fx.delta)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzMessage(data, func(msg []byte) {
+			queryDelta, err := DecodeQueryDelta(msg)
+			if err != nil {
+				return
+			}
+			// returns a new query; the deployed one is left as it is
+			ApplyQueryDelta(fx.queryMessagePlus, queryDelta)
+		})
+	})
+}
@@ -0,0 +1,320 @@
+package pcr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	bloom "bhwmonitoring-go/bloom"
+	elgamal "bhwmonitoring-go/elgamal"
+	workpool "bhwmonitoring-go/workpool"
+)
+
+// QueryDelta moves a deployed query to a new Bloom filter of the target, e.g.
+// after a password change, without sending BfLength ciphertexts and OR-proofs
+// again. It carries fresh encryptions, with OR-proofs, of the positions that
+// changed, or of every position if the target does not want the monitor to
+// learn which ones changed. NumOnesProof proves that the sum of the new EBF
+// decrypts to 2·BfNumOnes - BfLength, i.e. that the new filter still has
+// BfNumOnes ones.
+type QueryDelta struct {
+	Base         []byte // Challenge of the query, or of the last delta, this delta applies to
+	BfNumOnes    int
+	Positions    []int `json:",omitempty"` // ascending; nil when every position is re-encrypted
+	EBF          []*elgamal.CiphertextByte
+	ZKPs         []*elgamal.ZKPByte
+	Challenge    []byte
+	NumOnesProof *elgamal.DecryptionProofByte
+}
+
+// This function computes the delta from the target's old to its new Bloom
+// filter for the query the monitor holds, which is queryMessage with every
+// earlier delta applied. It returns the delta and that query after the delta,
+// to pass in for the next one. The returned query mixes proofs of several
+// challenges and is not meant to be sent. With rerandomizeAll every position
+// is re-encrypted, which costs as much as a new query but hides which
+// positions changed.
+func QueryDeltaGen(sk *elgamal.SecretKey, reqPara *ReqPara, queryMessage *QueryMessage, oldBF, newBF *bloom.BloomFilter, rerandomizeAll bool) (*QueryDelta, *QueryMessage, error) {
+	return QueryDeltaGenWithContext(context.Background(), elgamal.NewLocalDecryptor(sk), reqPara, queryMessage, oldBF, newBF, rerandomizeAll)
+}
+
+// This function works as QueryDeltaGen, but leaves the proof of the number of
+// ones to a Decryptor, and stops once ctx is done and returns ctx.Err()
+func QueryDeltaGenWithContext(ctx context.Context, d elgamal.Decryptor, reqPara *ReqPara, queryMessage *QueryMessage, oldBF, newBF *bloom.BloomFilter, rerandomizeAll bool) (*QueryDelta, *QueryMessage, error) {
+
+	pk := d.PublicKey()
+	if len(queryMessage.EBF) != reqPara.BfLength || oldBF.Cap() != uint(reqPara.BfLength) || newBF.Cap() != uint(reqPara.BfLength) {
+		return nil, nil, errors.New("query length does not match the Bloom filter length")
+	}
+	if GetBFNumOnes(newBF) != reqPara.BfNumOnes {
+		return nil, nil, errors.New("new Bloom filter does not have BfNumOnes ones")
+	}
+
+	var positions []int
+	for i := 0; i < reqPara.BfLength; i++ {
+		if rerandomizeAll || oldBF.BitSet().Test(uint(i)) != newBF.BitSet().Test(uint(i)) {
+			positions = append(positions, i)
+		}
+	}
+	if len(positions) == 0 {
+		return nil, nil, errors.New("Bloom filters do not differ")
+	}
+
+	bf2encrypt := make([]*big.Int, len(positions))
+	for j, i := range positions {
+		if newBF.BitSet().Test(uint(i)) {
+			bf2encrypt[j] = big.NewInt(1)
+		} else {
+			bf2encrypt[j] = big.NewInt(-1)
+		}
+	}
+	deltaPositions := positions
+	if rerandomizeAll {
+		deltaPositions = nil
+	}
+	binding := deltaBinding(queryMessage.Challenge, reqPara.BfNumOnes, deltaPositions, queryMessage.QueryID, queryMessage.IssuedAt, queryMessage.ExpiresAt)
+	ebf, zkps, challenge, err := pk.EncryptSeqWithZKPBoundContext(ctx, bf2encrypt, reqPara.NumThreads, binding)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	updated := *queryMessage
+	updated.BfNumOnes = reqPara.BfNumOnes
+	updated.EBF = append([]*elgamal.CiphertextByte{}, queryMessage.EBF...)
+	updated.ZKPs = append([]*elgamal.ZKPByte{}, queryMessage.ZKPs...)
+	updated.Challenge = challenge.Bytes()
+	delta := &QueryDelta{
+		Base:      queryMessage.Challenge,
+		BfNumOnes: reqPara.BfNumOnes,
+		EBF:       make([]*elgamal.CiphertextByte, len(positions)),
+		ZKPs:      make([]*elgamal.ZKPByte, len(positions)),
+		Positions: deltaPositions,
+		Challenge: challenge.Bytes(),
+	}
+	for j, i := range positions {
+		delta.EBF[j] = pk.Ciphertext2Bytes(ebf[j], reqPara.PointCompression)
+		delta.ZKPs[j] = pk.ZKP2Bytes(zkps[j], reqPara.PointCompression)
+		updated.EBF[i] = delta.EBF[j]
+		updated.ZKPs[i] = delta.ZKPs[j]
+	}
+
+	sum, err := sumEBF(ctx, pk, updated.EBF, reqPara.PointCompression)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	proof, err := d.ProveDecryption(sum)
+	if err != nil {
+		return nil, nil, err
+	}
+	delta.NumOnesProof = pk.DecryptionProof2Bytes(proof, reqPara.PointCompression)
+
+	return delta, &updated, nil
+}
+
+// This function verifies a delta against a deployed query and returns the
+// query with the delta applied, deployed and with a recomputed C1. The stored
+// query is left unchanged, so responses in flight keep using it; the monitor
+// replaces it with the returned one and stops its precomputation pool, whose
+// randomness is bound to the old C1.
+func ApplyQueryDelta(queryMessagePlus *QueryMessagePlus, delta *QueryDelta) (*QueryMessagePlus, error) {
+	return ApplyQueryDeltaContext(context.Background(), queryMessagePlus, delta)
+}
+
+// This function works as ApplyQueryDelta, but stops once ctx is done and
+// returns ctx.Err()
+func ApplyQueryDeltaContext(ctx context.Context, queryMessagePlus *QueryMessagePlus, delta *QueryDelta) (*QueryMessagePlus, error) {
+
+	if err := queryMessagePlus.CheckLive(); err != nil {
+		return nil, err
+	}
+	pk := queryMessagePlus.PK
+	if err := pk.InitCurve(); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(delta.Base, queryMessagePlus.Challenge) {
+		return nil, errors.New("delta does not apply to this query")
+	}
+	if err := checkQueryDelta(delta, queryMessagePlus.BfLength); err != nil {
+		return nil, err
+	}
+
+	ebf := make([]*elgamal.Ciphertext, len(delta.EBF))
+	zkps := make([]*elgamal.ZKP, len(delta.EBF))
+	decodeErrs := make([]error, len(delta.EBF))
+	err := workpool.FromContext(ctx).ForEach(ctx, len(delta.EBF), func(j int) {
+		var err error
+		if ebf[j], err = pk.Bytes2Ciphertext(delta.EBF[j], queryMessagePlus.PointCompression); err == nil {
+			zkps[j], err = pk.Bytes2ZKP(delta.ZKPs[j], queryMessagePlus.PointCompression)
+		}
+		decodeErrs[j] = err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range decodeErrs {
+		if err != nil {
+			return nil, errors.New("Invalid delta: " + err.Error())
+		}
+	}
+	challenge, err := pk.DecodeScalar(delta.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	// a proof that does not verify comes back as an *elgamal.ZKPError
+	binding := deltaBinding(delta.Base, delta.BfNumOnes, delta.Positions, queryMessagePlus.QueryID, queryMessagePlus.IssuedAt, queryMessagePlus.ExpiresAt)
+	if _, err := pk.VerifySeqZKPBoundContext(ctx, ebf, zkps, challenge, queryMessagePlus.NumThreads, binding); err != nil {
+		return nil, err
+	}
+
+	ebfBytes := append([]*elgamal.CiphertextByte{}, queryMessagePlus.EBF...)
+	for j := range delta.EBF {
+		i := j
+		if delta.Positions != nil {
+			i = delta.Positions[j]
+		}
+		ebfBytes[i] = delta.EBF[j]
+	}
+
+	sum, err := sumEBF(ctx, pk, ebfBytes, queryMessagePlus.PointCompression)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := pk.Bytes2DecryptionProof(delta.NumOnesProof, queryMessagePlus.PointCompression)
+	if err != nil {
+		return nil, err
+	}
+	if !pk.VerifyPlaintext(sum, big.NewInt(int64(2*delta.BfNumOnes-queryMessagePlus.BfLength)), proof) {
+		return nil, errors.New("Invalid proof of the number of ones!")
+	}
+
+	encInvSum := pk.Encrypt(big.NewInt(int64(queryMessagePlus.BfLength - 2*delta.BfNumOnes)))
+	c1 := pk.Ciphertext2Bytes(pk.Add(sum, encInvSum, false), queryMessagePlus.PointCompression)
+
+	updated := &QueryMessagePlus{BfLength: queryMessagePlus.BfLength, BfNumOnes: delta.BfNumOnes, NumHashFuncs: queryMessagePlus.NumHashFuncs, NumThreads: queryMessagePlus.NumThreads, PointCompression: queryMessagePlus.PointCompression, PK: pk, EBF: ebfBytes, C1: c1, ResponseMode: queryMessagePlus.ResponseMode, Challenge: delta.Challenge, QueryID: queryMessagePlus.QueryID, IssuedAt: queryMessagePlus.IssuedAt, ExpiresAt: queryMessagePlus.ExpiresAt}
+	dq := queryMessagePlus.deployment()
+	tables := dq != nil && dq.tables
+	if err := updated.Deploy(tables); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+const deltaLabel = "bhwmonitoring-go/query-delta/v1"
+
+// This function returns the label the proofs of a delta are bound with: the
+// query it applies to, the new number of ones, the positions it replaces (nil
+// for all of them) and the ID and validity of the query, so that none of them
+// can be changed on the way to the monitor
+func deltaBinding(base []byte, bfNumOnes int, positions []int, queryID []byte, issuedAt, expiresAt int64) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(deltaLabel)
+	for _, field := range [][]byte{base, queryID} {
+		binary.Write(buf, binary.BigEndian, uint32(len(field)))
+		buf.Write(field)
+	}
+	binary.Write(buf, binary.BigEndian, int64(bfNumOnes))
+	if positions == nil {
+		binary.Write(buf, binary.BigEndian, int64(-1))
+	} else {
+		binary.Write(buf, binary.BigEndian, int64(len(positions)))
+		for _, i := range positions {
+			binary.Write(buf, binary.BigEndian, int64(i))
+		}
+	}
+	binary.Write(buf, binary.BigEndian, issuedAt)
+	binary.Write(buf, binary.BigEndian, expiresAt)
+	return buf.Bytes()
+}
+
+// This function decodes a full EBF and returns the sum of its entries
+func sumEBF(ctx context.Context, pk *elgamal.PublicKey, ebfBytes []*elgamal.CiphertextByte, pointCompression bool) (*elgamal.Ciphertext, error) {
+
+	pool := workpool.FromContext(ctx)
+	ebf := make([]*elgamal.Ciphertext, len(ebfBytes))
+	decodeErrs := make([]error, len(ebfBytes))
+	err := pool.ForEach(ctx, len(ebfBytes), func(i int) {
+		ebf[i], decodeErrs[i] = pk.Bytes2Ciphertext(ebfBytes[i], pointCompression)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range decodeErrs {
+		if err != nil {
+			return nil, errors.New("Invalid query: " + err.Error())
+		}
+	}
+	return pk.Sum(ebf, pool.Workers()), nil
+}
+
+// This function checks the shape of a delta for a query of bfLength positions
+func checkQueryDelta(delta *QueryDelta, bfLength int) error {
+
+	if delta.BfNumOnes < 0 || delta.BfNumOnes > bfLength {
+		return errors.New("invalid number of ones")
+	}
+	if len(delta.EBF) == 0 || len(delta.ZKPs) != len(delta.EBF) {
+		return errors.New("delta with missing ciphertexts or proofs")
+	}
+	if delta.Positions == nil && len(delta.EBF) != bfLength {
+		return errors.New("delta length does not match the Bloom filter length")
+	}
+	if delta.Positions != nil {
+		if len(delta.Positions) != len(delta.EBF) {
+			return errors.New("delta length does not match its positions")
+		}
+		for j, i := range delta.Positions {
+			if i < 0 || i >= bfLength || (j > 0 && i <= delta.Positions[j-1]) {
+				return errors.New("invalid delta positions")
+			}
+		}
+	}
+	for j := range delta.EBF {
+		if delta.EBF[j] == nil || delta.ZKPs[j] == nil {
+			return errors.New("delta with missing ciphertext or proof")
+		}
+	}
+	if delta.NumOnesProof == nil {
+		return errors.New("delta without proof of the number of ones")
+	}
+	return nil
+}
+
+// This function encodes a query delta to bytes.
+func EncodeQueryDelta(delta *QueryDelta) []byte {
+
+	deltaJson, _ := json.Marshal(*delta)
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	_, err := w.Write(deltaJson)
+	err = w.Close()
+	if err != nil {
+		panic(err)
+	}
+	return b.Bytes()
+}
+
+// This function decodes a query delta in bytes to struct. Like DecodeQuery it
+// never panics on malformed input; ApplyQueryDelta checks the delta against
+// the query.
+func DecodeQueryDelta(deltaBytes []byte) (*QueryDelta, error) {
+
+	var delta QueryDelta
+
+	deltaJson, err := gunzipMessage(deltaBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(deltaJson, &delta)
+	if err != nil {
+		return nil, err
+	}
+	return &delta, nil
+}
@@ -0,0 +1,163 @@
+package pcr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	bloom "bhwmonitoring-go/bloom"
+	elgamal "bhwmonitoring-go/elgamal"
+)
+
+type deltaFixture struct {
+	pk           *elgamal.PublicKey
+	sk           *elgamal.SecretKey
+	reqPara      *ReqPara
+	bf, newBF    *bloom.BloomFilter
+	queryMessage *QueryMessage
+}
+
+// This function returns a seeded query for Simba with a validity, and the
+// target's Bloom filter for Nala
+func newDeltaFixture(t *testing.T) *deltaFixture {
+	t.Helper()
+	group, err := elgamal.GroupByName("P-256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := []byte("bhwmonitoring-go/delta/v1")
+	pk, sk, err := elgamal.KeyGenFromSeed(group, seed, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk.SetRandomSource(elgamal.NewSeededReader(seed))
+	reqPara := &ReqPara{Group: group.Name(), Params: pk.SecParam, BfLength: 64, BfNumOnes: 16, NumHashFuncs: 4, NumThreads: 1, PointCompression: true, Lifetime: time.Hour}
+	bf := ReqBFGen(pk, reqPara, "Simba")
+	queryMessage, err := QueryGenContext(context.Background(), pk, reqPara, bf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &deltaFixture{pk, sk, reqPara, bf, ReqBFGen(pk, reqPara, "Nala"), queryMessage}
+}
+
+// This function deploys the query as the monitor receives it
+func (fx *deltaFixture) deploy(t *testing.T) *QueryMessagePlus {
+	t.Helper()
+	rcvQuery, err := DecodeQuery(EncodeQuery(fx.queryMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryMessagePlus, err := RespDeploymentContext(context.Background(), rcvQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queryMessagePlus
+}
+
+// After the delta the monitor answers for the new password only
+func TestQueryDeltaRoundTrip(t *testing.T) {
+
+	fx := newDeltaFixture(t)
+	for _, rerandomizeAll := range []bool{false, true} {
+		delta, _, err := QueryDeltaGen(fx.sk, fx.reqPara, fx.queryMessage, fx.bf, fx.newBF, rerandomizeAll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rcvDelta, err := DecodeQueryDelta(EncodeQueryDelta(delta))
+		if err != nil {
+			t.Fatal(err)
+		}
+		queryMessagePlus := fx.deploy(t)
+		updated, err := ApplyQueryDelta(queryMessagePlus, rcvDelta)
+		if err != nil {
+			t.Fatalf("rerandomizeAll %v: %v", rerandomizeAll, err)
+		}
+		if updated.ExpiresAt != queryMessagePlus.ExpiresAt || string(updated.QueryID) != string(queryMessagePlus.QueryID) {
+			t.Fatal("delta lost the ID or validity of the query")
+		}
+
+		for _, c := range []struct {
+			pwd      string
+			positive bool
+		}{{"Nala", true}, {"Simba", false}} {
+			responseMessage, err := ResponseGenContext(context.Background(), nil, updated, c.pwd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := ResponseDecrypt(fx.pk, fx.sk, fx.reqPara, responseMessage, fx.newBF)
+			if result.Positive() != c.positive {
+				t.Errorf("rerandomizeAll %v: response for %s was %v", rerandomizeAll, c.pwd, result.Outcome)
+			}
+		}
+	}
+}
+
+// A delta only applies to the query, the number of ones and the positions
+// its proofs were generated for
+func TestQueryDeltaRejects(t *testing.T) {
+
+	fx := newDeltaFixture(t)
+	delta, _, err := QueryDeltaGen(fx.sk, fx.reqPara, fx.queryMessage, fx.bf, fx.newBF, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryMessagePlus := fx.deploy(t)
+
+	received := func(tamper func(d *QueryDelta)) *QueryDelta {
+		t.Helper()
+		d, err := DecodeQueryDelta(EncodeQueryDelta(delta))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tamper(d)
+		return d
+	}
+	// a position the delta does not replace, kept in ascending order
+	movePosition := func(d *QueryDelta) {
+		for j := len(d.Positions) - 1; j >= 0; j-- {
+			next := fx.reqPara.BfLength
+			if j+1 < len(d.Positions) {
+				next = d.Positions[j+1]
+			}
+			if d.Positions[j]+1 < next {
+				d.Positions[j]++
+				return
+			}
+		}
+		t.Fatal("delta replaces every position")
+	}
+
+	for _, c := range []struct {
+		name   string
+		tamper func(d *QueryDelta)
+		want   error
+	}{
+		{"other base", func(d *QueryDelta) { d.Base[0] ^= 1 }, nil},
+		{"no base", func(d *QueryDelta) { d.Base = nil }, nil},
+		{"more ones", func(d *QueryDelta) { d.BfNumOnes++ }, elgamal.ErrInvalidZKP},
+		{"fewer ones", func(d *QueryDelta) { d.BfNumOnes-- }, elgamal.ErrInvalidZKP},
+		{"other position", movePosition, elgamal.ErrInvalidZKP},
+		{"every position", func(d *QueryDelta) { d.Positions = nil }, nil},
+		{"no proof of ones", func(d *QueryDelta) { d.NumOnesProof = nil }, nil},
+	} {
+		_, err := ApplyQueryDelta(queryMessagePlus, received(c.tamper))
+		if err == nil || (c.want != nil && !errors.Is(err, c.want)) {
+			t.Errorf("%s: got %v", c.name, err)
+		}
+	}
+
+	// a delta for one query does not apply to another of the same key
+	other := *fx.queryMessage
+	fx.queryMessage, err = QueryGenContext(context.Background(), fx.pk, fx.reqPara, fx.bf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ApplyQueryDelta(fx.deploy(t), received(func(d *QueryDelta) {})); err == nil {
+		t.Error("delta applied to another query")
+	}
+	fx.queryMessage = &other
+	if _, err := ApplyQueryDelta(fx.deploy(t), received(func(d *QueryDelta) {})); err != nil {
+		t.Fatal(err)
+	}
+}
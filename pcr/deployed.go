@@ -0,0 +1,120 @@
+package pcr
+
+import (
+	"errors"
+	"sync"
+
+	elgamal "bhwmonitoring-go/elgamal"
+)
+
+// ErrQueryNotDeployed is returned by ResponseGenBatchContext for a query that
+// has not been through RespDeployment or Deploy
+var ErrQueryNotDeployed = errors.New("query not deployed")
+
+// deployedQuery is the decoded form of a QueryMessagePlus, kept next to the
+// byte form that is stored and shared by all responses to the query. An EBF
+// entry is decoded, and prepared for table-driven linear combinations if
+// enabled, the first time a response touches it. Only the entries of checked
+// passwords are ever held, and a repeated check for the same account decodes
+// nothing.
+type deployedQuery struct {
+	c1      *elgamal.Ciphertext
+	tables  bool
+	entries []deployedEntry
+}
+
+type deployedEntry struct {
+	once     sync.Once
+	prepared *elgamal.PreparedCiphertext
+	err      error
+}
+
+// This function decodes C1 and makes responses to the query cache the EBF
+// entries they decode. With tables set, cached entries also keep the tables
+// of elgamal.PreparedCiphertext, a few KB per entry, which speed up the
+// linear combination of every later response on the curves where tables pay
+// off. RespDeployment calls it without tables; call it on a query
+// loaded from storage, or again to turn tables on. It may be called while
+// responses are generated and a precomputation pool is filled; they see
+// either the earlier or the new deployment.
+func (queryMessagePlus *QueryMessagePlus) Deploy(tables bool) error {
+
+	pk := queryMessagePlus.PK
+	if err := pk.InitCurve(); err != nil {
+		return err
+	}
+	if len(queryMessagePlus.EBF) != queryMessagePlus.BfLength {
+		return errors.New("query length does not match the Bloom filter length")
+	}
+	c1, err := pk.Bytes2Ciphertext(queryMessagePlus.C1, queryMessagePlus.PointCompression)
+	if err != nil {
+		return err
+	}
+
+	dq := &deployedQuery{c1: c1, tables: tables, entries: make([]deployedEntry, len(queryMessagePlus.EBF))}
+	queryMessagePlus.deployMu.Lock()
+	queryMessagePlus.deployed = dq
+	queryMessagePlus.deployMu.Unlock()
+	return nil
+}
+
+// This function returns the current deployment of the query, or nil if it has
+// not been deployed
+func (queryMessagePlus *QueryMessagePlus) deployment() *deployedQuery {
+	queryMessagePlus.deployMu.RLock()
+	defer queryMessagePlus.deployMu.RUnlock()
+	return queryMessagePlus.deployed
+}
+
+// This function returns the EBF entry at position i of the deployment dq,
+// decoding and caching it on first use
+func (queryMessagePlus *QueryMessagePlus) deployedEntry(dq *deployedQuery, i int) (*elgamal.PreparedCiphertext, error) {
+
+	pk := queryMessagePlus.PK
+	entry := &dq.entries[i]
+	entry.once.Do(func() {
+		c, err := pk.Bytes2Ciphertext(queryMessagePlus.EBF[i], queryMessagePlus.PointCompression)
+		if err != nil {
+			entry.err = err
+			return
+		}
+		entry.prepared = dq.prepare(pk, c)
+	})
+	return entry.prepared, entry.err
+}
+
+// This function wraps a ciphertext for LinearCombinationPrepared, with tables
+// if the deployed query uses them
+func (queryMessagePlus *QueryMessagePlus) prepare(c *elgamal.Ciphertext) *elgamal.PreparedCiphertext {
+	return queryMessagePlus.deployment().prepare(queryMessagePlus.PK, c)
+}
+
+// This function wraps a ciphertext for LinearCombinationPrepared, with tables
+// if dq uses them; dq may be nil
+func (dq *deployedQuery) prepare(pk *elgamal.PublicKey, c *elgamal.Ciphertext) *elgamal.PreparedCiphertext {
+	if dq != nil && dq.tables {
+		return pk.Prepare(c)
+	}
+	return &elgamal.PreparedCiphertext{Ciphertext: c}
+}
+
+// This function returns C1, decoded once at deployment if possible
+func (queryMessagePlus *QueryMessagePlus) decodedC1() (*elgamal.Ciphertext, error) {
+	if dq := queryMessagePlus.deployment(); dq != nil {
+		return dq.c1, nil
+	}
+	return queryMessagePlus.PK.Bytes2Ciphertext(queryMessagePlus.C1, queryMessagePlus.PointCompression)
+}
+
+// This function returns the EBF entry at position i, from the cache of a
+// deployed query or freshly decoded
+func (queryMessagePlus *QueryMessagePlus) ebfEntry(i int) (*elgamal.PreparedCiphertext, error) {
+	if dq := queryMessagePlus.deployment(); dq != nil {
+		return queryMessagePlus.deployedEntry(dq, i)
+	}
+	c, err := queryMessagePlus.PK.Bytes2Ciphertext(queryMessagePlus.EBF[i], queryMessagePlus.PointCompression)
+	if err != nil {
+		return nil, err
+	}
+	return &elgamal.PreparedCiphertext{Ciphertext: c}, nil
+}
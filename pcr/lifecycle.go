@@ -0,0 +1,259 @@
+package pcr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	bloom "bhwmonitoring-go/bloom"
+	elgamal "bhwmonitoring-go/elgamal"
+)
+
+// The errors returned for a query the monitor must no longer answer
+var (
+	ErrQueryExpired = errors.New("query expired")
+	ErrQueryRevoked = errors.New("query revoked")
+)
+
+const (
+	revocationLabel   = "bhwmonitoring-go/query-revocation/v1"
+	lifetimeLabel     = "bhwmonitoring-go/query-lifetime/v1"
+	responseModeLabel = "bhwmonitoring-go/response-mode/v1"
+	queryIDLabel      = "bhwmonitoring-go/query-id/v1"
+
+	// how far ahead of the monitor's clock the target's clock may be when it
+	// signs a revocation
+	revocationClockSkew = 5 * time.Minute
+)
+
+// This function returns the validity of a new query, none without a Lifetime
+func queryLifetime(reqPara *ReqPara) (issuedAt, expiresAt int64) {
+	if reqPara.Lifetime <= 0 {
+		return 0, 0
+	}
+	now := time.Now()
+	return now.Unix(), now.Add(reqPara.Lifetime).Unix()
+}
+
+// This function returns the label the proofs of a query bind its response
+// mode and validity with, so that neither can be changed on the way to the
+// monitor. RevealPassword, the default, adds nothing to the label, and a
+// RevealPassword query without a validity has an empty label and keeps the
+// challenge of a plain EncryptSeqWithZKP.
+func queryBinding(mode ResponseMode, issuedAt, expiresAt int64) []byte {
+	if mode == RevealPassword && issuedAt == 0 && expiresAt == 0 {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString(lifetimeLabel)
+	binary.Write(buf, binary.BigEndian, issuedAt)
+	binary.Write(buf, binary.BigEndian, expiresAt)
+	if mode != RevealPassword {
+		buf.WriteString(responseModeLabel)
+		binary.Write(buf, binary.BigEndian, int64(mode))
+	}
+	return buf.Bytes()
+}
+
+// This function returns the ID of a query, derived from its challenge, which
+// hashes the whole query and its validity, so that seeded runs stay
+// reproducible. The monitor recomputes it.
+func queryIDOf(challenge []byte) []byte {
+	return elgamal.HashSha256(append([]byte(queryIDLabel), challenge...))[:16]
+}
+
+func expired(expiresAt int64) bool {
+	return expiresAt != 0 && time.Now().Unix() >= expiresAt
+}
+
+// This function returns ErrQueryExpired or ErrQueryRevoked if the query must
+// no longer be answered. ResponseGen and its variants check it before
+// answering.
+func (queryMessagePlus *QueryMessagePlus) CheckLive() error {
+	if atomic.LoadInt32(&queryMessagePlus.revoked) != 0 {
+		return ErrQueryRevoked
+	}
+	if expired(queryMessagePlus.ExpiresAt) {
+		return ErrQueryExpired
+	}
+	return nil
+}
+
+// Revocation withdraws a query before its expiry. It is signed with the secret
+// key of the query, so only the target that issued the query can revoke it.
+type Revocation struct {
+	QueryID   []byte
+	RevokedAt int64 // Unix time
+	Signature *elgamal.SignatureByte
+}
+
+// This function revokes a query issued with sk
+func RevokeQuery(sk *elgamal.SecretKey, queryMessage *QueryMessage) *Revocation {
+	revocation := &Revocation{QueryID: queryMessage.QueryID, RevokedAt: time.Now().Unix()}
+	sig := sk.Sign(revocation.signedBytes())
+	revocation.Signature = queryMessage.PK.Signature2Bytes(sig, queryMessage.PointCompression)
+	return revocation
+}
+
+// This function verifies a revocation against the public key of the query and
+// marks the query revoked, so that every later response fails with
+// ErrQueryRevoked, and stops its precomputation pool. The mark is not stored
+// with the query; the monitor drops the query from its storage. The signed
+// RevokedAt must lie within the validity of the query and not after the
+// monitor's clock, give or take revocationClockSkew.
+func (queryMessagePlus *QueryMessagePlus) Revoke(revocation *Revocation) error {
+
+	pk := queryMessagePlus.PK
+	if err := pk.InitCurve(); err != nil {
+		return err
+	}
+	if len(queryMessagePlus.QueryID) == 0 || !bytes.Equal(revocation.QueryID, queryMessagePlus.QueryID) {
+		return errors.New("revocation is for another query")
+	}
+	sig, err := pk.Bytes2Signature(revocation.Signature, queryMessagePlus.PointCompression)
+	if err != nil {
+		return err
+	}
+	if !pk.VerifySignature(revocation.signedBytes(), sig) {
+		return errors.New("Invalid revocation signature!")
+	}
+	if revocation.RevokedAt <= 0 || revocation.RevokedAt < queryMessagePlus.IssuedAt {
+		return errors.New("revocation predates the query")
+	}
+	if queryMessagePlus.ExpiresAt != 0 && revocation.RevokedAt >= queryMessagePlus.ExpiresAt {
+		return errors.New("revocation made after the query expired")
+	}
+	if revocation.RevokedAt > time.Now().Add(revocationClockSkew).Unix() {
+		return errors.New("revocation made in the future")
+	}
+
+	atomic.StoreInt32(&queryMessagePlus.revoked, 1)
+	if queryMessagePlus.precompute != nil {
+		queryMessagePlus.precompute.Stop()
+	}
+	return nil
+}
+
+func (revocation *Revocation) signedBytes() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(revocationLabel)
+	binary.Write(buf, binary.BigEndian, revocation.RevokedAt)
+	buf.Write(revocation.QueryID)
+	return buf.Bytes()
+}
+
+// This function encodes a revocation to bytes.
+func EncodeRevocation(revocation *Revocation) []byte {
+
+	revocationJson, _ := json.Marshal(*revocation)
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	_, err := w.Write(revocationJson)
+	err = w.Close()
+	if err != nil {
+		panic(err)
+	}
+	return b.Bytes()
+}
+
+// This function decodes a revocation in bytes to struct; Revoke checks it.
+func DecodeRevocation(revocationBytes []byte) (*Revocation, error) {
+
+	var revocation Revocation
+
+	revocationJson, err := gunzipMessage(revocationBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(revocationJson, &revocation)
+	if err != nil {
+		return nil, err
+	}
+	return &revocation, nil
+}
+
+// IssuedQuery is what the target keeps of a query it issued: the key pair
+// generated for the query, its parameters and Bloom filter, and the query as
+// sent
+type IssuedQuery struct {
+	PK      *elgamal.PublicKey
+	SK      *elgamal.SecretKey
+	ReqPara *ReqPara
+	BF      *bloom.BloomFilter
+	Query   *QueryMessage
+}
+
+// This function issues a query for pwd with the parameters of reqPara under a
+// fresh key pair
+func IssueQuery(ctx context.Context, reqPara *ReqPara, pwd string) (*IssuedQuery, error) {
+
+	// ReqInitGroup exits on an unknown group
+	group, err := elgamal.GroupByName(reqPara.Group)
+	if err != nil {
+		return nil, err
+	}
+	pk, sk, err := elgamal.KeyGenInGroup(group, reqPara.PointCompression)
+	if err != nil {
+		return nil, err
+	}
+	para := *reqPara
+	para.Group = group.Name()
+	para.Params = pk.SecParam
+
+	bf := ReqBFGen(pk, &para, pwd)
+	queryMessage, err := QueryGenContext(ctx, pk, &para, bf)
+	if err != nil {
+		return nil, err
+	}
+	return &IssuedQuery{PK: pk, SK: sk, ReqPara: &para, BF: bf, Query: queryMessage}, nil
+}
+
+// QueryScheduler keeps one account monitored with queries that are re-issued
+// under fresh keys before they expire, which limits how long any one encrypted
+// filter is exposed to a monitor
+type QueryScheduler struct {
+	ReqPara  *ReqPara // Lifetime must be positive; expiry times are whole seconds
+	Password string
+	Lead     time.Duration // re-issue this long before expiry; at least a second shorter than Lifetime
+	// Publish sends next to the monitors. prev is the query next replaces,
+	// nil the first time, e.g. to send RevokeQuery(prev.SK, prev.Query) along.
+	Publish func(ctx context.Context, next, prev *IssuedQuery) error
+}
+
+// This function issues and publishes a query, and a new one Lead before each
+// expiry, until ctx is done. It returns ctx.Err(), or the first error of
+// IssueQuery or Publish.
+func (s *QueryScheduler) Run(ctx context.Context) error {
+
+	// ExpiresAt is cut to whole seconds, so a shorter gap could put the next
+	// issuance in the past and re-issue in a tight loop
+	if s.ReqPara.Lifetime <= 0 || s.Lead <= 0 || s.ReqPara.Lifetime-s.Lead < time.Second {
+		return errors.New("scheduler needs Lead > 0 and Lifetime - Lead >= 1s")
+	}
+
+	var current *IssuedQuery
+	for {
+		next, err := IssueQuery(ctx, s.ReqPara, s.Password)
+		if err != nil {
+			return err
+		}
+		if err := s.Publish(ctx, next, current); err != nil {
+			return err
+		}
+		current = next
+
+		timer := time.NewTimer(time.Until(time.Unix(current.Query.ExpiresAt, 0).Add(-s.Lead)))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
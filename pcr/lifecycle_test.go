@@ -0,0 +1,205 @@
+package pcr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	elgamal "bhwmonitoring-go/elgamal"
+)
+
+// The monitor deploys a query only with the validity and ID it was issued
+// with, so that a query cannot be made to live forever on the way
+func TestQueryLifetimeIsBound(t *testing.T) {
+
+	pk, _, reqPara := ReqInitGroup("P-256", 64, 16, 4, 1, true)
+	reqPara.Lifetime = time.Hour
+	queryMessage := QueryGen(pk, reqPara, ReqBFGen(pk, reqPara, "Simba"))
+	if queryMessage.IssuedAt == 0 || queryMessage.ExpiresAt <= queryMessage.IssuedAt {
+		t.Fatalf("query valid from %d to %d", queryMessage.IssuedAt, queryMessage.ExpiresAt)
+	}
+
+	received := func(tamper func(q *QueryMessage)) *QueryMessage {
+		t.Helper()
+		q, err := DecodeQuery(EncodeQuery(queryMessage))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tamper(q)
+		return q
+	}
+
+	queryMessagePlus, err := RespDeploymentContext(context.Background(), received(func(q *QueryMessage) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queryMessagePlus.ExpiresAt != queryMessage.ExpiresAt {
+		t.Fatal("deployed query lost its expiry")
+	}
+
+	for _, c := range []struct {
+		name   string
+		tamper func(q *QueryMessage)
+		want   error
+	}{
+		{"never expires", func(q *QueryMessage) { q.ExpiresAt = 0 }, elgamal.ErrInvalidZKP},
+		{"later expiry", func(q *QueryMessage) { q.ExpiresAt += 3600 }, elgamal.ErrInvalidZKP},
+		{"no validity", func(q *QueryMessage) { q.IssuedAt, q.ExpiresAt = 0, 0 }, elgamal.ErrInvalidZKP},
+		{"issued later", func(q *QueryMessage) { q.IssuedAt++ }, elgamal.ErrInvalidZKP},
+		{"membership only", func(q *QueryMessage) { q.ResponseMode = MembershipOnly }, elgamal.ErrInvalidZKP},
+		{"other ID", func(q *QueryMessage) { q.QueryID[0] ^= 1 }, nil},
+		{"no ID", func(q *QueryMessage) { q.QueryID = nil }, nil},
+	} {
+		_, err := RespDeploymentContext(context.Background(), received(c.tamper))
+		if err == nil || (c.want != nil && !errors.Is(err, c.want)) {
+			t.Errorf("%s: got %v", c.name, err)
+		}
+	}
+
+	// a query without a Lifetime has no validity to bind
+	reqPara.Lifetime = 0
+	forever := QueryGen(pk, reqPara, ReqBFGen(pk, reqPara, "Simba"))
+	if forever.IssuedAt != 0 || forever.ExpiresAt != 0 {
+		t.Fatalf("query without a Lifetime valid from %d to %d", forever.IssuedAt, forever.ExpiresAt)
+	}
+	if _, err := RespDeploymentContext(context.Background(), forever); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A query for membership only must not be turned into one that has the
+// monitor reveal the submitted passwords
+func TestQueryResponseModeIsBound(t *testing.T) {
+
+	pk, _, reqPara := ReqInitGroup("P-256", 64, 16, 4, 1, true)
+	reqPara.ResponseMode = MembershipOnly
+	for _, lifetime := range []time.Duration{0, time.Hour} {
+		reqPara.Lifetime = lifetime
+		queryMessage := QueryGen(pk, reqPara, ReqBFGen(pk, reqPara, "Simba"))
+		for _, c := range []struct {
+			mode ResponseMode
+			want error
+		}{{MembershipOnly, nil}, {RevealPassword, elgamal.ErrInvalidZKP}} {
+			q, err := DecodeQuery(EncodeQuery(queryMessage))
+			if err != nil {
+				t.Fatal(err)
+			}
+			q.ResponseMode = c.mode
+			queryMessagePlus, err := RespDeploymentContext(context.Background(), q)
+			if c.want == nil && (err != nil || queryMessagePlus.ResponseMode != MembershipOnly) {
+				t.Fatalf("lifetime %v: deploying the query: %v", lifetime, err)
+			}
+			if c.want != nil && !errors.Is(err, c.want) {
+				t.Fatalf("lifetime %v: deployed with mode %v: %v", lifetime, c.mode, err)
+			}
+		}
+	}
+}
+
+func TestIssueQueryUnknownGroup(t *testing.T) {
+	reqPara := &ReqPara{Group: "P-999", BfLength: 64, BfNumOnes: 16, NumHashFuncs: 4, NumThreads: 1, PointCompression: true, Lifetime: time.Hour}
+	if _, err := IssueQuery(context.Background(), reqPara, "Simba"); err == nil {
+		t.Fatal("issued a query in an unknown group")
+	}
+}
+
+func TestQuerySchedulerNeedsASecondBeforeLead(t *testing.T) {
+	s := &QueryScheduler{
+		ReqPara:  &ReqPara{Group: "P-256", BfLength: 64, BfNumOnes: 16, NumHashFuncs: 4, NumThreads: 1, PointCompression: true, Lifetime: 1500 * time.Millisecond},
+		Password: "Simba",
+		Lead:     time.Second,
+		Publish: func(ctx context.Context, next, prev *IssuedQuery) error {
+			return errors.New("published")
+		},
+	}
+	if err := s.Run(context.Background()); err == nil || err.Error() == "published" {
+		t.Fatalf("got %v, want the scheduler to refuse Lifetime - Lead < 1s", err)
+	}
+}
+
+// This function returns a revocation of queryID at revokedAt signed with sk
+func signedRevocation(sk *elgamal.SecretKey, queryID []byte, revokedAt int64) *Revocation {
+	revocation := &Revocation{QueryID: queryID, RevokedAt: revokedAt}
+	revocation.Signature = sk.PublicKey().Signature2Bytes(sk.Sign(revocation.signedBytes()), true)
+	return revocation
+}
+
+// Only the target that issued a query can revoke it, and only with a
+// revocation made while the query was valid; a revoked query is not answered
+func TestRevokeQuery(t *testing.T) {
+
+	pk, sk, reqPara := ReqInitGroup("P-256", 64, 16, 4, 1, true)
+	_, otherSK, _ := ReqInitGroup("P-256", 64, 16, 4, 1, true)
+	reqPara.Lifetime = time.Hour
+	queryMessage := QueryGen(pk, reqPara, ReqBFGen(pk, reqPara, "Simba"))
+	deployed := func(queryMessage *QueryMessage) *QueryMessagePlus {
+		t.Helper()
+		q, err := DecodeQuery(EncodeQuery(queryMessage))
+		if err != nil {
+			t.Fatal(err)
+		}
+		queryMessagePlus, err := RespDeploymentContext(context.Background(), q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return queryMessagePlus
+	}
+	queryMessagePlus := deployed(queryMessage)
+
+	now := time.Now().Unix()
+	otherID := append([]byte(nil), queryMessage.QueryID...)
+	otherID[0] ^= 1
+	for _, c := range []struct {
+		name       string
+		revocation *Revocation
+	}{
+		{"wrong key", signedRevocation(otherSK, queryMessage.QueryID, now)},
+		{"wrong query ID", signedRevocation(sk, otherID, now)},
+		{"no query ID", signedRevocation(sk, nil, now)},
+		{"time changed after signing", func() *Revocation {
+			r := signedRevocation(sk, queryMessage.QueryID, now)
+			r.RevokedAt--
+			return r
+		}()},
+		{"no time", signedRevocation(sk, queryMessage.QueryID, 0)},
+		{"before issue", signedRevocation(sk, queryMessage.QueryID, queryMessage.IssuedAt-1)},
+		{"at expiry", signedRevocation(sk, queryMessage.QueryID, queryMessage.ExpiresAt)},
+		{"in the future", signedRevocation(sk, queryMessage.QueryID, time.Now().Add(revocationClockSkew+time.Minute).Unix())},
+	} {
+		if err := queryMessagePlus.Revoke(c.revocation); err == nil {
+			t.Errorf("%s: revocation accepted", c.name)
+		}
+		if err := queryMessagePlus.CheckLive(); err != nil {
+			t.Fatalf("%s: rejected revocation left the query %v", c.name, err)
+		}
+	}
+
+	revocation, err := DecodeRevocation(EncodeRevocation(RevokeQuery(sk, queryMessage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queryMessagePlus.Revoke(revocation); err != nil {
+		t.Fatal(err)
+	}
+	if err := queryMessagePlus.CheckLive(); !errors.Is(err, ErrQueryRevoked) {
+		t.Fatalf("revoked query is %v", err)
+	}
+	if _, err := ResponseGenContext(context.Background(), nil, queryMessagePlus, "Simba"); !errors.Is(err, ErrQueryRevoked) {
+		t.Fatalf("response to a revoked query returned %v", err)
+	}
+
+	// a query without a Lifetime can be revoked at any time up to now
+	reqPara.Lifetime = 0
+	forever := QueryGen(pk, reqPara, ReqBFGen(pk, reqPara, "Simba"))
+	foreverPlus := deployed(forever)
+	if err := foreverPlus.Revoke(signedRevocation(sk, forever.QueryID, time.Now().Add(time.Hour).Unix())); err == nil {
+		t.Fatal("revocation in the future accepted")
+	}
+	if err := foreverPlus.Revoke(RevokeQuery(sk, forever)); err != nil {
+		t.Fatal(err)
+	}
+	if err := foreverPlus.CheckLive(); !errors.Is(err, ErrQueryRevoked) {
+		t.Fatalf("revoked query is %v", err)
+	}
+}
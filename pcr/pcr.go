@@ -3,11 +3,13 @@ package pcr
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
-	"math/rand"
-	"time"
+	"crypto/rand"
 	bloom "bhwmonitoring-go/bloom"
 	elgamal "bhwmonitoring-go/elgamal"
+	workpool "bhwmonitoring-go/workpool"
+	"io"
 	"io/ioutil"
 	"math/big"
 	"sync"
@@ -15,16 +17,20 @@ import (
 	"os"
 	"fmt"
 	"errors"
+	"time"
 )
 
 
 type ReqPara struct {
+	Group string
 	Params int
 	BfLength int
 	BfNumOnes int
 	NumHashFuncs int
 	NumThreads int
 	PointCompression bool
+	ResponseMode ResponseMode
+	Lifetime time.Duration // queries expire this long after QueryGen; 0 for never
 }
 
 type QueryMessage struct {
@@ -37,6 +43,10 @@ type QueryMessage struct {
 	EBF []*elgamal.CiphertextByte
 	ZKPs []*elgamal.ZKPByte
 	Challenge []byte
+	ResponseMode ResponseMode `json:",omitempty"`
+	QueryID []byte `json:",omitempty"`
+	IssuedAt int64 `json:",omitempty"`  // Unix time; 0 without a Lifetime
+	ExpiresAt int64 `json:",omitempty"` // Unix time; 0 for never
 }
 
 type QueryMessagePlus struct {
@@ -48,11 +58,43 @@ type QueryMessagePlus struct {
 	PK *elgamal.PublicKey
 	EBF []*elgamal.CiphertextByte
 	C1 *elgamal.CiphertextByte
+	ResponseMode ResponseMode
+	Challenge []byte // of the query or of the last QueryDelta applied, see ApplyQueryDelta
+	QueryID []byte
+	IssuedAt int64
+	ExpiresAt int64
+
+	deployMu   sync.RWMutex // guards deployed, which Deploy may swap while responses read it
+	deployed   *deployedQuery
+	precompute *PrecomputePool
+	revoked    int32 // set atomically by Revoke
 }
 
 type ResponseMessage struct {
 	Z1 *elgamal.CiphertextByte
-	Z2 *elgamal.CiphertextByte
+	Z2 *elgamal.CiphertextByte `json:",omitempty"` // nil in MembershipOnly mode
+	Payload []byte `json:",omitempty"`
+}
+
+// ResponseMode is negotiated through the query and tells the monitor what a
+// response may reveal on a match
+type ResponseMode int
+
+const (
+	// Z2 reveals the submitted password, or with ResponseGenHybrid the whole
+	// reveal record
+	RevealPassword ResponseMode = iota
+	// the response is the Z1 zero-test alone and only tells whether the
+	// submitted password matched. A monitor unwilling to reveal passwords
+	// sets this mode on every QueryMessagePlus it deploys.
+	MembershipOnly
+)
+
+// RevealRecord is what a hybrid response reveals to the target on a match
+type RevealRecord struct {
+	Password string
+	Timestamp int64
+	Source string
 }
 
 var mutex sync.Mutex
@@ -79,10 +121,11 @@ func ReqBFGen(pk *elgamal.PublicKey, reqPara *ReqPara, pwd string) *bloom.BloomF
 	// To be optimized
 	randBits := []byte{1, 2, 4, 8, 16, 32, 64, 128}
 
-	rand.Seed(time.Now().UnixNano())
+	// the noise bits come from the key's randomness source, see SetRandomSource
+	source := pk.RandomSource()
 	for i := 0; GetBFNumOnes(bf) < reqPara.BfNumOnes ; i++ {
-		randBit := randBits[rand.Intn(len(randBits))]
-		randBFByteIdx := 24 + rand.Intn(reqPara.BfLength/8) // BloomFilter struct has 24 bits of parameters before BF bits
+		randBit := randBits[randomIntn(source, len(randBits))]
+		randBFByteIdx := 24 + randomIntn(source, reqPara.BfLength/8) // BloomFilter struct has 24 bits of parameters before BF bits
 		bfGob[randBFByteIdx] = bfGob[randBFByteIdx] | randBit
 		bf.GobDecode(bfGob)
 	}
@@ -90,16 +133,51 @@ func ReqBFGen(pk *elgamal.PublicKey, reqPara *ReqPara, pwd string) *bloom.BloomF
 	return bf
 }
 
+// This function returns a uniform integer in [0, n) read from r
+func randomIntn(r io.Reader, n int) int {
+	v, err := rand.Int(r, big.NewInt(int64(n)))
+	if err != nil {
+		panic(err)
+	}
+	return int(v.Int64())
+}
+
 func ReqInit(params int, bfLength int, bfNumOfOnes int, numHashFuncs, numWorkers int, pointCompression bool) (*elgamal.PublicKey, *elgamal.SecretKey, *ReqPara) {
-	pk, sk := elgamal.KeyGen(params, pointCompression)
-	reqPara := &ReqPara{params, bfLength, bfNumOfOnes, numHashFuncs, numWorkers, pointCompression}
+	group, err := elgamal.GroupBySecParam(params)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	return ReqInitGroup(group.Name(), bfLength, bfNumOfOnes, numHashFuncs, numWorkers, pointCompression)
+}
+
+// This function works as ReqInit, but generates the key pair in the named group,
+// e.g. "P-256" or "ristretto255"
+func ReqInitGroup(groupName string, bfLength int, bfNumOfOnes int, numHashFuncs, numWorkers int, pointCompression bool) (*elgamal.PublicKey, *elgamal.SecretKey, *ReqPara) {
+	group, err := elgamal.GroupByName(groupName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	pk, sk, err := elgamal.KeyGenInGroup(group, pointCompression)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	reqPara := &ReqPara{group.Name(), pk.SecParam, bfLength, bfNumOfOnes, numHashFuncs, numWorkers, pointCompression, RevealPassword, 0}
 
 	return pk, sk, reqPara
 }
 
 func QueryGen(pk *elgamal.PublicKey, reqPara *ReqPara, bf *bloom.BloomFilter) *QueryMessage {
+	queryMessage, _ := QueryGenContext(context.Background(), pk, reqPara, bf)
+	return queryMessage
+}
+
+// This function works as QueryGen, but stops once ctx is done and returns
+// ctx.Err()
+func QueryGenContext(ctx context.Context, pk *elgamal.PublicKey, reqPara *ReqPara, bf *bloom.BloomFilter) (*QueryMessage, error) {
 
-	var reqGen sync.WaitGroup
 	bf2encrypt := make([]*big.Int, reqPara.BfLength)
 
 	for i := 0; uint(i) < bf.Cap(); i++ {
@@ -111,37 +189,49 @@ func QueryGen(pk *elgamal.PublicKey, reqPara *ReqPara, bf *bloom.BloomFilter) *Q
 		}
 	}
 
-	ebf, zkps, challenge := pk.EncryptSeqWithZKP(bf2encrypt, reqPara.NumThreads)
+	// the proofs bind the response mode and validity of the query, see
+	// queryBinding
+	issuedAt, expiresAt := queryLifetime(reqPara)
+	ebf, zkps, challenge, err := pk.EncryptSeqWithZKPBoundContext(ctx, bf2encrypt, reqPara.NumThreads, queryBinding(reqPara.ResponseMode, issuedAt, expiresAt))
+	if err != nil {
+		return nil, err
+	}
 	ebfBytes := make([]*elgamal.CiphertextByte, len(ebf))
 	zkpsBytes := make([]*elgamal.ZKPByte, len(ebf))
 
-	chWorker := make(chan int, reqPara.NumThreads)
-	defer close(chWorker)
-
-	for i, _ := range ebf {
-		chWorker <- 1
-		reqGen.Add(1)
-		go func(i int) {
-			defer reqGen.Done()
-			ebfBytes[i] = pk.Ciphertext2Bytes(ebf[i], reqPara.PointCompression)
-			zkpsBytes[i] = pk.ZKP2Bytes(zkps[i], reqPara.PointCompression)
-			<- chWorker
-		}(i)
-		
+	err = workpool.FromContext(ctx).ForEach(ctx, len(ebf), func(i int) {
+		ebfBytes[i] = pk.Ciphertext2Bytes(ebf[i], reqPara.PointCompression)
+		zkpsBytes[i] = pk.ZKP2Bytes(zkps[i], reqPara.PointCompression)
+	})
+	if err != nil {
+		return nil, err
 	}
-	reqGen.Wait()
 
-	queryMessage := &QueryMessage{reqPara.BfLength, reqPara.BfNumOnes, reqPara.NumHashFuncs, reqPara.NumThreads, reqPara.PointCompression, pk, ebfBytes, zkpsBytes, challenge.Bytes()}
+	queryMessage := &QueryMessage{reqPara.BfLength, reqPara.BfNumOnes, reqPara.NumHashFuncs, reqPara.NumThreads, reqPara.PointCompression, pk, ebfBytes, zkpsBytes, challenge.Bytes(), reqPara.ResponseMode, queryIDOf(challenge.Bytes()), issuedAt, expiresAt}
 
-	return queryMessage
+	return queryMessage, nil
 }
 
 func RespDeployment(queryMessage *QueryMessage) *QueryMessagePlus {
-	
-	var respDep sync.WaitGroup
+	queryMessagePlus, err := RespDeploymentContext(context.Background(), queryMessage)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	return queryMessagePlus
+}
+
+// This function works as RespDeployment, but returns an error for an invalid
+// query instead of exiting, and stops once ctx is done and returns ctx.Err()
+func RespDeploymentContext(ctx context.Context, queryMessage *QueryMessage) (*QueryMessagePlus, error) {
 
+	if expired(queryMessage.ExpiresAt) {
+		return nil, ErrQueryExpired
+	}
 	pk := queryMessage.PK
-	pk.InitCurve()
+	if err := pk.InitCurve(); err != nil {
+		return nil, err
+	}
 
 	ebfBytes := queryMessage.EBF
 	zkpsBytes := queryMessage.ZKPs
@@ -149,135 +239,337 @@ func RespDeployment(queryMessage *QueryMessage) *QueryMessagePlus {
 	zkps := make([]*elgamal.ZKP, len(ebfBytes))
 	challenge := big.NewInt(1).SetBytes(queryMessage.Challenge)
 
-	chWorker := make(chan int, queryMessage.NumThreads)
-	defer close(chWorker)
+	pool := workpool.FromContext(ctx)
+	decodeErrs := make([]error, len(ebfBytes))
+	err := pool.ForEach(ctx, len(ebfBytes), func(i int) {
+		var err error
+		if ebf[i], err = pk.Bytes2Ciphertext(ebfBytes[i], queryMessage.PointCompression); err == nil {
+			zkps[i], err = pk.Bytes2ZKP(zkpsBytes[i], queryMessage.PointCompression)
+		}
+		decodeErrs[i] = err
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	for i := range ebfBytes {
-		chWorker <- 1
-		respDep.Add(1)
-		go func(i int) {
-			defer respDep.Done()
-			ebf[i] = pk.Bytes2Ciphertext(ebfBytes[i], queryMessage.PointCompression)
-			zkps[i] = pk.Bytes2ZKP(zkpsBytes[i], queryMessage.PointCompression)
-			<- chWorker
-		}(i)
-		
+	for _, err := range decodeErrs {
+		if err != nil {
+			return nil, errors.New("Invalid query: " + err.Error())
+		}
 	}
-	respDep.Wait()
 
-	if !pk.VerifySeqZKP(ebf, zkps, challenge, queryMessage.NumThreads) {
-		err := errors.New("Invalid ZKP!")
-		fmt.Println(err)
-		os.Exit(-1)
+	// a proof that does not verify comes back as an *elgamal.ZKPError, and so
+	// does a response mode or validity changed after QueryGen
+	binding := queryBinding(queryMessage.ResponseMode, queryMessage.IssuedAt, queryMessage.ExpiresAt)
+	if _, err := pk.VerifySeqZKPBoundContext(ctx, ebf, zkps, challenge, queryMessage.NumThreads, binding); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(queryMessage.QueryID, queryIDOf(queryMessage.Challenge)) {
+		return nil, errors.New("Invalid query: QueryID does not match the challenge")
 	}
 
 	encInvSum := pk.Encrypt(big.NewInt(int64(queryMessage.BfLength-2*queryMessage.BfNumOnes)))
 
-	resCT := pk.Encrypt(big.NewInt(0))
-	for _, ebit := range ebf {
-		resCT = pk.Add(resCT, ebit, false)
-	}
+	resCT := pk.Sum(ebf, pool.Workers())
 	resCT = pk.Add(resCT, encInvSum, false)
 
 	c1 := pk.Ciphertext2Bytes(resCT, queryMessage.PointCompression)
-	queryMessagePlus := &QueryMessagePlus{queryMessage.BfLength, queryMessage.BfNumOnes, queryMessage.NumHashFuncs, queryMessage.NumThreads, queryMessage.PointCompression, queryMessage.PK, queryMessage.EBF, c1}
-	return queryMessagePlus
+	queryMessagePlus := &QueryMessagePlus{BfLength: queryMessage.BfLength, BfNumOnes: queryMessage.BfNumOnes, NumHashFuncs: queryMessage.NumHashFuncs, NumThreads: queryMessage.NumThreads, PointCompression: queryMessage.PointCompression, PK: queryMessage.PK, EBF: queryMessage.EBF, C1: c1, ResponseMode: queryMessage.ResponseMode, Challenge: queryMessage.Challenge, QueryID: queryMessage.QueryID, IssuedAt: queryMessage.IssuedAt, ExpiresAt: queryMessage.ExpiresAt}
+	if err := queryMessagePlus.Deploy(false); err != nil {
+		return nil, err
+	}
+	return queryMessagePlus, nil
 }
 
 
 func ResponseGen(sk *elgamal.SecretKey, queryMessagePlus *QueryMessagePlus, submittedPWD string) *ResponseMessage {
+	responseMessage, err := ResponseGenContext(context.Background(), sk, queryMessagePlus, submittedPWD)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	return responseMessage
+}
+
+// This function works as ResponseGen, but returns an error for an invalid
+// deployed query instead of exiting, and stops once ctx is done and returns
+// ctx.Err()
+func ResponseGenContext(ctx context.Context, sk *elgamal.SecretKey, queryMessagePlus *QueryMessagePlus, submittedPWD string) (*ResponseMessage, error) {
+
+	if err := queryMessagePlus.CheckLive(); err != nil {
+		return nil, err
+	}
+	pk := queryMessagePlus.PK
+	if err := pk.InitCurve(); err != nil {
+		return nil, err
+	}
+
+	// randomness from the precomputation pool, if any, see Precompute
+	r := queryMessagePlus.precomputed()
+
+	if queryMessagePlus.ResponseMode == MembershipOnly {
+		return membershipResponse(ctx, queryMessagePlus, submittedPWD, r)
+	}
+
+	// a password too long to embed is an error, not a panic of EncryptMul
+	var mask *elgamal.Ciphertext
+	if r != nil && r.mask != nil {
+		mask = r.mask
+	} else {
+		mask = pk.Encrypt(big.NewInt(0))
+	}
+	encHashedPWD, err := pk.EncryptMulWithZero([]byte(submittedPWD), mask)
+	if err != nil {
+		return nil, err
+	}
+	c1PLUSc2, err := responseBase(ctx, queryMessagePlus, submittedPWD, r)
+	if err != nil {
+		return nil, err
+	}
+
+	z1 := pk.Ciphertext2Bytes(pk.ScalarMultRandomizer(c1PLUSc2, false), queryMessagePlus.PointCompression)
+	z2 := pk.Ciphertext2Bytes(pk.Add(c1PLUSc2, encHashedPWD, false), queryMessagePlus.PointCompression)
+	responseMessage := &ResponseMessage{Z1: z1, Z2: z2}
+	return responseMessage, nil
+}
+
+// This function generates the response of a MembershipOnly query: Z1 alone,
+// which decrypts to zero iff the submitted password matched
+func membershipResponse(ctx context.Context, queryMessagePlus *QueryMessagePlus, submittedPWD string, r *responseRandomness) (*ResponseMessage, error) {
+
+	pk := queryMessagePlus.PK
+	c1PLUSc2, err := responseBase(ctx, queryMessagePlus, submittedPWD, r)
+	if err != nil {
+		return nil, err
+	}
+
+	z1 := pk.Ciphertext2Bytes(pk.ScalarMultRandomizer(c1PLUSc2, false), queryMessagePlus.PointCompression)
+	return &ResponseMessage{Z1: z1}, nil
+}
+
+// This function generates a response whose Z2 carries a KEM ciphertext instead of
+// an EncryptMul encryption of the password. On a match the target recovers the
+// KEM key and opens the payload, which holds the whole reveal record.
+func ResponseGenHybrid(sk *elgamal.SecretKey, queryMessagePlus *QueryMessagePlus, record *RevealRecord) *ResponseMessage {
+	responseMessage, err := ResponseGenHybridContext(context.Background(), sk, queryMessagePlus, record)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	return responseMessage
+}
+
+// This function works as ResponseGenHybrid with the error handling of
+// ResponseGenContext
+func ResponseGenHybridContext(ctx context.Context, sk *elgamal.SecretKey, queryMessagePlus *QueryMessagePlus, record *RevealRecord) (*ResponseMessage, error) {
+
+	if record == nil {
+		return nil, errors.New("no reveal record to respond with")
+	}
+	if err := queryMessagePlus.CheckLive(); err != nil {
+		return nil, err
+	}
+	pk := queryMessagePlus.PK
+	if err := pk.InitCurve(); err != nil {
+		return nil, err
+	}
+
+	r := queryMessagePlus.precomputed()
+
+	if queryMessagePlus.ResponseMode == MembershipOnly {
+		// the record stays with the monitor
+		return membershipResponse(ctx, queryMessagePlus, record.Password, r)
+	}
 
-	var respGen sync.WaitGroup
+	recordJson, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var kem *elgamal.Ciphertext
+	var key []byte
+	if r != nil && r.kem != nil {
+		kem, key = r.kem, r.key
+	} else {
+		kem, key = pk.EncapsulateKey()
+	}
+	payload, err := elgamal.SealPayload(key, recordJson)
+	if err != nil {
+		return nil, err
+	}
+
+	c1PLUSc2, err := responseBase(ctx, queryMessagePlus, record.Password, r)
+	if err != nil {
+		return nil, err
+	}
+
+	z1 := pk.Ciphertext2Bytes(pk.ScalarMultRandomizer(c1PLUSc2, false), queryMessagePlus.PointCompression)
+	z2 := pk.Ciphertext2Bytes(pk.Add(c1PLUSc2, kem, false), queryMessagePlus.PointCompression)
+	responseMessage := &ResponseMessage{z1, z2, payload}
+	return responseMessage, nil
+}
+
+// This function computes the randomized sum c1 + c2, which encrypts zero iff all
+// positions of the submitted password are set in the target's Bloom filter.
+// Only the k positions of the password are touched: c2 encrypts
+// Σ r_i·(b_i - 1) over those positions, computed as one linear combination of
+// their EBF entries and a single encryption of -1 weighted by Σ r_i.
+func responseBase(ctx context.Context, queryMessagePlus *QueryMessagePlus, submittedPWD string, r *responseRandomness) (*elgamal.Ciphertext, error) {
 
 	pk := queryMessagePlus.PK
-	pk.InitCurve()
 
 	hashedPWD := elgamal.HashSha256([]byte(submittedPWD))
-	encHashedPWD := pk.EncryptMul([]byte(submittedPWD))
 
 	bf := bloom.New(uint(queryMessagePlus.BfLength), uint(queryMessagePlus.NumHashFuncs))
-	bf.Add(hashedPWD)
+	locs := bf.Locations(hashedPWD)
 
-	chWorker := make(chan int, queryMessagePlus.NumThreads)
-	defer close(chWorker)
-
-	chRes := make(chan *elgamal.Ciphertext, queryMessagePlus.NumThreads)
-	defer close(chRes)
-
-	taskUnit := int(bf.Cap())/int(queryMessagePlus.NumThreads)
-
-	for t := 0; t < queryMessagePlus.NumThreads; t++ {
-		chWorker <- 1
-		respGen.Add(1)
-		if (t < queryMessagePlus.NumThreads - 1) {
-			go func(start, end int) {
-				defer respGen.Done()
-				encRes := pk.Encrypt(big.NewInt(0))
-				for i := start; i < end; i++ {
-					bfIndex := []uint64{uint64(i)}
-					if bf.TestLocations(bfIndex) {
-						encNegOne := pk.Encrypt(big.NewInt(-1))
-						encShouldBeZero := pk.Add(encNegOne, pk.Bytes2Ciphertext(queryMessagePlus.EBF[i], queryMessagePlus.PointCompression), false)
-						encShouldBeZero = pk.ScalarMultRandomizer(encShouldBeZero, false)
-						encRes = pk.Add(encRes, encShouldBeZero, false)
-					} 
-				}
-				chRes <- encRes
-				<- chWorker
-			}(t * taskUnit, (t + 1) * taskUnit)
-		} else {
-			go func(start, end int) {
-				defer respGen.Done()
-				encRes := pk.Encrypt(big.NewInt(0))
-				for i := start; i < end; i++ {
-					bfIndex := []uint64{uint64(i)}
-					if bf.TestLocations(bfIndex) {
-						encNegOne := pk.Encrypt(big.NewInt(-1))
-						encShouldBeZero := pk.Add(encNegOne, pk.Bytes2Ciphertext(queryMessagePlus.EBF[i], queryMessagePlus.PointCompression), false)
-						encShouldBeZero = pk.ScalarMultRandomizer(encShouldBeZero, false)
-						encRes = pk.Add(encRes, encShouldBeZero, false)
-					} 
-				}
-				chRes <- encRes
-				<- chWorker
-			}(t * taskUnit, int(bf.Cap()))
+	if r == nil {
+		c1, err := queryMessagePlus.decodedC1()
+		if err != nil {
+			return nil, err
 		}
-	
+		// the randomness is drawn in position order before any work starts,
+		// so that a deterministic source (see SetRandomSource) gives the same
+		// response for any executor
+		r = &responseRandomness{}
+		fillResponseRandomness(queryMessagePlus, r, c1, len(locs))
 	}
-	respGen.Wait()
 
-	c2 := pk.Encrypt(big.NewInt(0))
-	go func() {
-		for ciphertext := range chRes {
-			c2 = pk.Add(c2, ciphertext, false)
+	randomizers := make([]*big.Int, len(locs)+1)
+	sumR := big.NewInt(0)
+	for i := range locs {
+		randomizers[i] = r.rs[i]
+		sumR.Add(sumR, randomizers[i])
+	}
+	randomizers[len(locs)] = sumR.Mod(sumR, pk.Group().Order())
+
+	terms := make([]*elgamal.PreparedCiphertext, len(locs)+1)
+	decodeErrs := make([]error, len(locs))
+	err := workpool.FromContext(ctx).ForEach(ctx, len(locs), func(i int) {
+		terms[i], decodeErrs[i] = queryMessagePlus.ebfEntry(int(locs[i]))
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range decodeErrs {
+		if err != nil {
+			return nil, errors.New("Invalid query: " + err.Error())
 		}
-	}()
-	
-	
-	c1 := pk.Bytes2Ciphertext(queryMessagePlus.C1, queryMessagePlus.PointCompression)
-	c1 = pk.ScalarMultRandomizer(c1, false)
-	c1PLUSc2 := pk.Add(c1, c2, false)
+	}
+	terms[len(locs)] = r.negOne
 
-	z1 := pk.Ciphertext2Bytes(pk.ScalarMultRandomizer(c1PLUSc2, false), queryMessagePlus.PointCompression)
-	z2 := pk.Ciphertext2Bytes(pk.Add(c1PLUSc2, encHashedPWD, false), queryMessagePlus.PointCompression)
-	responseMessage := &ResponseMessage{z1, z2}
-	return responseMessage
+	c2 := pk.LinearCombinationPrepared(terms, randomizers)
+	c2 = pk.Add(c2, r.zero, false)
+
+	return pk.Add(r.c1, c2, false), nil
 }
 
-func ResponseDecrypt(pk *elgamal.PublicKey, sk *elgamal.SecretKey, reqPara *ReqPara, responseMessage *ResponseMessage, bf *bloom.BloomFilter) (success bool, result []byte) {
+// This function draws the randomness of one response from the precomputation
+// pool of the query, or returns nil without a pool or when it is empty
+func (queryMessagePlus *QueryMessagePlus) precomputed() *responseRandomness {
+	if queryMessagePlus.precompute == nil {
+		return nil
+	}
+	return queryMessagePlus.precompute.take()
+}
 
-	if sk.DecryptAndCheck0(pk.Bytes2Ciphertext(responseMessage.Z1, reqPara.PointCompression)) {
-		pt := sk.Decrypt(pk.Bytes2Ciphertext(responseMessage.Z2, reqPara.PointCompression))
-		if bf.Test(elgamal.HashSha256([]byte(pt)))  {
-			return true, pt
-		} else {
-			return false, []byte("Responder is cheating!")
+// This function decrypts a response and tells whether it is Negative,
+// Positive or shows ResponderMisbehavior; see Result
+func ResponseDecrypt(pk *elgamal.PublicKey, sk *elgamal.SecretKey, reqPara *ReqPara, responseMessage *ResponseMessage, bf *bloom.BloomFilter) *Result {
+	// the local decryptor never fails
+	result, _ := ResponseDecryptWith(elgamal.NewLocalDecryptor(sk), reqPara, responseMessage, bf)
+	return result
+}
+
+// This function works as ResponseDecrypt but leaves every secret-key operation
+// to a Decryptor, e.g. a keyserver.Client talking to a key daemon. The error
+// is only set when the decryptor itself fails; a cheating responder is
+// reported as a ResponderMisbehavior result.
+func ResponseDecryptWith(d elgamal.Decryptor, reqPara *ReqPara, responseMessage *ResponseMessage, bf *bloom.BloomFilter) (*Result, error) {
+	return ResponseDecryptWithContext(context.Background(), d, reqPara, responseMessage, bf)
+}
+
+// This function works as ResponseDecryptWith, but checks ctx before each call
+// to the decryptor and returns ctx.Err() once it is done
+func ResponseDecryptWithContext(ctx context.Context, d elgamal.Decryptor, reqPara *ReqPara, responseMessage *ResponseMessage, bf *bloom.BloomFilter) (*Result, error) {
+
+	pk := d.PublicKey()
+	membershipOnly := responseMessage.Z2 == nil && len(responseMessage.Payload) == 0
+	if reqPara.ResponseMode == MembershipOnly && !membershipOnly {
+		// the target does not look at what it did not ask for
+		return misbehaviorResult("response reveals more than the MembershipOnly mode of the query"), nil
+	}
+
+	z1, err := pk.Bytes2Ciphertext(responseMessage.Z1, reqPara.PointCompression)
+	if err != nil {
+		return misbehaviorResult("Z1: " + err.Error()), nil
+	}
+	var z2 *elgamal.Ciphertext
+	if !membershipOnly {
+		z2, err = pk.Bytes2Ciphertext(responseMessage.Z2, reqPara.PointCompression)
+		if err != nil {
+			return misbehaviorResult("Z2: " + err.Error()), nil
 		}
-		
-	} else {
-		return false, []byte("")
 	}
-	
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	isZero, err := d.DecryptAndCheck0(z1)
+	if err != nil {
+		return nil, err
+	}
+	if !isZero {
+		return negativeResult(), nil
+	}
+	if membershipOnly {
+		// a monitor may always reveal less than the query asks for
+		return membershipResult(), nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(responseMessage.Payload) > 0 {
+		key, err := d.DecapsulateKey(z2)
+		if err != nil {
+			return nil, err
+		}
+		return revealRecord(key, responseMessage.Payload, bf), nil
+	}
+
+	pt, err := d.Decrypt(z2)
+	if err != nil {
+		return nil, err
+	}
+	return matchResult(bf, string(pt), pt, nil), nil
+}
+
+// This function opens the payload of a hybrid response whose Z1 tested zero
+// with the key decapsulated from Z2 and checks the reveal record it holds
+func revealRecord(key []byte, payload []byte, bf *bloom.BloomFilter) *Result {
+
+	recordJson, err := elgamal.OpenPayload(key, payload)
+	if err != nil {
+		return misbehaviorResult("payload does not open with the key in Z2: " + err.Error())
+	}
+
+	record, err := DecodeRevealRecord(recordJson)
+	if err != nil {
+		result := misbehaviorResult("payload is not a reveal record: " + err.Error())
+		result.Revealed = recordJson
+		return result
+	}
+	return matchResult(bf, record.Password, recordJson, record)
+}
+
+// This function decodes the revealed bytes of a hybrid response, as also
+// found in Result.Record.
+func DecodeRevealRecord(result []byte) (*RevealRecord, error) {
+	var record RevealRecord
+	if err := json.Unmarshal(result, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
 }
 
 
@@ -299,28 +591,64 @@ func EncodeQuery(queryMessage *QueryMessage) []byte {
 }
 
 
-// This function decodes a query message in bytes back to struct.
-func DecodeQuery(queryMessageBytes []byte) *QueryMessage {
+// This function decodes a query message in bytes back to struct. It never
+// panics on malformed input; it checks the public key and the shape of the
+// query, while the ciphertexts and proofs are validated by RespDeployment.
+func DecodeQuery(queryMessageBytes []byte) (*QueryMessage, error) {
 
 	var queryMessage QueryMessage
 
-	r, err := gzip.NewReader(bytes.NewBuffer(queryMessageBytes))
+	jsonQM, err := gunzipMessage(queryMessageBytes)
 	if err != nil {
-		panic(err)
-	}
-	jsonQM, err := ioutil.ReadAll(r)
-	err = r.Close()
-	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	err = json.Unmarshal(jsonQM, &queryMessage)
-	err = r.Close()
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	if err := checkQuery(&queryMessage); err != nil {
+		return nil, err
 	}
+	return &queryMessage, nil
+}
+
+func checkQuery(queryMessage *QueryMessage) error {
 
-	return &queryMessage
+	if queryMessage.PK == nil {
+		return errors.New("query without public key")
+	}
+	if err := queryMessage.PK.InitCurve(); err != nil {
+		return err
+	}
+	if queryMessage.BfLength <= 0 {
+		return errors.New("invalid Bloom filter length")
+	}
+	if queryMessage.BfNumOnes < 0 || queryMessage.BfNumOnes > queryMessage.BfLength {
+		return errors.New("invalid number of ones")
+	}
+	if queryMessage.NumHashFuncs <= 0 || queryMessage.NumHashFuncs > queryMessage.BfLength {
+		return errors.New("invalid number of hash functions")
+	}
+	if queryMessage.NumThreads <= 0 || queryMessage.NumThreads > queryMessage.BfLength {
+		return errors.New("invalid number of threads")
+	}
+	if queryMessage.ResponseMode != RevealPassword && queryMessage.ResponseMode != MembershipOnly {
+		return errors.New("unknown response mode")
+	}
+	if len(queryMessage.EBF) != queryMessage.BfLength || len(queryMessage.ZKPs) != queryMessage.BfLength {
+		return errors.New("query length does not match the Bloom filter length")
+	}
+	for i := range queryMessage.EBF {
+		if queryMessage.EBF[i] == nil || queryMessage.ZKPs[i] == nil {
+			return errors.New("query with missing ciphertext or proof")
+		}
+	}
+	if _, err := queryMessage.PK.DecodeScalar(queryMessage.Challenge); err != nil {
+		return err
+	}
+	return nil
 }
 
 
@@ -341,25 +669,56 @@ func EncodeResponse(responseMessage *ResponseMessage) []byte {
 	return msg
 }
 
-// This function decodes a response message in bytes to struct.
-func DecodeResponse(responseMessageBytes []byte) *ResponseMessage {
+// This function decodes a response message in bytes to struct. It never
+// panics on malformed input; the ciphertexts are validated by ResponseDecrypt.
+func DecodeResponse(responseMessageBytes []byte) (*ResponseMessage, error) {
 
 	var responseMessage ResponseMessage
 
-	r, err := gzip.NewReader(bytes.NewBuffer(responseMessageBytes))
+	jsonRM, err := gunzipMessage(responseMessageBytes)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	jsonRM, err := ioutil.ReadAll(r)
-	err = r.Close()
+
+	err = json.Unmarshal(jsonRM, &responseMessage)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	err = json.Unmarshal(jsonRM, &responseMessage)
+	if err := checkResponse(&responseMessage); err != nil {
+		return nil, err
+	}
+	return &responseMessage, nil
+}
+
+// This function checks that a decoded response has the ciphertexts
+// ResponseDecrypt needs. A membership-only response has no Z2 and no payload.
+func checkResponse(responseMessage *ResponseMessage) error {
+	if responseMessage.Z1 == nil || (responseMessage.Z2 == nil && len(responseMessage.Payload) > 0) {
+		return errors.New("response with missing ciphertext")
+	}
+	return nil
+}
+
+// Decoded messages larger than this are rejected rather than decompressed
+const MaxMessageSize = 64 << 20
+
+// This function decompresses a message, refusing to inflate it beyond
+// MaxMessageSize
+func gunzipMessage(msg []byte) ([]byte, error) {
+
+	r, err := gzip.NewReader(bytes.NewReader(msg))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	defer r.Close()
 
-	return &responseMessage
-}
\ No newline at end of file
+	out, err := ioutil.ReadAll(io.LimitReader(r, MaxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > MaxMessageSize {
+		return nil, errors.New("message too large")
+	}
+	return out, nil
+}
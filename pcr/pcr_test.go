@@ -0,0 +1,105 @@
+package pcr
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	elgamal "bhwmonitoring-go/elgamal"
+)
+
+// The Bloom filter lengths evaluated in the paper, with the defaults of
+// performance.go for the other parameters
+var benchBFLengths = []int{128, 256}
+
+const (
+	benchBFNumOnes    = 30
+	benchNumHashFuncs = 20
+)
+
+// The curves with fixed-base tables, see elgamal.SetPrecomputation
+var fixedBaseCurves = []string{"P-224", "P-256", "P-384", "P-521"}
+
+// This function generates a query for "Simba" and deploys it under a key
+// decoded from the wire, with the fixed-base tables forced on or off
+func benchDeployedQuery(b *testing.B, group string, bfLength int, fixedBase bool) (*elgamal.SecretKey, *QueryMessagePlus) {
+	b.Helper()
+
+	pk, sk, reqPara := ReqInitGroup(group, bfLength, benchBFNumOnes, benchNumHashFuncs, 1, true)
+	bf := ReqBFGen(pk, reqPara, "Simba")
+	queryMessage, err := DecodeQuery(EncodeQuery(QueryGen(pk, reqPara, bf)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	queryMessage.PK.SetPrecomputation(fixedBase)
+	return sk, RespDeployment(queryMessage)
+}
+
+// Run with -benchtime=Nx on the larger curves; deploying a P-521 query takes
+// seconds
+func BenchmarkResponseGen(b *testing.B) {
+	for _, group := range fixedBaseCurves {
+		for _, bfLength := range benchBFLengths {
+			for _, fixedBase := range []bool{false, true} {
+				mode := "off"
+				if fixedBase {
+					mode = "on"
+				}
+				b.Run(fmt.Sprintf("%s/BFLength=%d/fixedBase=%s", group, bfLength, mode), func(b *testing.B) {
+					sk, queryMessagePlus := benchDeployedQuery(b, group, bfLength, fixedBase)
+					ResponseGen(sk, queryMessagePlus, "Simba") // warms the entry cache and the tables
+
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						ResponseGen(sk, queryMessagePlus, "Simba")
+					}
+				})
+			}
+		}
+	}
+}
+
+// The target opens the payload of a hybrid response on a match and recovers
+// the whole reveal record, and learns nothing on a miss
+func TestResponseGenHybridRoundTrip(t *testing.T) {
+
+	fx := decodeFixture(t)
+	want := &RevealRecord{Password: "Simba", Timestamp: 1700000000, Source: "login"}
+
+	for _, c := range []struct {
+		record  *RevealRecord
+		outcome Outcome
+	}{
+		{want, Positive},
+		{&RevealRecord{Password: "Nala", Timestamp: 1700000000, Source: "login"}, Negative},
+	} {
+		responseMessage, err := ResponseGenHybridContext(context.Background(), fx.sk, fx.queryMessagePlus, c.record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rcvResponse, err := DecodeResponse(EncodeResponse(responseMessage))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := ResponseDecrypt(fx.pk, fx.sk, fx.reqPara, rcvResponse, fx.bf)
+		if result.Outcome != c.outcome {
+			t.Fatalf("response for %s was %v: %s", c.record.Password, result.Outcome, result.Details)
+		}
+		if c.outcome == Negative {
+			if result.Record != nil || result.Revealed != nil {
+				t.Fatal("a negative response revealed the record")
+			}
+			continue
+		}
+		if result.Record == nil || *result.Record != *want {
+			t.Fatalf("revealed record %+v, want %+v", result.Record, want)
+		}
+		if len(result.Positions) != fx.reqPara.NumHashFuncs {
+			t.Fatalf("revealed password sets %d Bloom positions", len(result.Positions))
+		}
+	}
+
+	if _, err := ResponseGenHybridContext(context.Background(), fx.sk, fx.queryMessagePlus, nil); err == nil {
+		t.Fatal("responded without a reveal record")
+	}
+}
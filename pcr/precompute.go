@@ -0,0 +1,198 @@
+package pcr
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	elgamal "bhwmonitoring-go/elgamal"
+)
+
+// PrecomputeConfig sizes the precomputation pool of a deployed query
+type PrecomputeConfig struct {
+	Depth  int  // responses kept ready; at least 1
+	Hybrid bool // also precompute the KEM ciphertexts of ResponseGenHybrid
+}
+
+// PrecomputeStats reports how well a precomputation pool keeps up with the
+// responses drawn from it
+type PrecomputeStats struct {
+	Depth    int
+	Ready    int           // responses currently ready
+	Produced uint64        // responses computed in the background so far
+	Hits     uint64        // responses served from the pool
+	Misses   uint64        // responses that found the pool empty and computed their randomness online
+	FillTime time.Duration // total time spent computing the produced responses
+}
+
+// PrecomputePool computes, in the background, everything a response to one
+// deployed query needs that does not depend on the submitted password: the
+// randomizers of the matched positions, the encryptions of -1 and zero, the
+// randomized C1 and, for hybrid responses, the KEM ciphertext and key. A
+// response drawn from the pool is left with decoding the k EBF entries, one
+// multi-scalar multiplication and the final randomization.
+type PrecomputePool struct {
+	// updated atomically; first in the struct to stay 64-bit aligned
+	produced uint64
+	hits     uint64
+	misses   uint64
+	fillTime int64
+
+	queryMessagePlus *QueryMessagePlus
+	config           PrecomputeConfig
+	ready            chan *responseRandomness
+	quit             chan struct{}
+	once             sync.Once
+	done             sync.WaitGroup
+}
+
+// The randomness of one response. rs, negOne, zero and c1 are used by
+// responseBase, mask by ResponseGen and kem and key by ResponseGenHybrid.
+type responseRandomness struct {
+	rs     []*big.Int
+	negOne *elgamal.PreparedCiphertext
+	zero   *elgamal.Ciphertext
+	c1     *elgamal.Ciphertext
+	mask   *elgamal.Ciphertext
+	kem    *elgamal.Ciphertext
+	key    []byte
+}
+
+// This function starts a pool that keeps config.Depth responses to the
+// deployed query precomputed, and makes ResponseGen and ResponseGenHybrid draw
+// from it. It replaces and stops an earlier pool of the query. Call it after
+// RespDeployment and before serving responses; Stop it when the query is
+// dropped.
+func (queryMessagePlus *QueryMessagePlus) Precompute(config PrecomputeConfig) *PrecomputePool {
+
+	if config.Depth < 1 {
+		config.Depth = 1
+	}
+	if queryMessagePlus.precompute != nil {
+		queryMessagePlus.precompute.Stop()
+	}
+
+	p := &PrecomputePool{
+		queryMessagePlus: queryMessagePlus,
+		config:           config,
+		ready:            make(chan *responseRandomness, config.Depth),
+		quit:             make(chan struct{}),
+	}
+	p.done.Add(1)
+	go p.fill()
+
+	queryMessagePlus.precompute = p
+	return p
+}
+
+// This function refills the pool whenever a response is drawn from it
+func (p *PrecomputePool) fill() {
+	defer p.done.Done()
+
+	c1, err := p.queryMessagePlus.decodedC1()
+	if err != nil {
+		// responses fail on the same C1, so there is nothing to precompute
+		return
+	}
+
+	for {
+		start := time.Now()
+		r := p.newRandomness(c1)
+		atomic.AddInt64(&p.fillTime, int64(time.Since(start)))
+
+		select {
+		case p.ready <- r:
+			atomic.AddUint64(&p.produced, 1)
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *PrecomputePool) newRandomness(c1 *elgamal.Ciphertext) *responseRandomness {
+
+	pk := p.queryMessagePlus.PK
+
+	r := &responseRandomness{}
+	// a MembershipOnly response has no Z2 to mask
+	if p.queryMessagePlus.ResponseMode != MembershipOnly {
+		r.mask = pk.Encrypt(big.NewInt(0))
+		if p.config.Hybrid {
+			r.kem, r.key = pk.EncapsulateKey()
+		}
+	}
+	// a password sets at most NumHashFuncs positions
+	fillResponseRandomness(p.queryMessagePlus, r, c1, p.queryMessagePlus.NumHashFuncs)
+	return r
+}
+
+// This function draws the randomness of responseBase for numPositions matched
+// positions, in the order of the known-answer vectors: a randomizer r_i per
+// position, the encryption randomness of -1, that of zero and the randomizer
+// of C1. The terms r_i·Enc(-1) share one encryption of -1 weighted by Σ r_i;
+// the encryption of zero keeps the sum uniformly random even when Σ r_i = 0.
+func fillResponseRandomness(queryMessagePlus *QueryMessagePlus, r *responseRandomness, c1 *elgamal.Ciphertext, numPositions int) {
+
+	pk := queryMessagePlus.PK
+
+	r.rs = make([]*big.Int, numPositions)
+	for i := range r.rs {
+		r.rs[i] = pk.RandomScalar()
+	}
+	r.negOne = queryMessagePlus.prepare(pk.EncryptWithRandomness(big.NewInt(-1), pk.RandomScalar()))
+	r.zero = pk.EncryptWithRandomness(big.NewInt(0), pk.RandomScalar())
+	r.c1 = pk.ScalarMultRandomizer(c1, false)
+}
+
+// This function returns a precomputed response randomness, or nil and counts
+// a miss if none is ready
+func (p *PrecomputePool) take() *responseRandomness {
+	select {
+	case r := <-p.ready:
+		atomic.AddUint64(&p.hits, 1)
+		return r
+	default:
+		atomic.AddUint64(&p.misses, 1)
+		return nil
+	}
+}
+
+// This function blocks until the pool holds Depth responses, the pool is
+// stopped or ctx is done, e.g. to let a freshly deployed query warm up
+func (p *PrecomputePool) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for len(p.ready) < p.config.Depth {
+		select {
+		case <-ticker.C:
+		case <-p.quit:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// This function returns the current metrics of the pool
+func (p *PrecomputePool) Stats() PrecomputeStats {
+	return PrecomputeStats{
+		Depth:    p.config.Depth,
+		Ready:    len(p.ready),
+		Produced: atomic.LoadUint64(&p.produced),
+		Hits:     atomic.LoadUint64(&p.hits),
+		Misses:   atomic.LoadUint64(&p.misses),
+		FillTime: time.Duration(atomic.LoadInt64(&p.fillTime)),
+	}
+}
+
+// This function stops the background work and waits for it to return.
+// Responses still ready in the pool keep being served.
+func (p *PrecomputePool) Stop() {
+	p.once.Do(func() {
+		close(p.quit)
+	})
+	p.done.Wait()
+}
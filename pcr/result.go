@@ -0,0 +1,108 @@
+package pcr
+
+import (
+	"fmt"
+
+	bloom "bhwmonitoring-go/bloom"
+	elgamal "bhwmonitoring-go/elgamal"
+)
+
+// Outcome tells the three possible results of decrypting a response apart
+type Outcome int
+
+const (
+	// Z1 does not decrypt to zero: the submitted password is not in the
+	// target's Bloom filter, and nothing is revealed
+	Negative Outcome = iota
+	// Z1 decrypts to zero and Z2 reveals a password whose positions are all
+	// set in the target's Bloom filter, or Z2 is absent in a membership-only
+	// response
+	Positive
+	// the response is malformed, or it claims a match for a password that is
+	// not in the target's Bloom filter
+	ResponderMisbehavior
+)
+
+var outcomeNames = []string{"Negative", "Positive", "ResponderMisbehavior"}
+
+func (o Outcome) String() string {
+	if o < 0 || int(o) >= len(outcomeNames) {
+		return fmt.Sprintf("Outcome(%d)", int(o))
+	}
+	return outcomeNames[o]
+}
+
+// This function encodes the outcome by name, which makes it readable in JSON
+func (o Outcome) MarshalText() ([]byte, error) {
+	if o < 0 || int(o) >= len(outcomeNames) {
+		return nil, fmt.Errorf("unknown outcome %d", int(o))
+	}
+	return []byte(outcomeNames[o]), nil
+}
+
+func (o *Outcome) UnmarshalText(text []byte) error {
+	for i, name := range outcomeNames {
+		if string(text) == name {
+			*o = Outcome(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown outcome %q", text)
+}
+
+// Result is what the target learns from a response. It encodes to JSON as
+// is, e.g. to be handed to an alerting pipeline.
+type Result struct {
+	Outcome Outcome
+	// the revealed password, or the JSON-encoded reveal record of a hybrid
+	// response; nil for a membership-only response; also set for
+	// ResponderMisbehavior when a claimed match revealed something
+	Revealed []byte `json:",omitempty"`
+	// the decoded reveal record of a hybrid response
+	Record *RevealRecord `json:",omitempty"`
+	// the Bloom positions of the revealed password that are set in the
+	// target's filter, in ascending order; all of them for a Positive result
+	// that revealed a password
+	Positions []uint `json:",omitempty"`
+	// why the result is what it is, for logs and alerts
+	Details string `json:",omitempty"`
+}
+
+// This function reports whether the submitted password matched
+func (result *Result) Positive() bool {
+	return result.Outcome == Positive
+}
+
+func negativeResult() *Result {
+	return &Result{Outcome: Negative, Details: "Z1 does not decrypt to zero"}
+}
+
+func membershipResult() *Result {
+	return &Result{Outcome: Positive, Details: "Z1 decrypts to zero; membership-only response reveals no password"}
+}
+
+func misbehaviorResult(details string) *Result {
+	return &Result{Outcome: ResponderMisbehavior, Details: details}
+}
+
+// This function checks a password revealed by a response against the target's
+// Bloom filter and returns a Positive result if all of its positions are set
+func matchResult(bf *bloom.BloomFilter, pwd string, revealed []byte, record *RevealRecord) *Result {
+
+	result := &Result{Revealed: revealed, Record: record}
+	locs := bf.Locations(elgamal.HashSha256([]byte(pwd)))
+	for _, loc := range locs {
+		if bf.BitSet().Test(loc) {
+			result.Positions = append(result.Positions, loc)
+		}
+	}
+
+	if len(result.Positions) < len(locs) {
+		result.Outcome = ResponderMisbehavior
+		result.Details = fmt.Sprintf("revealed password sets %d of its %d Bloom positions", len(result.Positions), len(locs))
+		return result
+	}
+	result.Outcome = Positive
+	result.Details = fmt.Sprintf("all %d Bloom positions of the revealed password are set", len(locs))
+	return result
+}
@@ -0,0 +1,223 @@
+package pcr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	bloom "bhwmonitoring-go/bloom"
+	elgamal "bhwmonitoring-go/elgamal"
+)
+
+func TestOutcomeText(t *testing.T) {
+
+	for _, c := range []struct {
+		outcome Outcome
+		name    string
+	}{
+		{Negative, "Negative"},
+		{Positive, "Positive"},
+		{ResponderMisbehavior, "ResponderMisbehavior"},
+	} {
+		text, err := c.outcome.MarshalText()
+		if err != nil || string(text) != c.name || c.outcome.String() != c.name {
+			t.Fatalf("%d encodes to %q (%v)", int(c.outcome), text, err)
+		}
+		var o Outcome
+		if err := o.UnmarshalText([]byte(c.name)); err != nil || o != c.outcome {
+			t.Fatalf("%q decodes to %v (%v)", c.name, o, err)
+		}
+	}
+
+	for _, o := range []Outcome{-1, ResponderMisbehavior + 1} {
+		if _, err := o.MarshalText(); err == nil {
+			t.Errorf("encoded unknown outcome %d", int(o))
+		}
+		if want := fmt.Sprintf("Outcome(%d)", int(o)); o.String() != want {
+			t.Errorf("unknown outcome prints as %s, want %s", o.String(), want)
+		}
+	}
+	for _, text := range []string{"", "positive", "Positive ", "1"} {
+		o := Positive
+		if err := o.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("decoded %q", text)
+		}
+	}
+
+	// a Result goes to JSON with the outcome by name
+	in := &Result{Outcome: Positive, Revealed: []byte("Simba"), Positions: []uint{3, 17}, Details: "match"}
+	b, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"Outcome":"Positive"`) {
+		t.Fatalf("result encodes as %s", b)
+	}
+	var out Result
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Outcome != in.Outcome || string(out.Revealed) != "Simba" || len(out.Positions) != 2 || out.Details != in.Details {
+		t.Fatalf("result decodes to %+v", out)
+	}
+	if err := json.Unmarshal([]byte(`{"Outcome":"Maybe"}`), &out); err == nil {
+		t.Fatal("decoded an unknown outcome")
+	}
+}
+
+func TestMatchResult(t *testing.T) {
+
+	const numHashFuncs = 4
+	bf := bloom.New(64, numHashFuncs)
+	bf.Add(elgamal.HashSha256([]byte("Simba")))
+	other := otherPassword(t, bf)
+
+	// the positions of other that are set in Simba's filter
+	locs := bf.Locations(elgamal.HashSha256([]byte(other)))
+	var set []uint
+	for _, loc := range locs {
+		if bf.BitSet().Test(loc) {
+			set = append(set, loc)
+		}
+	}
+
+	record := &RevealRecord{Password: "Simba"}
+	for _, c := range []struct {
+		pwd       string
+		record    *RevealRecord
+		outcome   Outcome
+		positions int
+	}{
+		{"Simba", nil, Positive, numHashFuncs},
+		{"Simba", record, Positive, numHashFuncs},
+		{other, nil, ResponderMisbehavior, len(set)},
+	} {
+		result := matchResult(bf, c.pwd, []byte(c.pwd), c.record)
+		if result.Outcome != c.outcome || len(result.Positions) != c.positions {
+			t.Fatalf("%s: %v with %d positions, want %v with %d", c.pwd, result.Outcome, len(result.Positions), c.outcome, c.positions)
+		}
+		if string(result.Revealed) != c.pwd || result.Record != c.record || result.Details == "" {
+			t.Fatalf("%s: result %+v", c.pwd, result)
+		}
+		for i := 1; i < len(result.Positions); i++ {
+			if result.Positions[i] < result.Positions[i-1] {
+				t.Fatalf("%s: positions %v out of order", c.pwd, result.Positions)
+			}
+		}
+	}
+}
+
+// This function returns a password that is not in bf, so that a response
+// revealing it claims a match it cannot have
+func otherPassword(t *testing.T, bf *bloom.BloomFilter) string {
+	t.Helper()
+	for _, pwd := range []string{"Nala", "Mufasa", "Scar", "Rafiki", "Timon", "Pumbaa"} {
+		if !bf.Test(elgamal.HashSha256([]byte(pwd))) {
+			return pwd
+		}
+	}
+	t.Fatal("every candidate password is in the filter")
+	return ""
+}
+
+// Every way a response can fail to be an honest match must come out as
+// Negative or ResponderMisbehavior, and never reveal a password as Positive
+func TestResponseDecryptOutcomes(t *testing.T) {
+
+	fx := decodeFixture(t)
+	ctx := context.Background()
+	pk, d := fx.pk, elgamal.NewLocalDecryptor(fx.sk)
+	compress := fx.reqPara.PointCompression
+	other := otherPassword(t, fx.bf)
+
+	respond := func(pwd string) *ResponseMessage {
+		t.Helper()
+		responseMessage, err := ResponseGenContext(ctx, fx.sk, fx.queryMessagePlus, pwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return responseMessage
+	}
+	respondHybrid := func(pwd string) *ResponseMessage {
+		t.Helper()
+		responseMessage, err := ResponseGenHybridContext(ctx, fx.sk, fx.queryMessagePlus, &RevealRecord{Password: pwd, Source: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return responseMessage
+	}
+	zero := func() *elgamal.CiphertextByte {
+		return pk.Ciphertext2Bytes(pk.Encrypt(big.NewInt(0)), compress)
+	}
+	// a Z1 that tests zero and a KEM ciphertext whose key seals payload
+	sealed := func(payload []byte) *ResponseMessage {
+		t.Helper()
+		kem, key := pk.EncapsulateKey()
+		sealedPayload, err := elgamal.SealPayload(key, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &ResponseMessage{zero(), pk.Ciphertext2Bytes(kem, compress), sealedPayload}
+	}
+	otherRecord, err := json.Marshal(&RevealRecord{Password: other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	membershipOnly := *fx.reqPara
+	membershipOnly.ResponseMode = MembershipOnly
+
+	for _, c := range []struct {
+		name     string
+		reqPara  *ReqPara
+		response *ResponseMessage
+		outcome  Outcome
+		revealed string
+		details  string
+	}{
+		{"match", fx.reqPara, respond("Simba"), Positive, "Simba", ""},
+		{"no match", fx.reqPara, respond(other), Negative, "", ""},
+		{"hybrid match", fx.reqPara, respondHybrid("Simba"), Positive, "", ""},
+		{"hybrid no match", fx.reqPara, respondHybrid(other), Negative, "", ""},
+		{"less than asked", fx.reqPara, &ResponseMessage{Z1: respond("Simba").Z1}, Positive, "", "membership-only"},
+		{"less than asked, no match", fx.reqPara, &ResponseMessage{Z1: respond(other).Z1}, Negative, "", ""},
+		{"more than asked", &membershipOnly, respond("Simba"), ResponderMisbehavior, "", "MembershipOnly"},
+		{"payload when asked for membership", &membershipOnly, &ResponseMessage{Z1: respond("Simba").Z1, Payload: []byte{1}}, ResponderMisbehavior, "", "MembershipOnly"},
+		{"Z1 malformed", fx.reqPara, &ResponseMessage{&elgamal.CiphertextByte{C1: []byte{2, 1}, C2: []byte{2, 1}}, respond("Simba").Z2, nil}, ResponderMisbehavior, "", "Z1:"},
+		{"Z2 malformed", fx.reqPara, &ResponseMessage{respond("Simba").Z1, &elgamal.CiphertextByte{C1: []byte{2, 1}, C2: []byte{2, 1}}, nil}, ResponderMisbehavior, "", "Z2:"},
+		{"match claimed for another password", fx.reqPara, &ResponseMessage{Z1: zero(), Z2: pk.Ciphertext2Bytes(pk.EncryptMul([]byte(other)), compress)}, ResponderMisbehavior, other, "Bloom positions"},
+		{"payload tampered", fx.reqPara, func() *ResponseMessage {
+			r := respondHybrid("Simba")
+			r.Payload[len(r.Payload)-1] ^= 1
+			return r
+		}(), ResponderMisbehavior, "", "does not open"},
+		{"payload not a record", fx.reqPara, sealed([]byte("Simba")), ResponderMisbehavior, "Simba", "not a reveal record"},
+		{"record of another password", fx.reqPara, sealed(otherRecord), ResponderMisbehavior, string(otherRecord), "Bloom positions"},
+	} {
+		result, err := ResponseDecryptWithContext(ctx, d, c.reqPara, c.response, fx.bf)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if result.Outcome != c.outcome {
+			t.Errorf("%s: %v (%s), want %v", c.name, result.Outcome, result.Details, c.outcome)
+			continue
+		}
+		if c.revealed != "" && string(result.Revealed) != c.revealed {
+			t.Errorf("%s: revealed %q, want %q", c.name, result.Revealed, c.revealed)
+		}
+		if c.outcome == Negative && (result.Revealed != nil || result.Record != nil || result.Positions != nil) {
+			t.Errorf("%s: a negative result revealed %+v", c.name, result)
+		}
+		if !strings.Contains(result.Details, c.details) {
+			t.Errorf("%s: details %q do not mention %q", c.name, result.Details, c.details)
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := ResponseDecryptWithContext(cancelled, d, fx.reqPara, respond("Simba"), fx.bf); err != context.Canceled {
+		t.Fatalf("decrypting with a done context returned %v", err)
+	}
+}
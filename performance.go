@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"runtime"
+	"time"
+	auth "bhwmonitoring-go/auth"
+	elgamal "bhwmonitoring-go/elgamal"
 	pcr "bhwmonitoring-go/pcr"
 	util "bhwmonitoring-go/util"
+	workpool "bhwmonitoring-go/workpool"
 )
 
 func main() {
@@ -14,14 +19,17 @@ func main() {
 
 	var bfLength, bfNumOfOnes, numHashFuncs int
 	var numThreads, params int
-	var pointCompression bool
-	var pwd2check string
-	var maxRounds int
+	var pointCompression, hybrid, membershipOnly, authenticate bool
+	var pwd2check, fixedBase, group, threshold string
+	var maxRounds, precomputeDepth int
 
 	var allResponderDeploymentTime, allQueryGenTime, allResponseGenTime, allResponseRevealTime []int64
 	var allQuerySize, allResponseSize []int
+	var allRevealModeResponseGenTime, allRevealModeResponseRevealTime []int64
+	var allRevealModeResponseSize []int
 
-	paramPtr := flag.Int("keyLength", 256, "224, 256, 384 or 512")
+	paramPtr := flag.Int("keyLength", 256, "224, 256, 384 or 521")
+	groupPtr := flag.String("group", "", "P-224, P-256, P-384, P-521 or ristretto255 (overrides keyLength)")
 	bfLengthPtr := flag.Int("BFLength", 128, "an int")
 	bfNumOfOnesPtr := flag.Int("numOnes", 30, "an int")
 	numHashFuncsPtr := flag.Int("numHFs", 20, "an int")
@@ -29,6 +37,12 @@ func main() {
 	pointCompressionPtr := flag.Bool("enablePC", true, "true or false")
 	roundsPtr := flag.Int("numRounds", 50, "an int")
 	pwd2checkPtr := flag.String("monitorInput", "Simba", "a string")
+	hybridPtr := flag.Bool("hybrid", false, "true or false")
+	fixedBasePtr := flag.String("fixedBase", "auto", "auto, on or off")
+	thresholdPtr := flag.String("threshold", "", "t/n to split the target key among n simulated parties")
+	membershipOnlyPtr := flag.Bool("membershipOnly", false, "true or false; queries ask for the Z1 zero-test alone")
+	authPtr := flag.Bool("auth", false, "true or false; queries and responses are signed with ECDSA identity keys")
+	precomputePtr := flag.Int("precompute", 0, "responses the monitor precomputes per deployed query (0 disables)")
 
 	flag.Parse()
 
@@ -40,18 +54,57 @@ func main() {
 	pointCompression = *pointCompressionPtr
 	pwd2check = *pwd2checkPtr
 	maxRounds = *roundsPtr
+	hybrid = *hybridPtr
+	fixedBase = *fixedBasePtr
+	group = *groupPtr
+	threshold = *thresholdPtr
+	precomputeDepth = *precomputePtr
+	membershipOnly = *membershipOnlyPtr
+	authenticate = *authPtr
+
+	if group == "" {
+		g, err := elgamal.GroupBySecParam(params)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		group = g.Name()
+	}
 
 	if runtime.NumCPU() <=numThreads {
 		numThreads = runtime.NumCPU()
 	}
 
 	fmt.Printf("\n==== Experiment Parameters ========\n[OS] # of threads >>> %d/%d\n", numThreads, runtime.NumCPU())
-	fmt.Println("[ECC-ElGamal] key length (bits) >>>", params)
+	fmt.Println("[ECC-ElGamal] group >>>", group)
 	fmt.Println("[ECC-ElGamal] Point compression >>>", pointCompression)
+	fmt.Println("[ECC-ElGamal] Hybrid reveal >>>", hybrid)
+	fmt.Println("[ECC-ElGamal] Membership-only responses >>>", membershipOnly)
+	fmt.Println("[ECC-ElGamal] Fixed-base tables >>>", fixedBase)
+	fmt.Println("[ECDSA] Message authentication >>>", authenticate)
+	if threshold != "" {
+		fmt.Println("[ECC-ElGamal] Threshold key >>>", threshold)
+	}
+	if precomputeDepth > 0 {
+		fmt.Println("[Monitor] Precomputed responses >>>", precomputeDepth)
+	}
 	fmt.Printf("[Target] Bloom filter length >>> %d\n", bfLength)
 	fmt.Printf("[Target] # of hash functions >>> %d\n", numHashFuncs)
 	fmt.Printf("[Target] # of ones in a Bloom filter >>> %d\n", bfNumOfOnes)
 
+	pool := workpool.New(numThreads) // One executor shared by both parties and all rounds
+	defer pool.Close()
+	ctx := workpool.NewContext(context.Background(), pool) // Runs the protocol steps below on the pool
+
+	var targetID, monitorID *auth.Identity
+	var targetTrust, monitorTrust *auth.TrustStore
+	if authenticate {
+		// Long-term identities, generated once and trusted by the other party
+		targetID, targetTrust, monitorID, monitorTrust = identities()
+		if targetID == nil {
+			return
+		}
+	}
 
 	for i := 0; i < maxRounds; i++ {
 		//////////////////  PROTOCOL OFFLINE PHASE  /////////////////
@@ -60,53 +113,161 @@ func main() {
 		time0 := util.MakeTimestamp()
 
 		
-		pk, sk, reqData := pcr.ReqInit(params, bfLength, bfNumOfOnes, numHashFuncs, numThreads, pointCompression) // Key generation and parameter initialization
+		pk, sk, reqData := pcr.ReqInitGroup(group, bfLength, bfNumOfOnes, numHashFuncs, numThreads, pointCompression) // Key generation and parameter initialization
+		if membershipOnly {
+			reqData.ResponseMode = pcr.MembershipOnly // Asks the monitor for the zero-test alone
+		}
+		var decryptor elgamal.Decryptor = elgamal.NewLocalDecryptor(sk)
+		if threshold != "" {
+			pk, decryptor = thresholdKey(group, threshold, pointCompression) // Replaces the key with one split by a DKG
+			if pk == nil {
+				return
+			}
+		}
+		setFixedBase(pk, fixedBase)
 		bf := pcr.ReqBFGen(pk, reqData, "Simba")
 
 		//////////////////  PROTOCOL ONLINE PHASE  /////////////////
 
 		/*  Requester/Receiver Online Phase I: Query Generation */
 		
-		queryMessage := pcr.QueryGen(pk, reqData, bf) // Query generation based on input element
+		queryMessage, err := pcr.QueryGenContext(ctx, pk, reqData, bf) // Query generation based on input element
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
 		queryMessageBytes:= pcr.EncodeQuery(queryMessage) // Encodes query message into bytes
+		if authenticate {
+			signedQuery, err := targetID.Sign(auth.KindQuery, monitorID.ID, queryMessageBytes) // Signs the encoded query for the monitor
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			queryMessageBytes = signedQuery
+		}
 		queryMessageSize := len(queryMessageBytes) // Gets message size in bytes
 
 		time1 := util.MakeTimestamp()
 
 		/*    Responder/Sender Online Phase I: Response Generation  */
-		rcvQueryMessage := pcr.DecodeQuery(queryMessageBytes) // Decodes query message from bytes
-		rcvQueryMessagePlus := pcr.RespDeployment(rcvQueryMessage)
+		if authenticate {
+			signedQuery, err := monitorTrust.Verify(queryMessageBytes, auth.KindQuery, monitorID.ID) // Checks the query comes from a trusted target
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			queryMessageBytes = signedQuery.Payload
+		}
+		rcvQueryMessage, err := pcr.DecodeQuery(queryMessageBytes) // Decodes query message from bytes
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		setFixedBase(rcvQueryMessage.PK, fixedBase)
+		rcvQueryMessagePlus, err := pcr.RespDeploymentContext(ctx, rcvQueryMessage)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
 
 		time2 := util.MakeTimestamp()
 
-		responseMessage := pcr.ResponseGen(sk, rcvQueryMessagePlus, pwd2check) // Generates response based on query
+		responseStart := time2
+		var precomputePool *pcr.PrecomputePool
+		if precomputeDepth > 0 {
+			// Fills the pool in what would be idle time between deployment and the first check; not measured
+			precomputePool = rcvQueryMessagePlus.Precompute(pcr.PrecomputeConfig{Depth: precomputeDepth, Hybrid: hybrid})
+			precomputePool.Wait(context.Background())
+			responseStart = util.MakeTimestamp()
+		}
+
+		responseMessage, err := respond(ctx, sk, rcvQueryMessagePlus, pwd2check, hybrid) // Generates response based on query
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
 		responseMessageBytes := pcr.EncodeResponse(responseMessage) // Encodes response message to bytes
+		if authenticate {
+			responseMessageBytes, err = monitorID.Sign(auth.KindResponse, targetID.ID, responseMessageBytes) // Signs the encoded response for the target
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
 		responseMessageSize := len(responseMessageBytes) // gets response message size in bytes
 
 		time3 := util.MakeTimestamp()
+		if precomputePool != nil {
+			precomputePool.Stop()
+		}
 
 		/*  Requester/Receiver Online Phase II: Response Decryption */
-		rcvResponseMessage := pcr.DecodeResponse(responseMessageBytes) // Decodes response message from bytes
-		success, result := pcr.ResponseDecrypt(pk, sk, reqData, rcvResponseMessage, bf) // Decrypt response to get the result
+		if authenticate {
+			signedResponse, err := targetTrust.Verify(responseMessageBytes, auth.KindResponse, targetID.ID) // Checks the response comes from a trusted monitor
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			responseMessageBytes = signedResponse.Payload
+		}
+		rcvResponseMessage, err := pcr.DecodeResponse(responseMessageBytes) // Decodes response message from bytes
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		result, err := pcr.ResponseDecryptWith(decryptor, reqData, rcvResponseMessage, bf) // Decrypt response to get the result
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
 		
 		time4 := util.MakeTimestamp()
 
+		if membershipOnly {
+			// A reveal-mode response to the same query, not part of the timings above, to report what the zero-test alone saves
+			rcvQueryMessagePlus.ResponseMode = pcr.RevealPassword
+			if err := rcvQueryMessagePlus.Deploy(false); err != nil { // Starts from a cold entry cache, as the response above did
+				fmt.Println(err)
+				return
+			}
+			revealData := *reqData
+			revealData.ResponseMode = pcr.RevealPassword
+
+			time5 := util.MakeTimestamp()
+			revealModeResponse, err := respond(ctx, sk, rcvQueryMessagePlus, pwd2check, hybrid)
+			time6 := util.MakeTimestamp()
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			revealModeBytes := pcr.EncodeResponse(revealModeResponse)
+			rcvRevealMode, err := pcr.DecodeResponse(revealModeBytes)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			if _, err := pcr.ResponseDecryptWith(decryptor, &revealData, rcvRevealMode, bf); err != nil {
+				fmt.Println(err)
+				return
+			}
+			time7 := util.MakeTimestamp()
+
+			allRevealModeResponseGenTime = append(allRevealModeResponseGenTime, time6-time5)
+			allRevealModeResponseRevealTime = append(allRevealModeResponseRevealTime, time7-time6)
+			allRevealModeResponseSize = append(allRevealModeResponseSize, len(revealModeBytes))
+		}
+
 
 		////////////////////////////////////////////////////////
 
 		queryGenTime := time1 - time0
 		responderDeploymentTime := time2 - time1
-		responseGenTime  := time3 - time2
+		responseGenTime  := time3 - responseStart
 		responseRevealTime := time4 - time3
 
-		revealRes := ""
-		if success {
-			// revealRes = "Positive: " + string(result)
-			revealRes = "Positive"
-		} else if !success && string(result) == "" {
-			revealRes = "Negative"
-		} else {
-			revealRes = "Cheating Monitor alert!"
+		revealRes := result.Outcome.String()
+		if result.Outcome == pcr.ResponderMisbehavior {
+			revealRes = "Cheating Monitor alert! " + result.Details
 		}
 
 		// Report the revealing result for only the last run
@@ -131,4 +292,74 @@ func main() {
 	fmt.Printf("[Monitor] Response message size >>> %.2f KB (rstd: %.4f)\n", float32(util.GetAvgInt(allResponseSize)) / 1000.0, float32(util.GetRelativeStdInt(allResponseSize)))
 	fmt.Printf("[Target] responseReveal() takes %.2f ms (rstd: %.4f) \n", float32(util.GetAvgInt64(allResponseRevealTime))/1000.0, float32(util.GetRelativeStdInt64(allResponseRevealTime)))
 
-}
\ No newline at end of file
+	if membershipOnly {
+		revealModeSize := float32(util.GetAvgInt(allRevealModeResponseSize))
+		sizeSaving := revealModeSize - float32(util.GetAvgInt(allResponseSize))
+		fmt.Printf("==== Membership-only savings over reveal-mode responses ===\n")
+		fmt.Printf("[Monitor] Response message size saves %.2f KB of %.2f KB (%.1f%%)\n", sizeSaving/1000.0, revealModeSize/1000.0, 100*sizeSaving/revealModeSize)
+		fmt.Printf("[Monitor] responseGen() saves %.2f ms of %.2f ms\n", float32(util.GetAvgInt64(allRevealModeResponseGenTime)-util.GetAvgInt64(allResponseGenTime))/1000.0, float32(util.GetAvgInt64(allRevealModeResponseGenTime))/1000.0)
+		fmt.Printf("[Target] responseReveal() saves %.2f ms of %.2f ms\n", float32(util.GetAvgInt64(allRevealModeResponseRevealTime)-util.GetAvgInt64(allResponseRevealTime))/1000.0, float32(util.GetAvgInt64(allRevealModeResponseRevealTime))/1000.0)
+	}
+}
+
+// This function generates the monitor's response to a deployed query
+func respond(ctx context.Context, sk *elgamal.SecretKey, queryMessagePlus *pcr.QueryMessagePlus, pwd2check string, hybrid bool) (*pcr.ResponseMessage, error) {
+	if hybrid {
+		record := &pcr.RevealRecord{Password: pwd2check, Timestamp: time.Now().Unix(), Source: "performance.go"}
+		return pcr.ResponseGenHybridContext(ctx, sk, queryMessagePlus, record) // Generates response revealing the whole record
+	}
+	return pcr.ResponseGenContext(ctx, sk, queryMessagePlus, pwd2check)
+}
+
+// This function applies the -fixedBase flag to a public key
+func setFixedBase(pk *elgamal.PublicKey, mode string) {
+	if mode == "on" {
+		pk.SetPrecomputation(true)
+	} else if mode == "off" {
+		pk.SetPrecomputation(false)
+	}
+}
+
+// This function runs a simulated t-of-n DKG for the -threshold flag and
+// returns the joint key with a decryptor asking the first t parties
+func thresholdKey(groupName, spec string, pointCompression bool) (*elgamal.PublicKey, elgamal.Decryptor) {
+	var t, n int
+	if _, err := fmt.Sscanf(spec, "%d/%d", &t, &n); err != nil {
+		fmt.Println("invalid -threshold, expected t/n:", err)
+		return nil, nil
+	}
+	group, err := elgamal.GroupByName(groupName)
+	if err != nil {
+		fmt.Println(err)
+		return nil, nil
+	}
+	tpk, shares, err := elgamal.SimulateDKG(group, t, n, pointCompression)
+	if err != nil {
+		fmt.Println(err)
+		return nil, nil
+	}
+	parties := make([]elgamal.PartialDecrypter, t)
+	for i := range parties {
+		parties[i] = shares[i]
+	}
+	return tpk.PK, elgamal.NewThresholdDecryptor(tpk, parties)
+}
+
+// This function generates the identities of a target and a monitor, each
+// trusted by the other party
+func identities() (*auth.Identity, *auth.TrustStore, *auth.Identity, *auth.TrustStore) {
+	targetID, err := auth.NewIdentity("target")
+	if err != nil {
+		fmt.Println(err)
+		return nil, nil, nil, nil
+	}
+	monitorID, err := auth.NewIdentity("monitor")
+	if err != nil {
+		fmt.Println(err)
+		return nil, nil, nil, nil
+	}
+	targetTrust, monitorTrust := auth.NewTrustStore(), auth.NewTrustStore()
+	targetTrust.Add(monitorID.ID, auth.Monitor, monitorID.Public())
+	monitorTrust.Add(targetID.ID, auth.Target, targetID.Public())
+	return targetID, targetTrust, monitorID, monitorTrust
+}
@@ -0,0 +1,186 @@
+// Package workpool provides the executor that runs the per-index loops of the
+// protocol (encrypting and proving the Bloom filter bits, decoding and
+// verifying them on the monitor) on a fixed set of long-lived goroutines.
+//
+// A Pool is sized once by local policy, typically the number of CPUs, and
+// shared by every request. Work is handed out in contiguous chunks that idle
+// workers claim from a shared counter, and the calling goroutine always works
+// on its own call too. A call therefore makes progress even when every worker
+// is busy, which also makes nested calls safe.
+//
+// The pool travels to the protocol steps in the context of a call, see
+// NewContext, so that a key or query shared between callers carries no
+// executor of its own.
+package workpool
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Each call is split into about this many chunks per worker, so that a slow
+// chunk does not leave the other workers idle at the end
+const chunksPerWorker = 4
+
+// Pool is a bounded executor. It is safe for concurrent use.
+type Pool struct {
+	workers int
+	tasks   chan func()
+	quit    chan struct{}
+	once    sync.Once
+}
+
+var (
+	defaultPool *Pool
+	defaultOnce sync.Once
+)
+
+// This function starts a pool with the given number of workers, or one per
+// CPU if workers < 1
+func New(workers int) *Pool {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	p := &Pool{workers: workers, tasks: make(chan func()), quit: make(chan struct{})}
+	// the caller of ForChunks is one of the workers
+	for i := 1; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// This function returns the process-wide pool with one worker per CPU, used
+// when no pool has been set
+func Default() *Pool {
+	defaultOnce.Do(func() {
+		defaultPool = New(0)
+	})
+	return defaultPool
+}
+
+type contextKey struct{}
+
+// This function returns a copy of ctx that carries pool. The protocol steps
+// called with it run their per-index loops on pool.
+func NewContext(ctx context.Context, pool *Pool) context.Context {
+	return context.WithValue(ctx, contextKey{}, pool)
+}
+
+// This function returns the pool carried by ctx, or the default pool if ctx
+// carries none
+func FromContext(ctx context.Context) *Pool {
+	if pool, ok := ctx.Value(contextKey{}).(*Pool); ok && pool != nil {
+		return pool
+	}
+	return Default()
+}
+
+// This function returns the number of goroutines working on a call
+func (p *Pool) Workers() int {
+	return p.workers
+}
+
+// This function stops the workers once they are idle. Calls made after Close
+// run on the calling goroutine only.
+func (p *Pool) Close() {
+	p.once.Do(func() {
+		close(p.quit)
+	})
+}
+
+func (p *Pool) work() {
+	for {
+		select {
+		case task := <-p.tasks:
+			task()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// This function calls fn(i) for every i in [0, n) and returns when all calls
+// have returned. Once ctx is done no further index is started and ctx.Err()
+// is returned. A panic in fn is raised again as in ForChunks.
+func (p *Pool) ForEach(ctx context.Context, n int, fn func(i int)) error {
+	return p.ForChunks(ctx, n, func(start, end int) {
+		for i := start; i < end && ctx.Err() == nil; i++ {
+			fn(i)
+		}
+	})
+}
+
+// This function calls fn on contiguous chunks covering [0, n) and returns when
+// all calls have returned. Once ctx is done no further chunk is started and
+// ctx.Err() is returned. If fn panics, no further chunk is started either, and
+// the first panic is raised again on the calling goroutine once the running
+// chunks have returned; the workers stay alive.
+func (p *Pool) ForChunks(ctx context.Context, n int, fn func(start, end int)) error {
+
+	if n <= 0 {
+		return ctx.Err()
+	}
+
+	chunk := n / (p.workers * chunksPerWorker)
+	if chunk < 1 {
+		chunk = 1
+	}
+	numChunks := (n + chunk - 1) / chunk
+
+	var next int64
+	var failed int32
+	var panicOnce sync.Once
+	var panicked interface{}
+	run := func() {
+		defer func() {
+			if v := recover(); v != nil {
+				panicOnce.Do(func() { panicked = v })
+				atomic.StoreInt32(&failed, 1)
+			}
+		}()
+		for ctx.Err() == nil && atomic.LoadInt32(&failed) == 0 {
+			c := int(atomic.AddInt64(&next, 1) - 1)
+			if c >= numChunks {
+				return
+			}
+			end := (c + 1) * chunk
+			if end > n {
+				end = n
+			}
+			fn(c*chunk, end)
+		}
+	}
+
+	// offer the call to the workers as they become idle until the caller has
+	// claimed the last chunk, so a busy pool is never waited for
+	var wg sync.WaitGroup
+	helper := func() {
+		defer wg.Done()
+		run()
+	}
+	done := make(chan struct{})
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		for h := 1; h < p.workers && h < numChunks; h++ {
+			wg.Add(1)
+			select {
+			case p.tasks <- helper:
+			case <-done:
+				wg.Done()
+				return
+			}
+		}
+	}()
+	run()
+	close(done)
+	<-dispatched
+	wg.Wait()
+
+	if atomic.LoadInt32(&failed) != 0 {
+		panic(panicked)
+	}
+	return ctx.Err()
+}
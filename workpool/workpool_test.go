@@ -0,0 +1,249 @@
+package workpool_test
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	elgamal "bhwmonitoring-go/elgamal"
+	workpool "bhwmonitoring-go/workpool"
+)
+
+// A Bloom filter far longer than the ones of the paper, where starting a
+// goroutine per index costs the most relative to the work
+const benchBFLength = 65536
+
+// This function returns fn wrapped to record in *max the most calls of it
+// that ever ran at once
+func countRunning(running, max *int64, fn func(i int)) func(i int) {
+	return func(i int) {
+		now := atomic.AddInt64(running, 1)
+		for {
+			seen := atomic.LoadInt64(max)
+			if now <= seen || atomic.CompareAndSwapInt64(max, seen, now) {
+				break
+			}
+		}
+		fn(i)
+		atomic.AddInt64(running, -1)
+	}
+}
+
+func TestForEachCallsEveryIndexOnce(t *testing.T) {
+
+	for _, workers := range []int{1, 4} {
+		pool := workpool.New(workers)
+		for _, n := range []int{0, 1, 7, 1000} {
+			calls := make([]int32, n)
+			if err := pool.ForEach(context.Background(), n, func(i int) { atomic.AddInt32(&calls[i], 1) }); err != nil {
+				t.Fatal(err)
+			}
+			for i, c := range calls {
+				if c != 1 {
+					t.Fatalf("%d workers, n = %d: index %d called %d times", workers, n, i, c)
+				}
+			}
+		}
+		pool.Close()
+	}
+}
+
+// A call runs on the caller and at most Workers()-1 workers, however many
+// indices it has, and concurrent calls share the same workers
+func TestPoolBoundsConcurrency(t *testing.T) {
+
+	const workers, callers = 3, 4
+	pool := workpool.New(workers)
+	defer pool.Close()
+
+	var running, max int64
+	fn := countRunning(&running, &max, func(i int) { time.Sleep(100 * time.Microsecond) })
+	if err := pool.ForEach(context.Background(), 200, fn); err != nil {
+		t.Fatal(err)
+	}
+	if max > workers {
+		t.Fatalf("one call ran %d indices at once on %d workers", max, workers)
+	}
+
+	max = 0
+	var wg sync.WaitGroup
+	for c := 0; c < callers; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pool.ForEach(context.Background(), 200, fn)
+		}()
+	}
+	wg.Wait()
+	if max > workers-1+callers {
+		t.Fatalf("%d calls ran %d indices at once on %d workers", callers, max, workers)
+	}
+
+	single := workpool.New(1)
+	defer single.Close()
+	max = 0
+	if err := single.ForEach(context.Background(), 50, fn); err != nil {
+		t.Fatal(err)
+	}
+	if max != 1 {
+		t.Fatalf("a pool of one worker ran %d indices at once", max)
+	}
+}
+
+func TestForEachCancel(t *testing.T) {
+
+	pool := workpool.New(4)
+	defer pool.Close()
+
+	const n = 100000
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int64
+	err := pool.ForEach(ctx, n, func(i int) {
+		if atomic.AddInt64(&calls, 1) == 100 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if calls >= n {
+		t.Fatal("indices were started after the context was done")
+	}
+
+	calls = 0
+	if err := pool.ForEach(ctx, n, func(i int) { atomic.AddInt64(&calls, 1) }); err != context.Canceled || calls != 0 {
+		t.Fatalf("a done context started %d indices and returned %v", calls, err)
+	}
+	if err := pool.ForEach(ctx, 0, func(i int) {}); err != context.Canceled {
+		t.Fatalf("an empty call with a done context returned %v", err)
+	}
+}
+
+// A panic on a worker must reach the caller instead of crashing the process,
+// and leave the pool usable
+func TestForEachPanic(t *testing.T) {
+
+	pool := workpool.New(4)
+	defer pool.Close()
+
+	for _, at := range []int{0, 37, 999} {
+		func() {
+			defer func() {
+				if v := recover(); v != at {
+					t.Fatalf("recovered %v, want the panic at index %d", v, at)
+				}
+			}()
+			pool.ForEach(context.Background(), 1000, func(i int) {
+				if i == at {
+					panic(at)
+				}
+				time.Sleep(time.Microsecond)
+			})
+			t.Fatalf("panic at index %d did not reach the caller", at)
+		}()
+	}
+
+	var calls int64
+	if err := pool.ForEach(context.Background(), 1000, func(i int) { atomic.AddInt64(&calls, 1) }); err != nil || calls != 1000 {
+		t.Fatalf("after the panics the pool ran %d of 1000 indices: %v", calls, err)
+	}
+}
+
+func TestNewContext(t *testing.T) {
+
+	pool := workpool.New(2)
+	defer pool.Close()
+
+	if got := workpool.FromContext(context.Background()); got != workpool.Default() {
+		t.Fatal("a context without a pool does not give the default pool")
+	}
+	ctx := workpool.NewContext(context.Background(), pool)
+	if got := workpool.FromContext(ctx); got != pool {
+		t.Fatal("the context lost its pool")
+	}
+	if got := workpool.FromContext(workpool.NewContext(ctx, nil)); got != workpool.Default() {
+		t.Fatal("a nil pool does not give the default pool")
+	}
+}
+
+// This function runs fn(i) for every index with one goroutine per index and
+// at most workers running at once, as the protocol loops did before workpool
+func spawnEach(n, workers int, fn func(i int)) {
+	var wg sync.WaitGroup
+	chWorker := make(chan int, workers)
+	for i := 0; i < n; i++ {
+		chWorker <- 1
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fn(i)
+			<-chWorker
+		}(i)
+	}
+	wg.Wait()
+}
+
+// Compares the goroutine-per-index loops with Pool.ForEach on a pool of the
+// same size, for an empty body, which shows the scheduling overhead alone,
+// for encoding a ciphertext as RespDeployment decodes it and for encrypting
+// +1 or -1 as EncryptSeqWithZKP does. Run with
+//
+//	go test -run XXX -bench Executor ./workpool
+func BenchmarkExecutor(b *testing.B) {
+
+	group, err := elgamal.GroupByName("P-256")
+	if err != nil {
+		b.Fatal(err)
+	}
+	pk, _, err := elgamal.KeyGenInGroup(group, true)
+	if err != nil {
+		b.Fatal(err)
+	}
+	workers := runtime.GOMAXPROCS(0)
+	pool := workpool.New(workers)
+	defer pool.Close()
+
+	ms := make([]*big.Int, benchBFLength)
+	for i := range ms {
+		ms[i] = big.NewInt(int64(1 - 2*(i%2)))
+	}
+	// Encrypt reduces its argument in place, so it gets copies
+	cs := make([]*elgamal.Ciphertext, benchBFLength)
+	for i := range cs {
+		cs[i] = pk.Encrypt(big.NewInt(0).Set(ms[i]))
+	}
+
+	sink := make([]int, benchBFLength)
+	tasks := []struct {
+		name string
+		fn   func(i int)
+	}{
+		{"empty", func(i int) { sink[i] = i }},
+		{"encode", func(i int) { pk.Ciphertext2Bytes(cs[i], true) }},
+		{"encrypt", func(i int) { pk.Encrypt(big.NewInt(0).Set(ms[i])) }},
+	}
+	executors := []struct {
+		name string
+		run  func(fn func(i int))
+	}{
+		{"spawn", func(fn func(i int)) { spawnEach(benchBFLength, workers, fn) }},
+		{"pool", func(fn func(i int)) { pool.ForEach(context.Background(), benchBFLength, fn) }},
+	}
+
+	for _, task := range tasks {
+		for _, executor := range executors {
+			b.Run(fmt.Sprintf("%s/BFLength=%d/%s", task.name, benchBFLength, executor.name), func(b *testing.B) {
+				start := time.Now()
+				for i := 0; i < b.N; i++ {
+					executor.run(task.fn)
+				}
+				b.ReportMetric(float64(time.Since(start).Nanoseconds())/float64(b.N*benchBFLength), "ns/index")
+			})
+		}
+	}
+}